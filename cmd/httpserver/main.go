@@ -3,32 +3,80 @@ package main
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"flag"
+	"fmt"
+	"html"
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"time"
 
-	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/cache"
+	"httpfromtcp/internal/compress"
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/cors"
+	"httpfromtcp/internal/fileserver"
+	"httpfromtcp/internal/jsonapi"
+	"httpfromtcp/internal/ratelimit"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"httpfromtcp/internal/server"
+	"httpfromtcp/internal/sse"
+	"httpfromtcp/internal/vhost"
 )
 
 const port = 42069
 
 func main() {
+	assetsDir := flag.String("assets", "", "directory served under /assets/ and holding vim.mp4 (default \"assets\" next to the executable)")
+	flag.Parse()
+
+	// Resolve once at startup so the logged path shows where files come
+	// from. The default doesn't depend on where the server was started.
+	dir := *assetsDir
+	if dir == "" {
+		exe, err := os.Executable()
+		if err != nil {
+			log.Fatalf("Error locating executable: %v", err)
+		}
+		if exe, err = filepath.EvalSymlinks(exe); err != nil {
+			log.Fatalf("Error locating executable: %v", err)
+		}
+		dir = filepath.Join(filepath.Dir(exe), "assets")
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		log.Fatalf("Error resolving assets directory: %v", err)
+	}
+	log.Println("Serving assets from", dir)
+	assets := os.DirFS(dir)
+	assetsHandler := fileserver.Handler(assets, fileserver.Options{Prefix: "/assets", ListDirectories: true})
+	clock := startClock()
+	// The cache stores what httpbin answers whole and passes streamed
+	// responses, with their trailers, straight through.
+	httpbin := cache.New(cache.Options{}).Middleware()(handleHTTPBin)
+
 	handler := func(w *response.Writer, req *request.Request) {
-		target := req.RequestLine.RequestTarget
+		// Route on the normalized path so "/video?t=10" and "/a/../video"
+		// reach the same handler as "/video".
+		target := req.CleanPath()
 
 		var status response.StatusCode
 		var html string
 
 		if strings.HasPrefix(target, "/httpbin/") {
-			handleHTTPBin(w, req)
+			httpbin(w, req)
+			return
+		} else if strings.HasPrefix(target, "/assets/") {
+			assetsHandler(w, req)
 			return
 		} else {
 			switch target {
@@ -55,7 +103,24 @@ func main() {
 	</body>
 	</html>`
 			case "/video":
-				handleVideo(w, req)
+				// Only GET, and HEAD which the server presents as GET, like
+				// the rest of the assets.
+				if req.RequestLine.Method != "GET" {
+					w.Header().Set("Allow", "GET, HEAD")
+					w.SetStatus(response.StatusMethodNotAllowed)
+					w.Write([]byte(response.StatusText(response.StatusMethodNotAllowed) + "\n"))
+					return
+				}
+				fileserver.ServeFile(w, req, assets, "vim.mp4")
+				return
+			case "/upload":
+				handleUpload(w, req)
+				return
+			case "/api/echo":
+				handleEcho(w, req)
+				return
+			case "/events":
+				handleEvents(w, req, clock)
 				return
 			default:
 				status = response.StatusOk
@@ -71,16 +136,38 @@ func main() {
 			}
 		}
 
-		body := []byte(html)
-		headers := response.GetDefaultHeaders(len(body))
-		headers["content-type"] = "text/html"
-
-		w.WriteStatusLine(status)
-		w.WriteHeaders(headers)
-		w.WriteBody(body)
+		w.Header().Set("Content-Type", "text/html")
+		w.SetStatus(status)
+		w.Write([]byte(html))
 	}
 
-	srv, err := server.Serve(port, handler)
+	// The demo site answers any host; assets.localhost, which resolves to
+	// loopback, serves the assets directory at its root.
+	sites := vhost.Handler(vhost.Options{
+		Hosts: map[string]server.Handler{
+			"assets.localhost": fileserver.Handler(assets, fileserver.Options{ListDirectories: true}),
+		},
+		Default: handler,
+	})
+
+	srv, err := server.ServeWithConfig(port, server.Chain(sites,
+		// 20 requests a second per client, with bursts of up to 20.
+		ratelimit.Middleware(ratelimit.Options{Limiter: ratelimit.NewTokenBucket(20, time.Second, 0)}),
+		// Let local frontends call the API; preflights are answered here
+		// rather than falling through to the HTML pages.
+		cors.Middleware(cors.Options{
+			AllowedOrigins: []string{"http://localhost:*", "http://127.0.0.1:*"},
+			AllowedMethods: []string{"GET", "HEAD", "POST"},
+			AllowedHeaders: []string{"Content-Type"},
+			MaxAge:         10 * time.Minute,
+		}),
+		// Validators for the HTML pages; they run before compression, which
+		// weakens the tags of encoded responses.
+		conditional.Middleware(),
+		compress.Middleware(compress.Options{}),
+	), server.Config{
+		DecompressRequests: true,
+	})
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
@@ -96,34 +183,57 @@ func main() {
 // helper function
 func handleHTTPBin(w *response.Writer, req *request.Request) {
 
-	path := req.RequestLine.RequestTarget
-	trimmed := strings.TrimPrefix(path, "/httpbin")
-	url := "https://httpbin.org" + trimmed
+	// Forward the same cleaned path the router matched on, so "/x/../httpbin/get"
+	// reaches httpbin as "/get". URL.String escapes it again.
+	upstreamURL := url.URL{
+		Scheme:   "https",
+		Host:     "httpbin.org",
+		Path:     strings.TrimPrefix(req.CleanPath(), "/httpbin"),
+		RawQuery: req.Target().RawQuery,
+	}
 
 	// Debugging
-	log.Println("proxying to:", url)
+	log.Println("proxying to:", upstreamURL.String())
 
-	resp, err := http.Get(url)
+	// Tie the upstream call to the client's request so it stops when the
+	// client leaves or the server shuts down.
+	upstream, err := http.NewRequestWithContext(req.Context(), http.MethodGet, upstreamURL.String(), nil)
+	if err != nil {
+		w.SetStatus(response.StatusBadRequest)
+		w.Write([]byte("bad upstream URL\n"))
+		return
+	}
+	// Pass validators through so httpbin's /etag and /cache endpoints can
+	// answer revalidations, from the client or the cache, with a 304.
+	for _, name := range []string{"If-None-Match", "If-Modified-Since"} {
+		if v := req.Headers.Get(name); v != "" {
+			upstream.Header.Set(name, v)
+		}
+	}
+	resp, err := http.DefaultClient.Do(upstream)
 	if err != nil {
 		// write a 502 or 500 back to the client
-		w.WriteStatusLine(response.StatusInternalServerError)
-		body := []byte("Error contacting httpbin.org")
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+		w.SetStatus(response.StatusInternalServerError)
+		w.Write([]byte("Error contacting httpbin.org"))
 		return
 	}
 	defer resp.Body.Close()
 
-	w.WriteStatusLine(response.StatusOk)
-
-	// start from default, but we won't use Content-Length
-	respHeaders := response.GetDefaultHeaders(0)
-	delete(respHeaders, "content-length")
-	respHeaders["transfer-encoding"] = "chunked"
-	respHeaders["content-type"] = "application/json"
-	respHeaders["trailer"] = "X-Content-SHA256, X-Content-Length"
+	for _, name := range []string{"Cache-Control", "ETag", "Last-Modified", "Expires", "Vary", "Date"} {
+		if v := resp.Header.Get(name); v != "" {
+			w.Header().Set(name, v)
+		}
+	}
+	w.SetStatus(response.StatusCode(resp.StatusCode))
+	if resp.StatusCode == http.StatusNotModified {
+		return
+	}
 
-	w.WriteHeaders(respHeaders)
+	// The writer switches to chunked encoding for declared trailers and
+	// sends them once the handler returns.
+	w.Header().Set("Content-Type", "application/json")
+	w.DeclareTrailer("X-Content-SHA256", "X-Content-Length")
+	w.EnableContentDigest()
 
 	buf := make([]byte, 1024)
 	var fullBody []byte
@@ -134,8 +244,11 @@ func handleHTTPBin(w *response.Writer, req *request.Request) {
 			chunk := buf[:n]
 			fullBody = append(fullBody, chunk...)
 
-			_, writeErr := w.WriteChunkedBody(chunk)
-			if writeErr != nil {
+			if _, writeErr := w.Write(chunk); writeErr != nil {
+				return
+			}
+			// Push each upstream read to the client as it arrives.
+			if flushErr := w.Flush(); flushErr != nil {
 				return
 			}
 		}
@@ -154,33 +267,146 @@ func handleHTTPBin(w *response.Writer, req *request.Request) {
 	hashHex := hex.EncodeToString(sum[:])
 	contentLen := len(fullBody)
 
-	trailers := headers.NewHeaders()
-	trailers["X-Content-SHA256"] = hashHex
-	trailers["X-Content-Length"] = strconv.Itoa(contentLen)
-
 	log.Println("writing trailers:", hashHex, contentLen)
 
-	w.WriteChunkedBodyDone()
-	w.WriteTrailers(trailers)
+	w.SetTrailer("X-Content-SHA256", hashHex)
+	w.SetTrailer("X-Content-Length", strconv.Itoa(contentLen))
 }
 
-func handleVideo(w *response.Writer, req *request.Request) {
-	// default video
-	video, err := os.ReadFile("assets/vim.mp4")
+const uploadForm = `<html>
+	<head>
+		<title>Upload</title>
+	</head>
+	<body>
+		<h1>Upload a file</h1>
+		<form method="POST" action="/upload" enctype="multipart/form-data">
+			<input type="text" name="note">
+			<input type="file" name="file" multiple>
+			<button type="submit">Upload</button>
+		</form>
+	</body>
+	</html>`
 
+// handleUpload shows an upload form on GET and reports what was received
+// on POST.
+func handleUpload(w *response.Writer, req *request.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	if req.RequestLine.Method != "POST" {
+		w.Write([]byte(uploadForm))
+		return
+	}
+
+	form, err := req.ParseMultipartForm(request.FormLimits{})
 	if err != nil {
-		log.Println("Error reading video file")
-		w.WriteStatusLine(response.StatusInternalServerError)
-		body := []byte("Error reading video file")
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+		w.SetStatus(response.StatusBadRequest)
+		fmt.Fprintf(w, "<html><body><p>Bad upload: %s</p></body></html>", html.EscapeString(err.Error()))
 		return
 	}
+	defer form.RemoveAll()
+
+	fmt.Fprint(w, "<html>\n<body>\n<h1>Upload received</h1>\n<ul>\n")
+	for name, values := range form.Values {
+		for _, v := range values {
+			fmt.Fprintf(w, "<li>%s = %s</li>\n", html.EscapeString(name), html.EscapeString(v))
+		}
+	}
+	for name, files := range form.Files {
+		for _, f := range files {
+			fmt.Fprintf(w, "<li>%s: %s (%s, %d bytes)</li>\n",
+				html.EscapeString(name), html.EscapeString(f.Filename), html.EscapeString(f.ContentType), f.Size)
+		}
+	}
+	fmt.Fprint(w, "</ul>\n</body>\n</html>\n")
+}
+
+// handleEcho decodes a JSON message and sends it back with its length.
+func handleEcho(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		jsonapi.WriteProblem(w, jsonapi.NewProblem(response.StatusMethodNotAllowed, "use POST"))
+		return
+	}
+	var in struct {
+		Message string `json:"message"`
+	}
+	if err := jsonapi.Decode(req, &in); err != nil {
+		jsonapi.WriteError(w, err)
+		return
+	}
+	jsonapi.Write(w, response.StatusOk, map[string]any{
+		"message": in.Message,
+		"length":  len(in.Message),
+	})
+}
+
+// clockFeed publishes the time once a second to /events subscribers.
+type clockFeed struct {
+	mu      sync.Mutex
+	history *sse.ReplayBuffer
+	subs    map[chan sse.Event]bool
+}
+
+// startClock starts the feed behind /events.
+func startClock() *clockFeed {
+	c := &clockFeed{history: sse.NewReplayBuffer(30), subs: map[chan sse.Event]bool{}}
+	go func() {
+		for now := range time.Tick(time.Second) {
+			ev := c.history.Add(sse.Event{Event: "tick", Data: now.UTC().Format(time.RFC3339)})
+			c.mu.Lock()
+			for ch := range c.subs {
+				select {
+				case ch <- ev:
+				default: // slow subscriber; it can catch up on reconnect
+				}
+			}
+			c.mu.Unlock()
+		}
+	}()
+	return c
+}
 
-	respHeaders := response.GetDefaultHeaders(len(video))
-	respHeaders["content-type"] = "video/mp4"
+// handleEvents streams clock ticks, replaying the ones a reconnecting
+// client missed.
+func handleEvents(w *response.Writer, req *request.Request, c *clockFeed) {
+	ch := make(chan sse.Event, 8)
+	c.mu.Lock()
+	c.subs[ch] = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.subs, ch)
+		c.mu.Unlock()
+	}()
 
-	w.WriteStatusLine(response.StatusOk)
-	w.WriteHeaders(respHeaders)
-	w.WriteBody(video)
+	stream, err := sse.NewStream(w, req)
+	if err != nil {
+		return
+	}
+	defer stream.Close()
+	// Subscribing before replaying leaves no gap, but a tick can then arrive
+	// both ways; remember the last one replayed and skip up to it.
+	lastSent := 0
+	if lastID := stream.LastEventID(); lastID != "" {
+		missed, _ := c.history.Since(lastID)
+		for _, ev := range missed {
+			if stream.Send(ev) != nil {
+				return
+			}
+			lastSent, _ = strconv.Atoi(ev.ID)
+		}
+	}
+	stream.StartHeartbeat(15 * time.Second)
+	for {
+		select {
+		case ev := <-ch:
+			if id, _ := strconv.Atoi(ev.ID); id <= lastSent {
+				continue
+			}
+			if stream.Send(ev) != nil {
+				return
+			}
+		case <-stream.Done():
+			return
+		}
+	}
 }
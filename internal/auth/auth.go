@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	// Scheme is how the caller authenticated: "basic", "bearer" or
+	// "apikey".
+	Scheme string
+	// Name identifies the caller: the Basic user name, the token's "sub"
+	// claim or the name an API key was registered under.
+	Name string
+	// Claims holds a bearer token's claims, nil for other schemes.
+	Claims map[string]any
+}
+
+type principalKey struct{}
+
+// FromRequest returns the principal an auth middleware attached to req.
+func FromRequest(req *request.Request) (*Principal, bool) {
+	p, ok := req.Context().Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// setPrincipal attaches p to req's context for the handlers that follow.
+func setPrincipal(req *request.Request, p *Principal) {
+	req.SetContext(context.WithValue(req.Context(), principalKey{}, p))
+}
+
+// unauthorized answers with 401 and the given WWW-Authenticate challenge.
+func unauthorized(w *response.Writer, challenge string) {
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.SetStatus(response.StatusUnauthorized)
+	w.Write([]byte(response.StatusText(response.StatusUnauthorized) + "\n"))
+}
+
+// credentials splits an Authorization header into its scheme, matched
+// case-insensitively, and what follows.
+func credentials(req *request.Request, scheme string) (string, bool) {
+	v := req.Headers.Get("Authorization")
+	prefix, rest, found := strings.Cut(v, " ")
+	if !found || !strings.EqualFold(prefix, scheme) {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// quote renders s as a quoted-string for a challenge parameter.
+func quote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// serve starts a server answering with the authenticated principal behind
+// mw, and returns a function making a GET with the given header lines.
+func serve(t *testing.T, mw server.Middleware) func(lines ...string) string {
+	t.Helper()
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		p, ok := FromRequest(req)
+		if !ok {
+			_, _ = w.Write([]byte("no principal\n"))
+			return
+		}
+		_, _ = fmt.Fprintf(w, "%s:%s\n", p.Scheme, p.Name)
+	}, mw)
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return func(lines ...string) string {
+		conn, err := net.DialTimeout("tcp", s.Addr().String(), 2*time.Second)
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n"+strings.Join(lines, "")+"\r\n")
+		raw, err := io.ReadAll(bufio.NewReader(conn))
+		require.NoError(t, err)
+		return string(raw)
+	}
+}
+
+func basicHeader(user, password string) string {
+	return "Authorization: Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password)) + "\r\n"
+}
+
+func TestBasic(t *testing.T) {
+	get := serve(t, Basic("admin area", Users{"alice": "s3cret"}))
+
+	// Test: Missing or wrong credentials get a Basic challenge
+	for _, lines := range [][]string{
+		nil,
+		{basicHeader("alice", "wrong")},
+		{basicHeader("bob", "s3cret")},
+		{"Authorization: Basic not-base64!\r\n"},
+		{"Authorization: Bearer abc\r\n"},
+	} {
+		resp := get(lines...)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"), resp)
+		assert.Contains(t, resp, "www-authenticate: Basic realm=\"admin area\", charset=\"UTF-8\"\r\n")
+	}
+
+	// Test: Good credentials attach the principal
+	resp := get(basicHeader("alice", "s3cret"))
+	assert.True(t, strings.HasSuffix(resp, "basic:alice\n"), resp)
+	resp = get("Authorization: basic " + base64.StdEncoding.EncodeToString([]byte("alice:s3cret")) + "\r\n")
+	assert.True(t, strings.HasSuffix(resp, "basic:alice\n"), resp)
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	require.NoError(t, err)
+	// htpasswd writes the $2y$ prefix, which bcrypt treats like $2a$.
+	y := "$2y$" + string(hash[4:])
+
+	// Test: bcrypt entries verify; comments and blank lines are skipped
+	h, err := ParseHtpasswd(strings.NewReader("# users\n\nalice:" + string(hash) + "\nbob:" + y + "\n"))
+	require.NoError(t, err)
+	assert.True(t, h.Verify("alice", "hunter2"))
+	assert.True(t, h.Verify("bob", "hunter2"))
+	assert.False(t, h.Verify("alice", "hunter3"))
+	assert.False(t, h.Verify("carol", "hunter2"))
+
+	// Test: Other hash types are refused
+	_, err = ParseHtpasswd(strings.NewReader("alice:$apr1$abc$def\n"))
+	assert.ErrorContains(t, err, "only bcrypt")
+	_, err = ParseHtpasswd(strings.NewReader("alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"))
+	assert.Error(t, err)
+	_, err = ParseHtpasswd(strings.NewReader("no-colon\n"))
+	assert.Error(t, err)
+
+	// Test: It works as the Basic user store
+	get := serve(t, Basic("files", h))
+	assert.True(t, strings.HasSuffix(get(basicHeader("bob", "hunter2")), "basic:bob\n"))
+}
+
+// sign builds a JWT with the given header and claims.
+func sign(t *testing.T, header, claims map[string]any, signer func([]byte) []byte) string {
+	t.Helper()
+	h, err := json.Marshal(header)
+	require.NoError(t, err)
+	c, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signer([]byte(signed)))
+}
+
+func TestJWT(t *testing.T) {
+	key := []byte("shared secret")
+	hs256 := func(b []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(b)
+		return mac.Sum(nil)
+	}
+	now := time.Unix(1700000000, 0)
+	v := NewJWTVerifier(JWTOptions{HMACKey: key, Issuer: "https://issuer.example", Audience: "api", Leeway: time.Minute})
+	v.now = func() time.Time { return now }
+	good := map[string]any{"sub": "alice", "iss": "https://issuer.example", "aud": []string{"other", "api"}, "exp": now.Add(time.Hour).Unix()}
+	with := func(k string, val any) map[string]any {
+		c := map[string]any{}
+		for k, v := range good {
+			c[k] = v
+		}
+		if val == nil {
+			delete(c, k)
+		} else {
+			c[k] = val
+		}
+		return c
+	}
+	hs := map[string]any{"alg": "HS256", "typ": "JWT"}
+
+	// Test: A valid HS256 token
+	claims, err := v.Verify(sign(t, hs, good, hs256))
+	require.NoError(t, err)
+	assert.Equal(t, "alice", claims["sub"])
+
+	// Test: Rejected tokens
+	rejected := map[string]string{
+		"expired":        sign(t, hs, with("exp", now.Add(-2*time.Minute).Unix()), hs256),
+		"not yet valid":  sign(t, hs, with("nbf", now.Add(2*time.Minute).Unix()), hs256),
+		"wrong issuer":   sign(t, hs, with("iss", "https://evil.example"), hs256),
+		"wrong audience": sign(t, hs, with("aud", "other"), hs256),
+		"no audience":    sign(t, hs, with("aud", nil), hs256),
+		"bad exp":        sign(t, hs, with("exp", "tomorrow"), hs256),
+		"bad signature":  sign(t, hs, good, func(b []byte) []byte { return hs256(append(b, 'x')) }),
+		"alg none":       sign(t, map[string]any{"alg": "none"}, good, func([]byte) []byte { return nil }),
+		"wrong alg":      sign(t, map[string]any{"alg": "ES256"}, good, hs256),
+		"two parts":      "abc.def",
+		"bad base64":     "abc.def.!!!",
+	}
+	for name, token := range rejected {
+		_, err := v.Verify(token)
+		assert.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+
+	// Test: Expiry within the leeway is still accepted
+	_, err = v.Verify(sign(t, hs, with("exp", now.Add(-30*time.Second).Unix()), hs256))
+	assert.NoError(t, err)
+
+	// Test: ES256 with a P-256 key
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	es256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		r, s, err := ecdsa.Sign(rand.Reader, priv, digest[:])
+		require.NoError(t, err)
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig
+	}
+	ev := NewJWTVerifier(JWTOptions{ECDSAKey: &priv.PublicKey})
+	claims, err = ev.Verify(sign(t, map[string]any{"alg": "ES256"}, map[string]any{"sub": "bob"}, es256))
+	require.NoError(t, err)
+	assert.Equal(t, "bob", claims["sub"])
+	_, err = ev.Verify(sign(t, hs, map[string]any{"sub": "bob"}, hs256))
+	assert.ErrorIs(t, err, ErrInvalidToken, "an HS256 token can't pass for ES256")
+
+	// Test: The Bearer middleware challenges per RFC 6750
+	get := serve(t, Bearer("api", v))
+	resp := get()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"), resp)
+	assert.Contains(t, resp, "www-authenticate: Bearer realm=\"api\"\r\n")
+	resp = get("Authorization: Bearer " + rejected["expired"] + "\r\n")
+	assert.Contains(t, resp, "www-authenticate: Bearer realm=\"api\", error=\"invalid_token\", error_description=\"expired\"\r\n")
+	resp = get("Authorization: Bearer " + sign(t, hs, good, hs256) + "\r\n")
+	assert.True(t, strings.HasSuffix(resp, "bearer:alice\n"), resp)
+}
+
+func TestAPIKey(t *testing.T) {
+	get := serve(t, APIKey(APIKeyOptions{Realm: "api", Keys: map[string]string{"k-123": "ci"}}))
+
+	// Test: Missing and unknown keys are refused with a challenge naming
+	// the header
+	for _, lines := range [][]string{nil, {"X-API-Key: k-124\r\n"}, {"X-API-Key: \r\n"}} {
+		resp := get(lines...)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"), resp)
+		assert.Contains(t, resp, "www-authenticate: APIKey realm=\"api\", header=\"X-API-Key\"\r\n")
+	}
+
+	// Test: A known key attaches its owner
+	resp := get("X-Api-Key: k-123\r\n")
+	assert.True(t, strings.HasSuffix(resp, "apikey:ci\n"), resp)
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// UserStore checks a user name and password.
+type UserStore interface {
+	Verify(user, password string) bool
+}
+
+// Users is a UserStore of plain-text passwords, for tests and small
+// setups. Passwords are compared in constant time.
+type Users map[string]string
+
+// Verify reports whether password is user's. Unknown users still cost a
+// comparison, so timing doesn't reveal which names exist.
+func (u Users) Verify(user, password string) bool {
+	want, ok := u[user]
+	// Comparing digests keeps the time independent of the lengths too.
+	a := sha256.Sum256([]byte(password))
+	b := sha256.Sum256([]byte(want))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1 && ok
+}
+
+// Htpasswd is a UserStore read from an Apache htpasswd file. Only bcrypt
+// entries, as written by "htpasswd -B", are supported.
+type Htpasswd struct {
+	hashes map[string][]byte
+}
+
+// dummyHash is checked for unknown users so they take as long as known
+// ones. It is made on first use since bcrypt is slow on purpose.
+var dummyHash = sync.OnceValue(func() []byte {
+	h, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return h
+})
+
+// LoadHtpasswd reads an htpasswd file.
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ParseHtpasswd(f)
+}
+
+// ParseHtpasswd reads "user:hash" lines, skipping blank lines and comments.
+// A line with any other kind of hash is an error rather than a user who
+// can never log in.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	h := &Htpasswd{hashes: map[string][]byte{}}
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found || user == "" {
+			return nil, fmt.Errorf("htpasswd line %d: missing user name", n)
+		}
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return nil, fmt.Errorf("htpasswd line %d: user %q: only bcrypt hashes are supported", n, user)
+		}
+		h.hashes[user] = []byte(hash)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// Verify reports whether password matches user's bcrypt hash.
+func (h *Htpasswd) Verify(user, password string) bool {
+	hash, ok := h.hashes[user]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
+
+// Basic requires HTTP Basic credentials (RFC 7617) that users accepts, and
+// answers anything else with 401 and a challenge for realm.
+func Basic(realm string, users UserStore) server.Middleware {
+	challenge := "Basic realm=" + quote(realm) + `, charset="UTF-8"`
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			user, password, ok := basicCredentials(req)
+			if !ok || !users.Verify(user, password) {
+				unauthorized(w, challenge)
+				return
+			}
+			setPrincipal(req, &Principal{Scheme: "basic", Name: user})
+			next(w, req)
+		}
+	}
+}
+
+func basicCredentials(req *request.Request) (string, string, bool) {
+	encoded, ok := credentials(req, "Basic")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
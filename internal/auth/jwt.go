@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// ErrInvalidToken is returned by JWTVerifier.Verify for a token that is
+// malformed, wrongly signed, expired or meant for someone else.
+var ErrInvalidToken = errors.New("invalid token")
+
+// JWTOptions configures a JWTVerifier. Exactly one of HMACKey and ECDSAKey
+// should be set; the token's "alg" must match it.
+type JWTOptions struct {
+	// HMACKey verifies HS256 tokens.
+	HMACKey []byte
+	// ECDSAKey verifies ES256 tokens. It must be a P-256 key.
+	ECDSAKey *ecdsa.PublicKey
+	// Issuer and Audience, when set, must match the "iss" and "aud"
+	// claims.
+	Issuer   string
+	Audience string
+	// Leeway allows for clock skew when checking "exp" and "nbf".
+	Leeway time.Duration
+}
+
+// JWTVerifier checks JSON Web Tokens (RFC 7519) signed with HS256 or ES256.
+type JWTVerifier struct {
+	opts JWTOptions
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// NewJWTVerifier returns a verifier for tokens matching opts.
+func NewJWTVerifier(opts JWTOptions) *JWTVerifier {
+	return &JWTVerifier{opts: opts, now: time.Now}
+}
+
+// Verify checks token's signature and registered claims and returns its
+// claims. Errors wrap ErrInvalidToken.
+func (v *JWTVerifier) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWS compact token", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrInvalidToken, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %v", ErrInvalidToken, err)
+	}
+	// The algorithm is fixed by the key we hold, never chosen by the
+	// token, so "none" and key confusion are ruled out.
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case v.opts.HMACKey != nil && header.Alg == "HS256":
+		mac := hmac.New(sha256.New, v.opts.HMACKey)
+		mac.Write(signed)
+		if !hmac.Equal(sig, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	case v.opts.ECDSAKey != nil && header.Alg == "ES256":
+		// ES256 signatures are r and s as fixed-size big-endian integers
+		// (RFC 7518 section 3.4), not ASN.1.
+		if len(sig) != 64 {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		digest := sha256.Sum256(signed)
+		if !ecdsa.Verify(v.opts.ECDSAKey, digest[:], r, s) {
+			return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unexpected algorithm %q", ErrInvalidToken, header.Alg)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: claims: %v", ErrInvalidToken, err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (v *JWTVerifier) checkClaims(claims map[string]any) error {
+	now := v.now()
+	if exp, ok, err := numericDate(claims, "exp"); err != nil {
+		return err
+	} else if ok && !now.Before(exp.Add(v.opts.Leeway)) {
+		return fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok, err := numericDate(claims, "nbf"); err != nil {
+		return err
+	} else if ok && now.Add(v.opts.Leeway).Before(nbf) {
+		return fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	if v.opts.Issuer != "" && claims["iss"] != v.opts.Issuer {
+		return fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if v.opts.Audience != "" && !hasAudience(claims["aud"], v.opts.Audience) {
+		return fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	return nil
+}
+
+// numericDate reads a NumericDate claim: seconds since the epoch.
+func numericDate(claims map[string]any, name string) (time.Time, bool, error) {
+	raw, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	secs, ok := raw.(float64)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %q is not a number", ErrInvalidToken, name)
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))), true, nil
+}
+
+// hasAudience reports whether aud, a string or array of strings, names want.
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, a := range aud {
+			if a == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(seg string, v any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// Bearer requires an "Authorization: Bearer" token that v accepts. Failures
+// get 401 with the challenge from RFC 6750: bare when no token was sent,
+// with error="invalid_token" when one was rejected.
+func Bearer(realm string, v *JWTVerifier) server.Middleware {
+	challenge := "Bearer realm=" + quote(realm)
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			token, ok := credentials(req, "Bearer")
+			if !ok || token == "" {
+				unauthorized(w, challenge)
+				return
+			}
+			claims, err := v.Verify(token)
+			if err != nil {
+				unauthorized(w, challenge+`, error="invalid_token", error_description=`+quote(strings.TrimPrefix(err.Error(), ErrInvalidToken.Error()+": ")))
+				return
+			}
+			name, _ := claims["sub"].(string)
+			setPrincipal(req, &Principal{Scheme: "bearer", Name: name, Claims: claims})
+			next(w, req)
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package cache
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultMaxEntrySize is the largest body stored when Options.MaxEntrySize
+// is zero.
+const DefaultMaxEntrySize = 1 << 20
+
+// DefaultName identifies the cache in Cache-Status when Options.Name is
+// empty.
+const DefaultName = "httpfromtcp"
+
+// hopByHop lists fields describing one connection rather than the
+// response, which are never stored.
+var hopByHop = []string{"connection", "keep-alive", "transfer-encoding", "trailer", "content-length"}
+
+// Options configures a Cache.
+type Options struct {
+	// Store holds the responses. Defaults to a MemoryStore of
+	// DefaultMaxBytes; a DiskStore keeps them across restarts.
+	Store Store
+	// MaxEntrySize is the largest body worth storing. Defaults to
+	// DefaultMaxEntrySize.
+	MaxEntrySize int
+	// Name identifies the cache in the Cache-Status header (RFC 9211).
+	// Defaults to DefaultName.
+	Name string
+}
+
+// Cache is a shared HTTP cache (RFC 9111) in front of handlers, such as
+// the httpbin proxy.
+type Cache struct {
+	store Store
+	opts  Options
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// refreshing holds the keys being revalidated in the background, so a
+	// burst of stale hits starts one refresh rather than many.
+	refreshing map[string]bool
+	background sync.WaitGroup
+}
+
+// New returns a Cache with the given options.
+func New(opts Options) *Cache {
+	if opts.Store == nil {
+		opts.Store = NewMemoryStore(DefaultMaxBytes)
+	}
+	if opts.MaxEntrySize == 0 {
+		opts.MaxEntrySize = DefaultMaxEntrySize
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultName
+	}
+	return &Cache{store: opts.Store, opts: opts, now: time.Now, refreshing: map[string]bool{}}
+}
+
+// Middleware answers GET requests from the cache when it can and stores
+// the handler's responses when they allow it. Stale responses are
+// revalidated with a conditional request to the handler, which should
+// honour If-None-Match and If-Modified-Since; within a response's
+// stale-while-revalidate window the stale copy is served at once and
+// refreshed in the background. The client's own conditional headers are
+// answered by the cache. A response the handler streams, chunked, is passed
+// on to the client as it arrives, trailers included, and isn't stored.
+//
+// Unsafe methods go straight to the handler and, if it succeeds, evict the
+// target URI.
+func (c *Cache) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			switch req.RequestLine.Method {
+			case "GET":
+				c.serveGet(next, w, req)
+			case "POST", "PUT", "PATCH", "DELETE":
+				next(w, req)
+				// Status is the code actually sent, whichever API the
+				// handler used.
+				if w.Status() < 400 {
+					c.store.Delete(cacheKey(req))
+				}
+			default:
+				next(w, req)
+			}
+		}
+	}
+}
+
+// cacheKey identifies the target resource: method, host, the path with dot
+// segments resolved, as the router sees it, and the query. "/a/../doc"
+// and "/doc" are the same resource, and so are an absolute-form target
+// and its origin form.
+func cacheKey(req *request.Request) string {
+	target := req.Target()
+	host := target.Host
+	if host == "" {
+		host = req.Headers.Get("Host")
+	}
+	key := "GET " + strings.ToLower(host) + " " + req.CleanPath()
+	if target.RawQuery != "" {
+		key += "?" + target.RawQuery
+	}
+	return key
+}
+
+func (c *Cache) serveGet(next server.Handler, w *response.Writer, req *request.Request) {
+	reqCC := ParseCacheControl(req.Headers.Get("Cache-Control"))
+	if req.Headers.Get("Pragma") == "no-cache" && req.Headers.Get("Cache-Control") == "" {
+		reqCC["no-cache"] = ""
+	}
+	key := cacheKey(req)
+	if reqCC.Has("no-store") {
+		e, err := c.fetchOrStream(next, w, req, nil, "fwd=bypass")
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if e == nil {
+			return
+		}
+		c.send(w, req, e, "fwd=bypass")
+		return
+	}
+
+	stored, variants := c.lookup(key, req)
+	if stored == nil {
+		fwd := "fwd=uri-miss"
+		if len(variants) > 0 {
+			fwd = "fwd=vary-miss"
+		}
+		c.fetchAndStore(next, w, req, reqCC, key, variants, fwd)
+		return
+	}
+
+	now := c.now()
+	age := stored.age(now)
+	lifetime := stored.lifetime()
+	d := stored.directives()
+	fresh := age < lifetime && !d.Has("no-cache")
+	if maxAge, ok := reqCC.Seconds("max-age"); ok && age > maxAge {
+		fresh = false
+	}
+	switch {
+	case fresh && !reqCC.Has("no-cache"):
+		c.send(w, req, stored, fmt.Sprintf("hit; ttl=%d", int((lifetime-age)/time.Second)))
+		return
+	case !reqCC.Has("no-cache") && c.servableStale(stored, age, lifetime, "stale-while-revalidate"):
+		c.send(w, req, stored, fmt.Sprintf("hit; ttl=%d", int((lifetime-age)/time.Second)))
+		c.refresh(next, req, key, stored)
+		return
+	}
+
+	fwd := "fwd=stale"
+	if reqCC.Has("no-cache") {
+		fwd = "fwd=request"
+	}
+	e, err := c.fetchOrStream(next, w, req, stored, fwd)
+	if err == nil && e == nil {
+		return
+	}
+	switch {
+	case err != nil || e.Status >= 500:
+		if c.servableStale(stored, age, lifetime, "stale-if-error") {
+			c.send(w, req, stored, fmt.Sprintf("hit; ttl=%d; detail=stale-if-error", int((lifetime-age)/time.Second)))
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	case e.Status == int(response.StatusNotModified):
+		updated := c.freshen(stored, e)
+		c.put(key, req, updated, variants)
+		c.send(w, req, updated, fwd+"; fwd-status=304")
+		return
+	}
+	status := fmt.Sprintf("%s; fwd-status=%d", fwd, e.Status)
+	if c.storable(req, e, reqCC) {
+		c.put(key, req, e, variants)
+		status += "; stored"
+	}
+	c.send(w, req, e, status)
+}
+
+// servableStale reports whether a stale entry may still be used under the
+// named directive, stale-while-revalidate or stale-if-error.
+func (c *Cache) servableStale(e *Entry, age, lifetime time.Duration, directive string) bool {
+	d := e.directives()
+	if d.Has("no-cache") || d.Has("must-revalidate") || d.Has("proxy-revalidate") || d.Has("s-maxage") {
+		return false
+	}
+	window, ok := d.Seconds(directive)
+	return ok && age < lifetime+window
+}
+
+// lookup returns the stored variant selected by req, if any, and all
+// variants stored for key.
+func (c *Cache) lookup(key string, req *request.Request) (*Entry, []*Entry) {
+	variants, ok := c.store.Get(key)
+	if !ok {
+		return nil, nil
+	}
+	for _, e := range variants {
+		if e.matches(req) {
+			return e, variants
+		}
+	}
+	return nil, variants
+}
+
+func (c *Cache) fetchAndStore(next server.Handler, w *response.Writer, req *request.Request, reqCC Directives, key string, variants []*Entry, fwd string) {
+	e, err := c.fetchOrStream(next, w, req, nil, fwd)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if e == nil {
+		return
+	}
+	status := fmt.Sprintf("%s; fwd-status=%d", fwd, e.Status)
+	if c.storable(req, e, reqCC) {
+		c.put(key, req, e, variants)
+		status += "; stored"
+	}
+	c.send(w, req, e, status)
+}
+
+func (c *Cache) storable(req *request.Request, e *Entry, reqCC Directives) bool {
+	return len(e.Body) <= c.opts.MaxEntrySize && storable(req, e, reqCC)
+}
+
+// put stores e as req's variant of key, replacing the variant it selects.
+func (c *Cache) put(key string, req *request.Request, e *Entry, variants []*Entry) {
+	e.Vary = varyValues(req, varyNames(e.Header))
+	kept := []*Entry{e}
+	for _, v := range variants {
+		if !v.matches(req) {
+			kept = append(kept, v)
+		}
+	}
+	c.store.Put(key, kept)
+}
+
+// freshen returns a copy of stored updated with the header fields of a 304
+// received for it (RFC 9111 section 4.3.4).
+func (c *Cache) freshen(stored, notModified *Entry) *Entry {
+	updated := *stored
+	updated.Header = headers.NewHeaders()
+	for k, v := range stored.Header {
+		updated.Header[k] = v
+	}
+	for k, v := range notModified.Header {
+		if k != "content-length" && k != "content-type" && k != "content-encoding" {
+			updated.Header[k] = v
+		}
+	}
+	updated.RequestTime = notModified.RequestTime
+	updated.ResponseTime = notModified.ResponseTime
+	return &updated
+}
+
+// refresh revalidates key in the background, unless that is already under
+// way.
+func (c *Cache) refresh(next server.Handler, req *request.Request, key string, stored *Entry) {
+	// The refresh must outlive the client's request, so it gets its own
+	// copy detached from the client's context.
+	bg, err := cloneRequest(req, nil)
+	if err != nil {
+		log.Printf("cache: refreshing %s: %v\n", key, err)
+		return
+	}
+
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+	c.background.Add(1)
+	go func() {
+		defer c.background.Done()
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+		e, err := c.fetch(next, bg, stored)
+		if err != nil {
+			log.Printf("cache: refreshing %s: %v\n", key, err)
+			return
+		}
+		_, variants := c.lookup(key, bg)
+		switch {
+		case e.Status == int(response.StatusNotModified):
+			c.put(key, bg, c.freshen(stored, e), variants)
+		case c.storable(bg, e, Directives{}):
+			c.put(key, bg, e, variants)
+		}
+	}()
+}
+
+// upstream is a handler response being read by the cache as the handler
+// writes it.
+type upstream struct {
+	resp        *http.Response
+	requestTime time.Time
+	pr          *io.PipeReader
+	done        chan struct{}
+}
+
+// open runs next on a copy of req and returns once the status line and
+// headers it wrote have arrived. The client's conditional headers are left
+// out, so the handler answers in full; when revalidating stored, its
+// validators are sent instead. The caller must close the upstream.
+func (c *Cache) open(next server.Handler, req *request.Request, stored *Entry) (*upstream, error) {
+	var validators map[string]string
+	if stored != nil {
+		validators = map[string]string{}
+		if etag := stored.Header.Get("ETag"); etag != "" {
+			validators["If-None-Match"] = etag
+		}
+		if lm := stored.Header.Get("Last-Modified"); lm != "" {
+			validators["If-Modified-Since"] = lm
+		}
+	}
+	inner, err := cloneRequest(req, validators)
+	if err != nil {
+		return nil, err
+	}
+	inner.SetContext(req.Context())
+
+	// The handler writes into a pipe from its own goroutine, so a response
+	// it flushes can be read, and passed on, before it returns.
+	pr, pw := io.Pipe()
+	u := &upstream{requestTime: c.now(), pr: pr, done: make(chan struct{})}
+	go func() {
+		defer close(u.done)
+		rw := response.NewWriter(pw)
+		next(rw, inner)
+		pw.CloseWithError(rw.Finish())
+	}()
+	resp, err := http.ReadResponse(bufio.NewReader(pr), nil)
+	if err != nil {
+		u.close()
+		return nil, fmt.Errorf("cache: reading handler response: %w", err)
+	}
+	u.resp = resp
+	return u, nil
+}
+
+// streamed reports whether the handler streamed its response, which then
+// came chunked, rather than writing it whole.
+func (u *upstream) streamed() bool {
+	return len(u.resp.TransferEncoding) > 0
+}
+
+// close stops reading the response and waits for the handler to return. A
+// handler still writing gets an error.
+func (u *upstream) close() {
+	u.pr.Close()
+	<-u.done
+}
+
+// entry reads the rest of the response into an Entry.
+func (c *Cache) entry(u *upstream) (*Entry, error) {
+	body, err := io.ReadAll(u.resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("cache: reading handler response: %w", err)
+	}
+	return &Entry{
+		Status:       u.resp.StatusCode,
+		Header:       responseHeader(u.resp),
+		Body:         body,
+		RequestTime:  u.requestTime,
+		ResponseTime: c.now(),
+	}, nil
+}
+
+// fetch runs next on a copy of req, as open does, and returns the whole
+// response it wrote.
+func (c *Cache) fetch(next server.Handler, req *request.Request, stored *Entry) (*Entry, error) {
+	u, err := c.open(next, req, stored)
+	if err != nil {
+		return nil, err
+	}
+	defer u.close()
+	return c.entry(u)
+}
+
+// fetchOrStream is fetch for a client waiting on the response. One the
+// handler streams is passed on to w as it arrives, marked with fwd, and a
+// nil Entry is returned. A streamed error while revalidating stored is
+// read whole instead, so the stale copy can stand in for it.
+func (c *Cache) fetchOrStream(next server.Handler, w *response.Writer, req *request.Request, stored *Entry, fwd string) (*Entry, error) {
+	u, err := c.open(next, req, stored)
+	if err != nil {
+		return nil, err
+	}
+	defer u.close()
+	if u.streamed() && (stored == nil || u.resp.StatusCode < 500) {
+		c.stream(w, req, u, fmt.Sprintf("%s; fwd-status=%d", fwd, u.resp.StatusCode))
+		return nil, nil
+	}
+	return c.entry(u)
+}
+
+// stream copies a streamed response to w, flushing each read so the client
+// sees it as the handler wrote it, and sends its trailers after the body.
+func (c *Cache) stream(w *response.Writer, req *request.Request, u *upstream, status string) {
+	resp := u.resp
+	if !c.writeHead(w, req, &Entry{Status: resp.StatusCode, Header: responseHeader(resp)}, status) {
+		return
+	}
+	// The digest covers the body as w sends it, so w computes its own.
+	for name := range resp.Trailer {
+		if name == "Content-Digest" {
+			w.EnableContentDigest()
+		} else {
+			w.DeclareTrailer(name)
+		}
+	}
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			log.Printf("cache: streaming handler response: %v\n", err)
+			return
+		}
+	}
+	for name, values := range resp.Trailer {
+		if name != "Content-Digest" {
+			w.SetTrailer(name, strings.Join(values, ", "))
+		}
+	}
+}
+
+// responseHeader returns resp's fields minus the hop-by-hop ones.
+func responseHeader(resp *http.Response) headers.Headers {
+	h := headers.NewHeaders()
+	for name, values := range resp.Header {
+		for _, v := range values {
+			h.Add(name, v)
+		}
+	}
+	for _, name := range hopByHop {
+		h.Del(name)
+	}
+	return h
+}
+
+// cloneRequest rebuilds req as a bodiless GET carrying the same fields,
+// minus any conditional ones, plus extra.
+func cloneRequest(req *request.Request, extra map[string]string) (*request.Request, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "GET %s %s\r\n", req.RequestLine.RequestTarget, "HTTP/"+req.RequestLine.HttpVersion)
+	for name, value := range req.Headers {
+		switch name {
+		case "if-none-match", "if-modified-since", "if-match", "if-unmodified-since", "if-range",
+			"content-length", "transfer-encoding", "expect":
+			continue
+		}
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	for name, value := range extra {
+		fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+	}
+	b.WriteString("\r\n")
+	clone, err := request.RequestFromReader(strings.NewReader(b.String()))
+	if err != nil {
+		return nil, err
+	}
+	clone.Conn = req.Conn
+	clone.Client = req.Client
+	return clone, nil
+}
+
+// send writes e to the client with its current Age and a Cache-Status, or
+// a 304 or 412 if the client's own preconditions call for one.
+func (c *Cache) send(w *response.Writer, req *request.Request, e *Entry, status string) {
+	if c.writeHead(w, req, e, status) {
+		w.Write(e.Body)
+	}
+}
+
+// writeHead sets w's status and fields from e, adding Age and Cache-Status,
+// and reports whether e's body should follow. It doesn't when the client's
+// preconditions are answered with a 304 or 412 instead.
+func (c *Cache) writeHead(w *response.Writer, req *request.Request, e *Entry, status string) bool {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = v
+	}
+	if e.ResponseTime.IsZero() {
+		h.Del("Age")
+	} else {
+		h.Set("Age", strconv.Itoa(int(e.age(c.now())/time.Second)))
+	}
+	h.Set("Cache-Status", c.opts.Name+"; "+status)
+	if e.Status == int(response.StatusOk) {
+		if st := conditional.Evaluate(req, e.validators()); st != 0 {
+			w.SetStatus(st)
+			return false
+		}
+	}
+	w.SetStatus(response.StatusCode(e.Status))
+	return true
+}
+
+func writeError(w *response.Writer, err error) {
+	log.Printf("cache: %v\n", err)
+	w.SetStatus(response.StatusInternalServerError)
+	w.Write([]byte(response.StatusText(response.StatusInternalServerError) + "\n"))
+}
@@ -0,0 +1,500 @@
+package cache
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+var start = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// clock is a manual clock for the cache's now.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+// newCache returns a cache on a manual clock starting at start.
+func newCache(opts Options) (*Cache, *clock) {
+	clk := &clock{t: start}
+	c := New(opts)
+	c.now = clk.now
+	return c, clk
+}
+
+// newRequest parses a request for target with the given header lines.
+func newRequest(t *testing.T, method, target string, lines ...string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " " + target + " HTTP/1.1\r\nHost: x\r\n" + strings.Join(lines, "") + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+// serve runs h against req and returns the raw response.
+func serve(t *testing.T, h server.Handler, req *request.Request) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+// origin counts its calls and answers with the call number, using fields
+// to set response headers.
+type origin struct {
+	calls  atomic.Int32
+	fields map[string]string
+	// status, if set, overrides 200 for full responses.
+	status response.StatusCode
+}
+
+func (o *origin) handle(w *response.Writer, req *request.Request) {
+	n := o.calls.Add(1)
+	h := w.Header()
+	for k, v := range o.fields {
+		h.Set(k, v)
+	}
+	v := conditional.Validators{ETag: h.Get("ETag")}
+	v.LastModified, _ = time.Parse(conditional.TimeFormat, h.Get("Last-Modified"))
+	if conditional.Check(w, req, v) {
+		return
+	}
+	if o.status != 0 {
+		w.SetStatus(o.status)
+	}
+	fmt.Fprintf(w, "call %d lang=%s\n", n, req.Headers.Get("Accept-Language"))
+}
+
+func TestParseCacheControl(t *testing.T) {
+	d := ParseCacheControl(`max-age=60, No-Cache="Set-Cookie, Foo", private, max-age=5, s-maxage="30"`)
+
+	// Test: Names are lowercased and quoted arguments unquoted
+	assert.Equal(t, "Set-Cookie, Foo", d["no-cache"])
+	assert.True(t, d.Has("private"))
+	assert.False(t, d.Has("public"))
+
+	// Test: The first occurrence wins
+	s, ok := d.Seconds("max-age")
+	assert.True(t, ok)
+	assert.Equal(t, 60*time.Second, s)
+
+	// Test: Quoted delta-seconds are accepted
+	s, ok = d.Seconds("s-maxage")
+	assert.True(t, ok)
+	assert.Equal(t, 30*time.Second, s)
+
+	// Test: Malformed, negative and huge values
+	d = ParseCacheControl("max-age=abc, s-maxage=-5, stale-if-error=99999999999999999999")
+	_, ok = d.Seconds("max-age")
+	assert.False(t, ok)
+	s, ok = d.Seconds("s-maxage")
+	assert.True(t, ok)
+	assert.Zero(t, s)
+	s, ok = d.Seconds("stale-if-error")
+	assert.True(t, ok)
+	assert.Equal(t, maxDelta, s)
+}
+
+func TestFreshness(t *testing.T) {
+	entry := func(fields map[string]string) *Entry {
+		h := headers.NewHeaders()
+		for k, v := range fields {
+			h.Set(k, v)
+		}
+		return &Entry{Status: 200, Header: h, RequestTime: start, ResponseTime: start}
+	}
+	date := start.Format(conditional.TimeFormat)
+
+	// Test: s-maxage beats max-age, which beats Expires
+	e := entry(map[string]string{"Cache-Control": "max-age=60, s-maxage=10", "Expires": start.Add(time.Hour).Format(conditional.TimeFormat)})
+	assert.Equal(t, 10*time.Second, e.lifetime())
+	e = entry(map[string]string{"Cache-Control": "max-age=60", "Expires": start.Add(time.Hour).Format(conditional.TimeFormat)})
+	assert.Equal(t, 60*time.Second, e.lifetime())
+
+	// Test: Expires is relative to Date, and an invalid one has expired
+	e = entry(map[string]string{"Date": date, "Expires": start.Add(time.Hour).Format(conditional.TimeFormat)})
+	assert.Equal(t, time.Hour, e.lifetime())
+	e = entry(map[string]string{"Expires": "0"})
+	assert.Zero(t, e.lifetime())
+
+	// Test: Heuristic freshness is a tenth of the time since Last-Modified
+	e = entry(map[string]string{"Date": date, "Last-Modified": start.Add(-10 * time.Hour).Format(conditional.TimeFormat)})
+	assert.Equal(t, time.Hour, e.lifetime())
+	e.Status = 302
+	assert.Zero(t, e.lifetime())
+
+	// Test: Age counts the Age header, transit time and time in the cache
+	e = entry(map[string]string{"Date": date, "Age": "30"})
+	e.ResponseTime = start.Add(2 * time.Second)
+	assert.Equal(t, 42*time.Second, e.age(start.Add(12*time.Second)))
+}
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore(100)
+	entry := func(n int) []*Entry {
+		return []*Entry{{Status: 200, Header: headers.NewHeaders(), Body: make([]byte, n)}}
+	}
+	s.Put("a", entry(40))
+	s.Put("b", entry(40))
+
+	// Test: Reading a marks it recently used
+	_, ok := s.Get("a")
+	assert.True(t, ok)
+
+	// Test: Exceeding the cap evicts the least recently used
+	s.Put("c", entry(40))
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+	_, ok = s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, int64(80), s.Size())
+
+	// Test: An entry larger than the cap isn't stored
+	s.Put("d", entry(200))
+	_, ok = s.Get("d")
+	assert.False(t, ok)
+
+	// Test: Delete frees its space
+	s.Delete("a")
+	assert.Equal(t, int64(40), s.Size())
+}
+
+func TestDiskStore(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewDiskStore(dir, 1<<20)
+	require.NoError(t, err)
+	h := headers.NewHeaders()
+	h.Set("ETag", `"v1"`)
+	s.Put("GET x /doc", []*Entry{{Status: 200, Header: h, Body: []byte("hello"), ResponseTime: start, Vary: map[string]string{"accept-language": "en"}}})
+
+	// Test: Entries survive reopening the directory
+	s, err = NewDiskStore(dir, 1<<20)
+	require.NoError(t, err)
+	got, ok := s.Get("GET x /doc")
+	require.True(t, ok)
+	require.Len(t, got, 1)
+	assert.Equal(t, []byte("hello"), got[0].Body)
+	assert.Equal(t, `"v1"`, got[0].Header.Get("ETag"))
+	assert.True(t, start.Equal(got[0].ResponseTime))
+	assert.Equal(t, "en", got[0].Vary["accept-language"])
+
+	// Test: Delete removes the file
+	s.Delete("GET x /doc")
+	_, ok = s.Get("GET x /doc")
+	assert.False(t, ok)
+	assert.Equal(t, int64(0), s.Size())
+}
+
+func TestDiskStoreEviction(t *testing.T) {
+	dir := t.TempDir()
+	entry := []*Entry{{Status: 200, Header: headers.NewHeaders(), Body: make([]byte, 100)}}
+	probe, err := NewDiskStore(t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	probe.Put("a", entry)
+	size := probe.Size()
+
+	s, err := NewDiskStore(dir, 2*size+size/2)
+	require.NoError(t, err)
+	s.Put("a", entry)
+	s.Put("b", entry)
+
+	// Test: Exceeding the cap evicts the least recently used file
+	_, ok := s.Get("a")
+	assert.True(t, ok)
+	s.Put("c", entry)
+	_, ok = s.Get("b")
+	assert.False(t, ok)
+	_, ok = s.Get("a")
+	assert.True(t, ok)
+	assert.Equal(t, 2*size, s.Size())
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	require.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// Test: A resource larger than the cap isn't stored
+	s.Put("d", []*Entry{{Status: 200, Header: headers.NewHeaders(), Body: make([]byte, 1000)}})
+	_, ok = s.Get("d")
+	assert.False(t, ok)
+
+	// Test: Reopening with a smaller cap evicts the oldest files
+	require.NoError(t, os.Chtimes(filepath.Join(dir, fileName("a")), start, start))
+	require.NoError(t, os.Chtimes(filepath.Join(dir, fileName("c")), start.Add(time.Hour), start.Add(time.Hour)))
+	s, err = NewDiskStore(dir, size)
+	require.NoError(t, err)
+	assert.Equal(t, size, s.Size())
+	_, ok = s.Get("a")
+	assert.False(t, ok)
+	_, ok = s.Get("c")
+	assert.True(t, ok)
+}
+
+func TestMiddlewareHit(t *testing.T) {
+	c, clk := newCache(Options{})
+	o := &origin{fields: map[string]string{"Cache-Control": "max-age=60", "ETag": `"v1"`}}
+	h := c.Middleware()(o.handle)
+
+	// Test: A miss is fetched and stored
+	resp := serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "call 1")
+	assert.Contains(t, resp, "cache-status: httpfromtcp; fwd=uri-miss; fwd-status=200; stored\r\n")
+	assert.Contains(t, resp, "age: 0\r\n")
+
+	// Test: A fresh hit is served with its Age
+	clk.advance(10 * time.Second)
+	resp = serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "call 1")
+	assert.Contains(t, resp, "age: 10\r\n")
+	assert.Contains(t, resp, "cache-status: httpfromtcp; hit; ttl=50\r\n")
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// Test: The cache answers the client's conditional requests itself
+	resp = serve(t, h, newRequest(t, "GET", "/doc", `If-None-Match: "v1"`+"\r\n"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 304 "))
+	assert.NotContains(t, resp, "call 1")
+	assert.Equal(t, int32(1), o.calls.Load())
+
+	// Test: A request max-age shorter than the age forces revalidation
+	resp = serve(t, h, newRequest(t, "GET", "/doc", "Cache-Control: max-age=5\r\n"))
+	assert.Contains(t, resp, "call 1")
+	assert.Contains(t, resp, "fwd=stale; fwd-status=304")
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: Request no-store bypasses the cache
+	resp = serve(t, h, newRequest(t, "GET", "/doc", "Cache-Control: no-store\r\n"))
+	assert.Contains(t, resp, "call 3")
+	assert.Contains(t, resp, "fwd=bypass")
+
+	// Test: Other paths are separate resources
+	resp = serve(t, h, newRequest(t, "GET", "/other"))
+	assert.Contains(t, resp, "call 4")
+
+	// Test: The key is the cleaned path, so dot segments and the absolute
+	// form hit the same entry, while a query is a different resource
+	resp = serve(t, h, newRequest(t, "GET", "/x/../doc"))
+	assert.Contains(t, resp, "cache-status: httpfromtcp; hit")
+	resp = serve(t, h, newRequest(t, "GET", "http://X/./doc"))
+	assert.Contains(t, resp, "cache-status: httpfromtcp; hit")
+	resp = serve(t, h, newRequest(t, "GET", "/doc?page=2"))
+	assert.Contains(t, resp, "call 5")
+	assert.Equal(t, int32(5), o.calls.Load())
+}
+
+func TestMiddlewareNotStored(t *testing.T) {
+	c, _ := newCache(Options{})
+	cases := []struct {
+		name   string
+		fields map[string]string
+		lines  []string
+	}{
+		{"no-store", map[string]string{"Cache-Control": "no-store, max-age=60"}, nil},
+		{"private", map[string]string{"Cache-Control": "private, max-age=60"}, nil},
+		{"no freshness or validators", nil, nil},
+		{"Set-Cookie", map[string]string{"Cache-Control": "max-age=60", "Set-Cookie": "a=b"}, nil},
+		{"Vary star", map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, nil},
+		{"Authorization", map[string]string{"Cache-Control": "max-age=60"}, []string{"Authorization: Basic eDp5\r\n"}},
+	}
+	for i, tc := range cases {
+		o := &origin{fields: tc.fields}
+		h := c.Middleware()(o.handle)
+		target := fmt.Sprintf("/case%d", i)
+		serve(t, h, newRequest(t, "GET", target, tc.lines...))
+		resp := serve(t, h, newRequest(t, "GET", target, tc.lines...))
+		// Test: each uncacheable response
+		assert.Contains(t, resp, "call 2", tc.name)
+	}
+
+	// Test: Bodies over MaxEntrySize aren't stored
+	c, _ = newCache(Options{MaxEntrySize: 4})
+	o := &origin{fields: map[string]string{"Cache-Control": "max-age=60"}}
+	h := c.Middleware()(o.handle)
+	serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc")), "call 2")
+}
+
+func TestMiddlewareVary(t *testing.T) {
+	c, _ := newCache(Options{})
+	o := &origin{fields: map[string]string{"Cache-Control": "max-age=60", "Vary": "Accept-Language"}}
+	h := c.Middleware()(o.handle)
+
+	serve(t, h, newRequest(t, "GET", "/doc", "Accept-Language: en\r\n"))
+	resp := serve(t, h, newRequest(t, "GET", "/doc", "Accept-Language: fr\r\n"))
+
+	// Test: A different value is a miss for the same URI
+	assert.Contains(t, resp, "call 2 lang=fr")
+	assert.Contains(t, resp, "fwd=vary-miss")
+
+	// Test: Both variants are kept
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc", "Accept-Language: en\r\n")), "call 1 lang=en")
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc", "Accept-Language: fr\r\n")), "call 2 lang=fr")
+	assert.Equal(t, int32(2), o.calls.Load())
+}
+
+func TestMiddlewareRevalidate(t *testing.T) {
+	c, clk := newCache(Options{})
+	o := &origin{fields: map[string]string{"Cache-Control": "max-age=10", "ETag": `"v1"`}}
+	h := c.Middleware()(o.handle)
+	serve(t, h, newRequest(t, "GET", "/doc"))
+
+	// Test: A stale entry is revalidated and refreshed by a 304
+	clk.advance(20 * time.Second)
+	resp := serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "call 1")
+	assert.Contains(t, resp, "fwd=stale; fwd-status=304")
+	assert.Contains(t, resp, "age: 0\r\n")
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: The refreshed entry is fresh again
+	clk.advance(5 * time.Second)
+	resp = serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "hit; ttl=5")
+	assert.Equal(t, int32(2), o.calls.Load())
+
+	// Test: A changed resource replaces the entry
+	o.fields["ETag"] = `"v2"`
+	clk.advance(10 * time.Second)
+	resp = serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "call 3")
+	assert.Contains(t, resp, "fwd=stale; fwd-status=200; stored")
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc")), "call 3")
+
+	// Test: Request no-cache always revalidates
+	resp = serve(t, h, newRequest(t, "GET", "/doc", "Cache-Control: no-cache\r\n"))
+	assert.Contains(t, resp, "fwd=request; fwd-status=304")
+	assert.Equal(t, int32(4), o.calls.Load())
+}
+
+func TestMiddlewareStale(t *testing.T) {
+	c, clk := newCache(Options{})
+	o := &origin{fields: map[string]string{"Cache-Control": "max-age=10, stale-while-revalidate=30, stale-if-error=60"}}
+	h := c.Middleware()(o.handle)
+	serve(t, h, newRequest(t, "GET", "/doc"))
+
+	// Test: Within stale-while-revalidate the stale copy is served at once
+	clk.advance(20 * time.Second)
+	resp := serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "call 1")
+	assert.Contains(t, resp, "age: 20\r\n")
+
+	// Test: and refreshed in the background
+	c.background.Wait()
+	assert.Equal(t, int32(2), o.calls.Load())
+	resp = serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.Contains(t, resp, "call 2")
+	assert.Contains(t, resp, "age: 0\r\n")
+
+	// Test: Past it, errors are covered by stale-if-error
+	o.status = response.StatusInternalServerError
+	clk.advance(50 * time.Second)
+	resp = serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 "))
+	assert.Contains(t, resp, "call 2")
+	assert.Contains(t, resp, "detail=stale-if-error")
+
+	// Test: and past that the error is passed on
+	clk.advance(time.Minute)
+	resp = serve(t, h, newRequest(t, "GET", "/doc"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 500 "))
+}
+
+func TestMiddlewareInvalidate(t *testing.T) {
+	c, _ := newCache(Options{})
+	o := &origin{fields: map[string]string{"Cache-Control": "max-age=60"}}
+	h := c.Middleware()(o.handle)
+	serve(t, h, newRequest(t, "GET", "/doc"))
+
+	// Test: A failed unsafe request leaves the entry
+	o.status = response.StatusBadRequest
+	serve(t, h, newRequest(t, "POST", "/doc"))
+	o.status = 0
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc")), "call 1")
+
+	// Test: A failure sent with the low-level API leaves it too
+	lowLevel := c.Middleware()(func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "POST" {
+			o.handle(w, req)
+			return
+		}
+		require.NoError(t, w.WriteStatusLine(response.StatusInternalServerError))
+		require.NoError(t, w.WriteHeaders(response.GetDefaultHeaders(0)))
+	})
+	serve(t, lowLevel, newRequest(t, "POST", "/doc"))
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc")), "call 1")
+
+	// Test: A successful one evicts it
+	serve(t, h, newRequest(t, "DELETE", "/doc"))
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc")), "call 4")
+
+	// Test: including when its target only cleans to the same path
+	serve(t, h, newRequest(t, "PUT", "/a/../doc"))
+	assert.Contains(t, serve(t, h, newRequest(t, "GET", "/doc")), "call 6")
+}
+
+// signalWriter closes seen once what was written contains mark.
+type signalWriter struct {
+	bytes.Buffer
+	mark string
+	seen chan struct{}
+}
+
+func (s *signalWriter) Write(p []byte) (int, error) {
+	n, _ := s.Buffer.Write(p)
+	if s.seen != nil && strings.Contains(s.String(), s.mark) {
+		close(s.seen)
+		s.seen = nil
+	}
+	return n, nil
+}
+
+func TestMiddlewareStream(t *testing.T) {
+	c, _ := newCache(Options{})
+	dst := &signalWriter{mark: "first\n", seen: make(chan struct{})}
+	seen := dst.seen
+	var calls atomic.Int32
+	h := c.Middleware()(func(w *response.Writer, req *request.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		require.NoError(t, w.DeclareTrailer("X-Count"))
+		w.Write([]byte("first\n"))
+		w.Flush()
+		select {
+		case <-seen:
+		case <-time.After(time.Second):
+			t.Error("flushed chunk did not reach the client")
+		}
+		w.Write([]byte("second\n"))
+		w.SetTrailer("X-Count", "2")
+	})
+
+	// Test: A streamed response reaches the client as it is written,
+	// trailers included
+	w := response.NewWriter(dst)
+	h(w, newRequest(t, "GET", "/feed"))
+	require.NoError(t, w.Finish())
+	resp := dst.String()
+	assert.Contains(t, resp, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, resp, "trailer: X-Count\r\n")
+	assert.Contains(t, resp, "cache-status: httpfromtcp; fwd=uri-miss; fwd-status=200\r\n")
+	assert.True(t, strings.HasSuffix(resp, "0\r\nx-count: 2\r\n\r\n"), resp)
+
+	// Test: and isn't stored
+	seen = make(chan struct{})
+	close(seen)
+	resp = serve(t, h, newRequest(t, "GET", "/feed"))
+	assert.Contains(t, resp, "fwd=uri-miss")
+	assert.Equal(t, int32(2), calls.Load())
+}
@@ -0,0 +1,91 @@
+package cache
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Directives are the parsed directives of a Cache-Control field. Names are
+// lowercase; directives without an argument map to "".
+type Directives map[string]string
+
+// ParseCacheControl parses a Cache-Control field value (RFC 9111 section
+// 5.2). Arguments may be tokens or quoted strings. When a directive repeats,
+// the first occurrence wins.
+func ParseCacheControl(v string) Directives {
+	d := Directives{}
+	for _, part := range splitDirectives(v) {
+		name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		arg = strings.TrimSpace(arg)
+		if len(arg) >= 2 && arg[0] == '"' && arg[len(arg)-1] == '"' {
+			arg = strings.ReplaceAll(arg[1:len(arg)-1], `\`, "")
+		}
+		if _, seen := d[name]; !seen {
+			d[name] = arg
+		}
+	}
+	return d
+}
+
+// splitDirectives splits on commas outside quoted strings, since a quoted
+// argument such as no-cache="Set-Cookie, Foo" can hold them.
+func splitDirectives(v string) []string {
+	var parts []string
+	inQuote := false
+	start := 0
+	for i := 0; i < len(v); i++ {
+		switch v[i] {
+		case '"':
+			inQuote = !inQuote
+		case ',':
+			if !inQuote {
+				parts = append(parts, v[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, v[start:])
+}
+
+// Has reports whether the directive is present.
+func (d Directives) Has(name string) bool {
+	_, ok := d[name]
+	return ok
+}
+
+// Seconds returns a delta-seconds argument such as max-age's. Malformed
+// values are reported as absent, except that negative ones count as zero.
+// Values too large to represent are capped (RFC 9111 section 1.2.2).
+func (d Directives) Seconds(name string) (time.Duration, bool) {
+	arg, ok := d[name]
+	if !ok || arg == "" {
+		return 0, false
+	}
+	if strings.HasPrefix(arg, "-") {
+		if _, err := strconv.ParseInt(arg, 10, 64); err == nil {
+			return 0, true
+		}
+		return 0, false
+	}
+	n, err := strconv.ParseUint(arg, 10, 64)
+	if err != nil {
+		if errors.Is(err, strconv.ErrRange) {
+			return maxDelta, true
+		}
+		return 0, false
+	}
+	if n >= uint64(maxDelta/time.Second) {
+		return maxDelta, true
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// maxDelta is the largest delta-seconds value honoured, 2^31 seconds as RFC
+// 9111 suggests.
+const maxDelta = (1 << 31) * time.Second
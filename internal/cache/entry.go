@@ -0,0 +1,167 @@
+package cache
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+)
+
+// Entry is one stored response.
+type Entry struct {
+	Status int             `json:"status"`
+	Header headers.Headers `json:"header"`
+	Body   []byte          `json:"body"`
+	// RequestTime and ResponseTime bracket the handler call that produced
+	// the response, for the age calculation.
+	RequestTime  time.Time `json:"request_time"`
+	ResponseTime time.Time `json:"response_time"`
+	// Vary holds the values the request had for the fields named in the
+	// response's Vary header, normalized, keyed by lowercase name.
+	Vary map[string]string `json:"vary,omitempty"`
+}
+
+// heuristicStatuses may be cached without explicit freshness information
+// (RFC 9110 section 15.1).
+var heuristicStatuses = map[int]bool{
+	200: true, 203: true, 204: true, 300: true, 301: true, 308: true,
+	404: true, 405: true, 410: true, 414: true, 501: true,
+}
+
+// maxHeuristic caps the freshness guessed from Last-Modified.
+const maxHeuristic = 24 * time.Hour
+
+func (e *Entry) directives() Directives {
+	return ParseCacheControl(e.Header.Get("Cache-Control"))
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for k, v := range e.Header {
+		n += int64(len(k) + len(v))
+	}
+	return n
+}
+
+// date returns the response's Date, or when it was received if it has none.
+func (e *Entry) date() time.Time {
+	if t, err := time.Parse(conditional.TimeFormat, e.Header.Get("Date")); err == nil {
+		return t
+	}
+	return e.ResponseTime
+}
+
+// lifetime is the freshness lifetime for a shared cache (RFC 9111 section
+// 4.2.1): s-maxage, then max-age, then Expires, then a heuristic of a tenth
+// of the time since Last-Modified.
+func (e *Entry) lifetime() time.Duration {
+	d := e.directives()
+	if s, ok := d.Seconds("s-maxage"); ok {
+		return s
+	}
+	if s, ok := d.Seconds("max-age"); ok {
+		return s
+	}
+	if exp := e.Header.Get("Expires"); exp != "" {
+		t, err := time.Parse(conditional.TimeFormat, exp)
+		if err != nil {
+			// An invalid Expires, such as "0", means already expired.
+			return 0
+		}
+		return max(t.Sub(e.date()), 0)
+	}
+	if heuristicStatuses[e.Status] || d.Has("public") {
+		if lm, err := time.Parse(conditional.TimeFormat, e.Header.Get("Last-Modified")); err == nil {
+			return min(max(e.date().Sub(lm)/10, 0), maxHeuristic)
+		}
+	}
+	return 0
+}
+
+// age is the response's current age (RFC 9111 section 4.2.3).
+func (e *Entry) age(now time.Time) time.Duration {
+	apparent := max(e.ResponseTime.Sub(e.date()), 0)
+	var ageValue time.Duration
+	if s, err := strconv.ParseInt(e.Header.Get("Age"), 10, 64); err == nil && s > 0 {
+		ageValue = time.Duration(s) * time.Second
+	}
+	corrected := ageValue + e.ResponseTime.Sub(e.RequestTime)
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+// validators returns what a conditional request can check the entry by.
+func (e *Entry) validators() conditional.Validators {
+	v := conditional.Validators{ETag: e.Header.Get("ETag")}
+	v.LastModified, _ = time.Parse(conditional.TimeFormat, e.Header.Get("Last-Modified"))
+	return v
+}
+
+// varyNames lists the request fields the response varies on.
+func varyNames(h headers.Headers) []string {
+	var names []string
+	for _, name := range strings.Split(h.Get("Vary"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// varyValues records req's values for names, normalized so that spacing
+// differences don't split variants.
+func varyValues(req *request.Request, names []string) map[string]string {
+	if len(names) == 0 {
+		return nil
+	}
+	values := make(map[string]string, len(names))
+	for _, name := range names {
+		values[name] = normalize(req.Headers.Get(name))
+	}
+	return values
+}
+
+// matches reports whether req selects this variant (RFC 9111 section 4.1).
+func (e *Entry) matches(req *request.Request) bool {
+	for _, name := range varyNames(e.Header) {
+		if normalize(req.Headers.Get(name)) != e.Vary[name] {
+			return false
+		}
+	}
+	return true
+}
+
+func normalize(v string) string {
+	parts := strings.Split(v, ",")
+	for i := range parts {
+		parts[i] = strings.Join(strings.Fields(parts[i]), " ")
+	}
+	return strings.Join(parts, ",")
+}
+
+// storable reports whether a shared cache may keep e as the response to
+// req (RFC 9111 section 3). Responses setting cookies aren't stored either,
+// since they are almost always meant for one client.
+func storable(req *request.Request, e *Entry, reqCC Directives) bool {
+	d := e.directives()
+	switch {
+	case reqCC.Has("no-store"), d.Has("no-store"), d.Has("private"):
+		return false
+	case !heuristicStatuses[e.Status]:
+		return false
+	case e.Header.Get("Set-Cookie") != "":
+		return false
+	case strings.Contains(e.Header.Get("Vary"), "*"):
+		return false
+	}
+	if req.Headers.Get("Authorization") != "" &&
+		!d.Has("public") && !d.Has("s-maxage") && !d.Has("must-revalidate") {
+		return false
+	}
+	// Worth keeping only if it will be fresh for a while or can be
+	// revalidated cheaply.
+	v := e.validators()
+	return e.lifetime() > 0 || v.ETag != "" || !v.LastModified.IsZero()
+}
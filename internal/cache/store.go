@@ -0,0 +1,281 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBytes is the size of the MemoryStore New creates when Options
+// has no Store.
+const DefaultMaxBytes = 64 << 20
+
+// Store keeps the variants of each cached resource, keyed by method and
+// URI. Stored entries are never modified, so implementations may share
+// them. Implementations must be safe for concurrent use.
+type Store interface {
+	Get(key string) ([]*Entry, bool)
+	Put(key string, variants []*Entry)
+	Delete(key string)
+}
+
+// MemoryStore is an in-memory Store that evicts the least recently used
+// resources once its contents exceed a size cap.
+type MemoryStore struct {
+	max int64
+
+	mu    sync.Mutex
+	used  int64
+	items map[string]*list.Element
+	order *list.List
+}
+
+type memoryItem struct {
+	key      string
+	variants []*Entry
+	size     int64
+}
+
+// NewMemoryStore returns a MemoryStore holding up to maxBytes of bodies
+// and headers.
+func NewMemoryStore(maxBytes int64) *MemoryStore {
+	return &MemoryStore{max: maxBytes, items: map[string]*list.Element{}, order: list.New()}
+}
+
+// Get returns the variants stored under key and marks them recently used.
+func (s *MemoryStore) Get(key string) ([]*Entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.order.MoveToFront(el)
+	return append([]*Entry(nil), el.Value.(*memoryItem).variants...), true
+}
+
+// Put stores variants under key, evicting older resources to make room. A
+// resource larger than the whole cap isn't stored.
+func (s *MemoryStore) Put(key string, variants []*Entry) {
+	var size int64
+	for _, e := range variants {
+		size += e.size()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+	if size > s.max {
+		return
+	}
+	item := &memoryItem{key: key, variants: append([]*Entry(nil), variants...), size: size}
+	s.items[key] = s.order.PushFront(item)
+	s.used += size
+	for s.used > s.max {
+		s.remove(s.order.Back().Value.(*memoryItem).key)
+	}
+}
+
+// Delete removes key.
+func (s *MemoryStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(key)
+}
+
+// Size returns how many bytes the store holds.
+func (s *MemoryStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+func (s *MemoryStore) remove(key string) {
+	el, ok := s.items[key]
+	if !ok {
+		return
+	}
+	s.order.Remove(el)
+	delete(s.items, key)
+	s.used -= el.Value.(*memoryItem).size
+}
+
+// DiskStore keeps each resource as a JSON file named after a hash of its
+// key, so the cache survives restarts. Like MemoryStore it evicts the least
+// recently used resources once its files exceed a size cap; reads touch a
+// file's modification time so that order also survives restarts.
+type DiskStore struct {
+	dir string
+	max int64
+
+	// mu guards the index and keeps writes in order. Renames already make
+	// each one atomic for readers.
+	mu    sync.Mutex
+	used  int64
+	files map[string]*list.Element
+	order *list.List
+}
+
+type diskFile struct {
+	name string
+	size int64
+}
+
+// diskRecord is the file format. Key guards against hash collisions.
+type diskRecord struct {
+	Key      string   `json:"key"`
+	Variants []*Entry `json:"variants"`
+}
+
+// NewDiskStore returns a DiskStore holding up to maxBytes of files in dir,
+// creating it if needed. Files already there count towards the cap, oldest
+// evicted first.
+func NewDiskStore(dir string, maxBytes int64) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		diskFile
+		modTime time.Time
+	}
+	var existing []found
+	for _, de := range dirEntries {
+		if !de.Type().IsRegular() || !strings.HasSuffix(de.Name(), ".json") {
+			continue
+		}
+		info, err := de.Info()
+		if err != nil {
+			continue
+		}
+		existing = append(existing, found{diskFile{de.Name(), info.Size()}, info.ModTime()})
+	}
+	// Oldest first, so the most recently used end up at the front.
+	sort.Slice(existing, func(i, j int) bool {
+		return existing[i].modTime.Before(existing[j].modTime)
+	})
+	s := &DiskStore{dir: dir, max: maxBytes, files: map[string]*list.Element{}, order: list.New()}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range existing {
+		s.add(f.name, f.size)
+	}
+	s.evict()
+	return s, nil
+}
+
+// Get reads the variants stored under key and marks them recently used.
+// Unreadable files count as misses.
+func (s *DiskStore) Get(key string) ([]*Entry, bool) {
+	name := fileName(key)
+	data, err := os.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, false
+	}
+	var rec diskRecord
+	if err := json.Unmarshal(data, &rec); err != nil || rec.Key != key {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.files[name]; ok {
+		s.order.MoveToFront(el)
+		now := time.Now()
+		_ = os.Chtimes(filepath.Join(s.dir, name), now, now)
+	}
+	return rec.Variants, true
+}
+
+// Put writes variants to a temporary file and renames it into place, so a
+// concurrent Get never sees a partial record, then evicts older resources
+// to make room. A resource larger than the whole cap isn't stored, and
+// write errors leave it uncached.
+func (s *DiskStore) Put(key string, variants []*Entry) {
+	data, err := json.Marshal(diskRecord{Key: key, Variants: variants})
+	if err != nil {
+		return
+	}
+	name := fileName(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if int64(len(data)) > s.max {
+		s.remove(name)
+		return
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(s.dir, name))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+	s.forget(name)
+	s.add(name, int64(len(data)))
+	s.evict()
+}
+
+// Delete removes key.
+func (s *DiskStore) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(fileName(key))
+}
+
+// Size returns how many bytes the store's files take.
+func (s *DiskStore) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used
+}
+
+func (s *DiskStore) add(name string, size int64) {
+	s.files[name] = s.order.PushFront(&diskFile{name: name, size: size})
+	s.used += size
+}
+
+func (s *DiskStore) evict() {
+	for s.used > s.max {
+		s.remove(s.order.Back().Value.(*diskFile).name)
+	}
+}
+
+// forget drops name from the index, leaving the file.
+func (s *DiskStore) forget(name string) {
+	el, ok := s.files[name]
+	if !ok {
+		return
+	}
+	s.order.Remove(el)
+	delete(s.files, name)
+	s.used -= el.Value.(*diskFile).size
+}
+
+// remove deletes name's file and drops it from the index.
+func (s *DiskStore) remove(name string) {
+	s.forget(name)
+	// A missing file is already deleted, and any other failure leaves a
+	// stale copy that revalidation will correct.
+	_ = os.Remove(filepath.Join(s.dir, name))
+}
+
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + ".json"
+}
@@ -0,0 +1,220 @@
+package compress
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultMinSize is the smallest body worth compressing; below it the gzip
+// header and trailer eat most of the savings.
+const DefaultMinSize = 1024
+
+// DefaultContentTypes are the media types compressed when Options doesn't
+// list any. Entries ending in "/" match a whole top-level type, except for
+// text/event-stream, which has to reach the client event by event and is
+// only compressed when listed by name.
+var DefaultContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// Options configures the compression middleware.
+type Options struct {
+	// Level is the compression level for both codings. Zero means the
+	// library default.
+	Level int
+	// MinSize is the smallest buffered body that gets compressed. Streamed
+	// bodies are always compressed since their size isn't known up front.
+	// Zero means DefaultMinSize.
+	MinSize int
+	// ContentTypes overrides DefaultContentTypes.
+	ContentTypes []string
+}
+
+// supported lists the codings we can produce, in order of preference when
+// the client rates them equally.
+var supported = []string{"gzip", "deflate"}
+
+// Middleware compresses eligible responses with gzip or deflate according to
+// the request's Accept-Encoding. It skips bodyless, partial and already
+// encoded responses, responses marked no-transform and types not listed in
+// Options, and adds Vary: Accept-Encoding to every response it considered.
+// A client that refuses identity gets every response that may be
+// transformed compressed, whatever its type or size, and 406 if it accepts
+// none of our codings either.
+func Middleware(opts Options) server.Middleware {
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	if opts.MinSize == 0 {
+		opts.MinSize = DefaultMinSize
+	}
+	if opts.ContentTypes == nil {
+		opts.ContentTypes = DefaultContentTypes
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			coding, identity := Negotiate(req.Headers.Get("Accept-Encoding"))
+			if coding == "" && !identity {
+				w.Header().Set("Vary", "Accept-Encoding")
+				w.SetStatus(response.StatusNotAcceptable)
+				w.Write([]byte("no acceptable content coding\n"))
+				return
+			}
+			w.SetBodyEncoder(func(status response.StatusCode, h headers.Headers, size int64, dst io.Writer) io.WriteCloser {
+				if !opts.eligible(status, h) && (identity || !transformable(status, h)) {
+					return nil
+				}
+				addVary(h, "Accept-Encoding")
+				if coding == "" || (identity && size >= 0 && size < int64(opts.MinSize)) {
+					return nil
+				}
+				enc, err := newEncoder(coding, opts.Level, dst)
+				if err != nil {
+					return nil
+				}
+				h.Set("Content-Encoding", coding)
+				// The encoded bytes differ from the identity ones, so a strong
+				// validator no longer describes them exactly.
+				if etag := h.Get("ETag"); strings.HasPrefix(etag, "\"") {
+					h.Set("ETag", "W/"+etag)
+				}
+				return enc
+			})
+			next(w, req)
+		}
+	}
+}
+
+// eligible reports whether a response should be compressed, regardless of
+// what the client accepts.
+func (o Options) eligible(status response.StatusCode, h headers.Headers) bool {
+	if !transformable(status, h) {
+		return false
+	}
+	ct := strings.ToLower(h.Get("Content-Type"))
+	if i := strings.IndexByte(ct, ';'); i >= 0 {
+		ct = ct[:i]
+	}
+	ct = strings.TrimSpace(ct)
+	for _, t := range o.ContentTypes {
+		if ct == t {
+			return true
+		}
+	}
+	if ct == "text/event-stream" {
+		return false
+	}
+	for _, t := range o.ContentTypes {
+		if strings.HasSuffix(t, "/") && strings.HasPrefix(ct, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// transformable reports whether a response may be compressed at all: it
+// has a full body that isn't encoded already and doesn't forbid
+// transformation.
+func transformable(status response.StatusCode, h headers.Headers) bool {
+	if status < 200 || status == response.StatusNoContent || status == response.StatusNotModified ||
+		status == response.StatusPartialContent {
+		return false
+	}
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" {
+		return false
+	}
+	return !strings.Contains(strings.ToLower(h.Get("Cache-Control")), "no-transform")
+}
+
+func newEncoder(coding string, level int, dst io.Writer) (io.WriteCloser, error) {
+	if coding == "gzip" {
+		return gzip.NewWriterLevel(dst, level)
+	}
+	// HTTP "deflate" is the zlib format (RFC 9110 section 8.4.1.2).
+	return zlib.NewWriterLevel(dst, level)
+}
+
+// Negotiate picks the coding to use for an Accept-Encoding value, or "" for
+// identity. Codings are weighed by q-value; "*" covers codings not named
+// explicitly and q=0 rules a coding out. identity reports whether an
+// unencoded body is acceptable as well, which it is unless the value says
+// "identity;q=0", or "*;q=0" without naming identity (RFC 9110 section
+// 12.5.3).
+func Negotiate(acceptEncoding string) (coding string, identity bool) {
+	if strings.TrimSpace(acceptEncoding) == "" {
+		return "", true
+	}
+	weights := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		weights[name] = qValue(params)
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range supported {
+		q, ok := weights[coding]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	q, ok := weights["identity"]
+	if !ok {
+		q, ok = weights["*"]
+	}
+	return best, !ok || q > 0
+}
+
+// qValue extracts the weight from the parameters of a list element,
+// defaulting to 1 when none is given and 0 when it is malformed.
+func qValue(params string) float64 {
+	for _, p := range strings.Split(params, ";") {
+		k, v, ok := strings.Cut(strings.TrimSpace(p), "=")
+		if !ok || !strings.EqualFold(strings.TrimSpace(k), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || q < 0 || q > 1 {
+			return 0
+		}
+		return q
+	}
+	return 1
+}
+
+// addVary adds field to the Vary header unless it is already listed.
+func addVary(h headers.Headers, field string) {
+	vary := h.Get("Vary")
+	for _, v := range strings.Split(vary, ",") {
+		if v = strings.TrimSpace(v); v == "*" || strings.EqualFold(v, field) {
+			return
+		}
+	}
+	if vary == "" {
+		h.Set("Vary", field)
+		return
+	}
+	h.Set("Vary", vary+", "+field)
+}
@@ -0,0 +1,194 @@
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// serve runs h behind the middleware and splits the raw response into its
+// header section and body.
+func serve(t *testing.T, h server.Handler, acceptEncoding string) (string, []byte) {
+	raw := "GET / HTTP/1.1\r\nHost: x\r\n"
+	if acceptEncoding != "" {
+		raw += "Accept-Encoding: " + acceptEncoding + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	Middleware(Options{MinSize: 10})(h)(w, req)
+	require.NoError(t, w.Finish())
+	head, body, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	return head, []byte(body)
+}
+
+// dechunk strips chunked framing from body.
+func dechunk(t *testing.T, body []byte) []byte {
+	var out []byte
+	for {
+		line, rest, ok := bytes.Cut(body, []byte("\r\n"))
+		require.True(t, ok)
+		n, err := strconv.ParseInt(string(line), 16, 64)
+		require.NoError(t, err)
+		if n == 0 {
+			return out
+		}
+		out = append(out, rest[:n]...)
+		body = rest[n+2:]
+	}
+}
+
+func gunzip(t *testing.T, b []byte) string {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	return string(out)
+}
+
+var page = strings.Repeat("<p>hello</p>", 20)
+
+func TestNegotiate(t *testing.T) {
+	cases := []struct {
+		accept   string
+		coding   string
+		identity bool
+	}{
+		{"gzip, deflate", "gzip", true},
+		{"gzip;q=0.5, deflate", "deflate", true},
+		{"deflate;q=0.1", "deflate", true},
+		{"*", "gzip", true},
+		{"gzip;q=0, *;q=0.3", "deflate", true},
+		{"br, identity", "", true},
+		{"gzip;q=0", "", true},
+		{"", "", true},
+		{"gzip, identity;q=0", "gzip", false},
+		{"br, identity;q=0", "", false},
+		{"*;q=0", "", false},
+		{"*;q=0, identity", "", true},
+	}
+	for _, c := range cases {
+		coding, identity := Negotiate(c.accept)
+		// Test: each Accept-Encoding picks its coding and says whether
+		// identity is still acceptable
+		assert.Equal(t, c.coding, coding, c.accept)
+		assert.Equal(t, c.identity, identity, c.accept)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	html := func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(page))
+	}
+
+	// Test: Buffered body is gzipped with an exact Content-Length
+	head, body := serve(t, html, "gzip")
+	assert.Contains(t, head, "content-encoding: gzip")
+	assert.Contains(t, head, "vary: Accept-Encoding")
+	assert.Contains(t, head, "content-length: "+strconv.Itoa(len(body)))
+	assert.Equal(t, page, gunzip(t, body))
+
+	// Test: deflate produces zlib data
+	head, body = serve(t, html, "deflate")
+	assert.Contains(t, head, "content-encoding: deflate")
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	require.NoError(t, err)
+	out, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, page, string(out))
+
+	// Test: No acceptable coding still sends Vary
+	head, body = serve(t, html, "")
+	assert.NotContains(t, head, "content-encoding")
+	assert.Contains(t, head, "vary: Accept-Encoding")
+	assert.Equal(t, page, string(body))
+
+	// Test: Small bodies and ineligible types are left alone
+	head, _ = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("tiny"))
+	}, "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	head, _ = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte(page))
+	}, "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	assert.NotContains(t, head, "vary")
+
+	// Test: Event streams aren't compressed by the text/ default
+	head, _ = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(page))
+	}, "gzip")
+	assert.NotContains(t, head, "content-encoding")
+
+	// Test: A client refusing identity gets even small and unlisted
+	// bodies compressed
+	head, body = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Write([]byte("tiny"))
+	}, "gzip, identity;q=0")
+	assert.Contains(t, head, "content-encoding: gzip")
+	assert.Equal(t, "tiny", gunzip(t, body))
+
+	// Test: and 406 when it accepts none of our codings either
+	head, _ = serve(t, html, "br, identity;q=0")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 406 Not Acceptable\r\n"))
+	head, _ = serve(t, html, "*;q=0")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 406 "))
+
+	// Test: Partial and already encoded responses are skipped
+	head, _ = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Range", "bytes 0-9/100")
+		w.SetStatus(response.StatusPartialContent)
+		w.Write([]byte(page))
+	}, "gzip")
+	assert.NotContains(t, head, "content-encoding")
+	head, _ = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		w.Write([]byte(page))
+	}, "gzip")
+	assert.Contains(t, head, "content-encoding: br")
+
+	// Test: Streamed body is compressed and chunked, declared length dropped
+	head, body = serve(t, func(w *response.Writer, req *request.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(page)))
+		w.Header().Set("ETag", `"abc"`)
+		w.Write([]byte(page[:50]))
+		w.Flush()
+		w.Write([]byte(page[50:]))
+	}, "gzip")
+	assert.Contains(t, head, "transfer-encoding: chunked")
+	assert.Contains(t, head, `etag: W/"abc"`)
+	assert.NotContains(t, head, "content-length")
+	assert.Equal(t, page, gunzip(t, dechunk(t, body)))
+
+	// Test: Low-level chunked responses are compressed too
+	head, body = serve(t, func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		delete(h, "content-length")
+		h["transfer-encoding"] = "chunked"
+		w.WriteStatusLine(response.StatusOk)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte(page[:50]))
+		w.WriteChunkedBody([]byte(page[50:]))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(map[string]string{})
+	}, "gzip")
+	assert.Contains(t, head, "content-encoding: gzip")
+	assert.Equal(t, page, gunzip(t, dechunk(t, body)))
+}
@@ -0,0 +1,203 @@
+package conditional
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// TimeFormat is the IMF-fixdate layout of Last-Modified and the date
+// preconditions (RFC 9110 section 5.6.7).
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// Validators describe the current state of the selected representation.
+// A representation with neither validator is treated as not existing, which
+// is what "If-Match: *" and "If-None-Match: *" ask about.
+type Validators struct {
+	// ETag is a quoted entity tag, prefixed with W/ if weak.
+	ETag string
+	// LastModified is used at one-second precision; zero means unknown.
+	LastModified time.Time
+}
+
+func (v Validators) exists() bool {
+	return v.ETag != "" || !v.LastModified.IsZero()
+}
+
+// StrongETag returns a strong entity tag derived from the body's contents.
+func StrongETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + base64.RawURLEncoding.EncodeToString(sum[:18]) + `"`
+}
+
+// WeakETag returns a weak entity tag from a modification time, for content
+// that is streamed or too large to hash. Two versions written within the
+// same second would share it, hence weak.
+func WeakETag(modTime time.Time) string {
+	return fmt.Sprintf(`W/"%x"`, modTime.Unix())
+}
+
+// Evaluate applies req's preconditions to v in the order of RFC 9110
+// section 13.2.2 and returns the status to answer with instead of
+// performing the request: 412 Precondition Failed, 304 Not Modified, or 0
+// to go ahead.
+func Evaluate(req *request.Request, v Validators) response.StatusCode {
+	h := req.Headers
+	method := req.RequestLine.Method
+	safe := method == "GET" || method == "HEAD"
+	modTime := v.LastModified.UTC().Truncate(time.Second)
+
+	if im := h.Get("If-Match"); im != "" {
+		if !matchETag(im, v, strongMatch) {
+			return response.StatusPreconditionFailed
+		}
+	} else if ius := h.Get("If-Unmodified-Since"); ius != "" && !modTime.IsZero() {
+		if t, err := time.Parse(TimeFormat, ius); err == nil && modTime.After(t) {
+			return response.StatusPreconditionFailed
+		}
+	}
+
+	if inm := h.Get("If-None-Match"); inm != "" {
+		if matchETag(inm, v, weakMatch) {
+			if safe {
+				return response.StatusNotModified
+			}
+			return response.StatusPreconditionFailed
+		}
+	} else if ims := h.Get("If-Modified-Since"); ims != "" && safe && !modTime.IsZero() {
+		if t, err := time.Parse(TimeFormat, ims); err == nil && !modTime.After(t) {
+			return response.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// Check sets v as the response's ETag and Last-Modified and evaluates the
+// preconditions. When they call for 304 or 412 it writes that response and
+// returns true, and the handler should return without acting. Handlers of
+// unsafe methods call it before making any change, which gives clients
+// optimistic concurrency through If-Match.
+func Check(w *response.Writer, req *request.Request, v Validators) bool {
+	setValidators(w, v)
+	status := Evaluate(req, v)
+	if status == 0 {
+		return false
+	}
+	w.SetStatus(status)
+	if status == response.StatusPreconditionFailed {
+		w.Write([]byte(response.StatusText(status) + "\n"))
+	}
+	return true
+}
+
+func setValidators(w *response.Writer, v Validators) {
+	h := w.Header()
+	if v.ETag != "" {
+		h.Set("ETag", v.ETag)
+	}
+	if !v.LastModified.IsZero() {
+		h.Set("Last-Modified", v.LastModified.UTC().Format(TimeFormat))
+	}
+}
+
+type comparison int
+
+const (
+	strongMatch comparison = iota
+	weakMatch
+)
+
+// matchETag reports whether list, "*" or a comma-separated list of entity
+// tags, matches v's ETag under the given comparison (RFC 9110 section
+// 8.8.3.2).
+func matchETag(list string, v Validators, cmp comparison) bool {
+	if strings.TrimSpace(list) == "*" {
+		return v.exists()
+	}
+	if v.ETag == "" {
+		return false
+	}
+	current, currentWeak := opaque(v.ETag)
+	if cmp == strongMatch && currentWeak {
+		return false
+	}
+	for _, candidate := range strings.Split(list, ",") {
+		tag, weak := opaque(strings.TrimSpace(candidate))
+		if cmp == strongMatch && weak {
+			continue
+		}
+		if tag == current {
+			return true
+		}
+	}
+	return false
+}
+
+// opaque strips the weakness marker from an entity tag.
+func opaque(etag string) (string, bool) {
+	if rest, ok := strings.CutPrefix(etag, "W/"); ok {
+		return rest, true
+	}
+	return etag, false
+}
+
+// Middleware gives GET and HEAD responses validators and answers
+// conditional requests for them. A handler's own ETag and Last-Modified
+// headers are used when set. Otherwise a complete 200 body gets a strong
+// ETag computed from it, and a streamed one gets a weak ETag from
+// Last-Modified if it has one. When the preconditions call for 304 or 412
+// the body is dropped. Unsafe methods are passed through untouched: their
+// preconditions must be checked before the change is made, with Check.
+func Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			method := req.RequestLine.Method
+			if method != "GET" && method != "HEAD" {
+				next(w, req)
+				return
+			}
+			w.BeforeCommit(func() { precondition(w, req) })
+			next(w, req)
+		}
+	}
+}
+
+// precondition runs just before the response commits, once the handler's
+// status, headers and, for buffered responses, body are known.
+func precondition(w *response.Writer, req *request.Request) {
+	// Only a 200 describes the representation; error pages, partial
+	// content and the like are passed on as they are.
+	if w.Status() != response.StatusOk {
+		return
+	}
+	h := w.Header()
+	v := Validators{ETag: h.Get("ETag")}
+	if lm := h.Get("Last-Modified"); lm != "" {
+		v.LastModified, _ = time.Parse(TimeFormat, lm)
+	}
+	if v.ETag == "" {
+		body, complete := w.BufferedBody()
+		switch {
+		case complete:
+			v.ETag = StrongETag(body)
+		case !v.LastModified.IsZero():
+			v.ETag = WeakETag(v.LastModified)
+		}
+		if v.ETag != "" {
+			h.Set("ETag", v.ETag)
+		}
+	}
+	status := Evaluate(req, v)
+	if status == 0 {
+		return
+	}
+	w.DiscardBody()
+	h.Del("Content-Length")
+	w.SetStatus(status)
+}
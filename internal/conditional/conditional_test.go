@@ -0,0 +1,172 @@
+package conditional
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// newRequest parses a request with the given method and header lines.
+func newRequest(t *testing.T, method string, lines ...string) *request.Request {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(method + " /doc HTTP/1.1\r\nHost: x\r\n" + strings.Join(lines, "") + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+// serve runs h against req and returns the raw response.
+func serve(t *testing.T, h server.Handler, req *request.Request) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func TestEvaluate(t *testing.T) {
+	v := Validators{ETag: `"v2"`, LastModified: modTime}
+	const (
+		before = "Tue, 30 Apr 2024 12:00:00 GMT"
+		at     = "Wed, 01 May 2024 12:00:00 GMT"
+	)
+	cases := []struct {
+		name   string
+		method string
+		lines  []string
+		v      Validators
+		want   response.StatusCode
+	}{
+		{"no preconditions", "GET", nil, v, 0},
+		{"If-Match matches", "PUT", []string{`If-Match: "v1", "v2"` + "\r\n"}, v, 0},
+		{"If-Match stale", "PUT", []string{`If-Match: "v1"` + "\r\n"}, v, response.StatusPreconditionFailed},
+		{"If-Match needs a strong match", "PUT", []string{`If-Match: W/"v2"` + "\r\n"}, v, response.StatusPreconditionFailed},
+		{"If-Match with a weak current tag", "PUT", []string{`If-Match: "v2"` + "\r\n"}, Validators{ETag: `W/"v2"`}, response.StatusPreconditionFailed},
+		{"If-Match star on existing", "PUT", []string{"If-Match: *\r\n"}, v, 0},
+		{"If-Match star on missing", "PUT", []string{"If-Match: *\r\n"}, Validators{}, response.StatusPreconditionFailed},
+		{"If-Unmodified-Since passes", "PUT", []string{"If-Unmodified-Since: " + at + "\r\n"}, v, 0},
+		{"If-Unmodified-Since fails", "DELETE", []string{"If-Unmodified-Since: " + before + "\r\n"}, v, response.StatusPreconditionFailed},
+		{"If-Match overrides If-Unmodified-Since", "PUT", []string{`If-Match: "v2"` + "\r\n", "If-Unmodified-Since: " + before + "\r\n"}, v, 0},
+		{"If-None-Match weak match on GET", "GET", []string{`If-None-Match: W/"v2"` + "\r\n"}, v, response.StatusNotModified},
+		{"If-None-Match miss", "GET", []string{`If-None-Match: "v1"` + "\r\n"}, v, 0},
+		{"If-None-Match match on PUT", "PUT", []string{`If-None-Match: "v2"` + "\r\n"}, v, response.StatusPreconditionFailed},
+		{"If-None-Match star creates only", "PUT", []string{"If-None-Match: *\r\n"}, v, response.StatusPreconditionFailed},
+		{"If-None-Match star on missing", "PUT", []string{"If-None-Match: *\r\n"}, Validators{}, 0},
+		{"If-Modified-Since not modified", "GET", []string{"If-Modified-Since: " + at + "\r\n"}, v, response.StatusNotModified},
+		{"If-Modified-Since modified", "GET", []string{"If-Modified-Since: " + before + "\r\n"}, v, 0},
+		{"If-Modified-Since ignored for POST", "POST", []string{"If-Modified-Since: " + at + "\r\n"}, v, 0},
+		{"If-None-Match overrides If-Modified-Since", "GET", []string{`If-None-Match: "v1"` + "\r\n", "If-Modified-Since: " + at + "\r\n"}, v, 0},
+		{"bad dates are ignored", "GET", []string{"If-Modified-Since: yesterday\r\n", "If-Unmodified-Since: yesterday\r\n"}, v, 0},
+		{"If-Match checked before If-None-Match", "GET", []string{`If-Match: "v1"` + "\r\n", `If-None-Match: "v2"` + "\r\n"}, v, response.StatusPreconditionFailed},
+	}
+	for _, c := range cases {
+		// Test: each precondition case
+		assert.Equal(t, c.want, Evaluate(newRequest(t, c.method, c.lines...), c.v), c.name)
+	}
+}
+
+func TestETags(t *testing.T) {
+	// Test: Strong tags depend only on content
+	assert.Equal(t, StrongETag([]byte("a")), StrongETag([]byte("a")))
+	assert.NotEqual(t, StrongETag([]byte("a")), StrongETag([]byte("b")))
+	assert.Regexp(t, `^"[A-Za-z0-9_-]+"$`, StrongETag(nil))
+
+	// Test: Weak tags come from the modification time
+	assert.Equal(t, `W/"66322ec0"`, WeakETag(modTime))
+}
+
+func TestCheck(t *testing.T) {
+	v := Validators{ETag: `"v2"`, LastModified: modTime}
+	h := func(w *response.Writer, req *request.Request) {
+		if Check(w, req, v) {
+			return
+		}
+		_, _ = w.Write([]byte("updated\n"))
+	}
+
+	// Test: A matching If-Match lets the update through with validators
+	out := serve(t, h, newRequest(t, "PUT", `If-Match: "v2"`+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.Contains(t, out, "etag: \"v2\"\r\n")
+	assert.Contains(t, out, "last-modified: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	assert.True(t, strings.HasSuffix(out, "updated\n"))
+
+	// Test: A lost update gets 412
+	out = serve(t, h, newRequest(t, "PUT", `If-Match: "v1"`+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"), out)
+	assert.NotContains(t, out, "updated")
+}
+
+func TestMiddleware(t *testing.T) {
+	page := func(w *response.Writer, req *request.Request) {
+		switch req.Headers.Get("X-Mode") {
+		case "stream":
+			w.Header().Set("Last-Modified", modTime.Format(TimeFormat))
+			_, _ = w.Write([]byte("streamed "))
+			_ = w.Flush()
+			_, _ = w.Write([]byte("page\n"))
+		case "error":
+			w.SetStatus(response.StatusNotFound)
+			_, _ = w.Write([]byte("missing\n"))
+		case "tagged":
+			w.Header().Set("ETag", `"mine"`)
+			_, _ = w.Write([]byte("page\n"))
+		default:
+			_, _ = w.Write([]byte("page\n"))
+		}
+	}
+	h := server.Chain(page, Middleware())
+	strong := StrongETag([]byte("page\n"))
+
+	// Test: Buffered bodies get a strong ETag
+	out := serve(t, h, newRequest(t, "GET"))
+	assert.Contains(t, out, "etag: "+strong+"\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\npage\n"))
+
+	// Test: A matching If-None-Match gives 304 without a body
+	out = serve(t, h, newRequest(t, "GET", "If-None-Match: "+strong+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+	assert.Contains(t, out, "etag: "+strong+"\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.NotContains(t, out, "content-length")
+
+	// Test: HEAD is answered the same way
+	out = serve(t, h, newRequest(t, "HEAD", "If-None-Match: "+strong+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+
+	// Test: A stale If-Match gives 412
+	out = serve(t, h, newRequest(t, "GET", `If-Match: "old"`+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"), out)
+
+	// Test: The handler's own ETag is kept
+	out = serve(t, h, newRequest(t, "GET", "X-Mode: tagged\r\n", `If-None-Match: "mine"`+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+
+	// Test: Streamed bodies get a weak ETag from Last-Modified
+	out = serve(t, h, newRequest(t, "GET", "X-Mode: stream\r\n"))
+	assert.Contains(t, out, "etag: "+WeakETag(modTime)+"\r\n")
+	assert.Contains(t, out, "streamed ")
+	out = serve(t, h, newRequest(t, "GET", "X-Mode: stream\r\n", "If-Modified-Since: "+modTime.Format(TimeFormat)+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"), out)
+	assert.NotContains(t, out, "streamed")
+
+	// Test: Error responses are left alone
+	out = serve(t, h, newRequest(t, "GET", "X-Mode: error\r\n", "If-None-Match: *\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"), out)
+	assert.NotContains(t, out, "etag")
+
+	// Test: Unsafe methods pass through
+	out = serve(t, h, newRequest(t, "POST", `If-Match: "old"`+"\r\n"))
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"), out)
+	assert.NotContains(t, out, "etag")
+}
@@ -0,0 +1,211 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SameSite controls whether a cookie is sent with cross-site requests.
+type SameSite int
+
+const (
+	// SameSiteDefault omits the attribute and leaves the choice to the
+	// browser, which currently treats it as Lax.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// expiresFormat is the date layout for the Expires attribute.
+const expiresFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+// ErrInvalidCookie is wrapped by the errors Valid returns.
+var ErrInvalidCookie = errors.New("invalid cookie")
+
+// ErrNoCookie is returned when a request carries no cookie with the wanted
+// name.
+var ErrNoCookie = errors.New("named cookie not present")
+
+// Cookie is an HTTP cookie as sent by a client in Cookie or set by a server
+// with Set-Cookie (RFC 6265). Only Name and Value are filled in for cookies
+// parsed from a request.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is the lifetime in seconds. Zero leaves the attribute out and
+	// a negative value deletes the cookie right away (Max-Age=0).
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Parse reads the cookies in a Cookie request header value. Pairs with an
+// invalid name or value are skipped, as browsers do.
+func Parse(header string) []*Cookie {
+	var cookies []*Cookie
+	for _, pair := range strings.Split(header, ";") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok || !validName(name) {
+			continue
+		}
+		value, ok = parseValue(value)
+		if !ok {
+			continue
+		}
+		cookies = append(cookies, &Cookie{Name: name, Value: value})
+	}
+	return cookies
+}
+
+// Valid reports whether the cookie can be serialized into a Set-Cookie
+// header that browsers will accept.
+func (c *Cookie) Valid() error {
+	if !validName(c.Name) {
+		return fmt.Errorf("%w: name %q", ErrInvalidCookie, c.Name)
+	}
+	if _, ok := parseValue(c.Value); !ok {
+		return fmt.Errorf("%w: value for %q", ErrInvalidCookie, c.Name)
+	}
+	if !validAttrValue(c.Path) {
+		return fmt.Errorf("%w: path %q", ErrInvalidCookie, c.Path)
+	}
+	if c.Domain != "" && !validDomain(c.Domain) {
+		return fmt.Errorf("%w: domain %q", ErrInvalidCookie, c.Domain)
+	}
+	if !c.Expires.IsZero() && c.Expires.Year() < 1601 {
+		return fmt.Errorf("%w: expires %v", ErrInvalidCookie, c.Expires)
+	}
+	// Browsers drop these combinations, so refuse to send them.
+	if c.SameSite == SameSiteNone && !c.Secure {
+		return fmt.Errorf("%w: SameSite=None requires Secure", ErrInvalidCookie)
+	}
+	if c.Partitioned && !c.Secure {
+		return fmt.Errorf("%w: Partitioned requires Secure", ErrInvalidCookie)
+	}
+	return nil
+}
+
+// String serializes the cookie as a Set-Cookie value. It doesn't validate;
+// call Valid first when the fields come from untrusted input.
+func (c *Cookie) String() string {
+	var b strings.Builder
+	b.WriteString(c.Name)
+	b.WriteByte('=')
+	b.WriteString(c.Value)
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(expiresFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if s := c.SameSite.String(); s != "" {
+		b.WriteString("; SameSite=" + s)
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+	return b.String()
+}
+
+// validName reports whether name is an RFC 9110 token.
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, ch := range name {
+		if !((ch >= 'A' && ch <= 'Z') ||
+			(ch >= 'a' && ch <= 'z') ||
+			(ch >= '0' && ch <= '9') ||
+			strings.ContainsRune("!#$%&'*+-.^_|~`", ch)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseValue strips optional surrounding quotes and checks the remaining
+// bytes are cookie-octets (RFC 6265 section 4.1.1).
+func parseValue(v string) (string, bool) {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		v = v[1 : len(v)-1]
+	}
+	for i := 0; i < len(v); i++ {
+		ch := v[i]
+		if ch < 0x21 || ch > 0x7e || ch == '"' || ch == ',' || ch == ';' || ch == '\\' {
+			return "", false
+		}
+	}
+	return v, true
+}
+
+// validAttrValue reports whether an attribute value is free of control
+// characters and semicolons.
+func validAttrValue(v string) bool {
+	for i := 0; i < len(v); i++ {
+		if v[i] < 0x20 || v[i] == 0x7f || v[i] == ';' {
+			return false
+		}
+	}
+	return true
+}
+
+// validDomain accepts hostnames made of letters, digits, hyphens and dots,
+// with an optional leading dot.
+func validDomain(d string) bool {
+	d = strings.TrimPrefix(d, ".")
+	if d == "" || len(d) > 253 {
+		return false
+	}
+	for _, label := range strings.Split(d, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, ch := range label {
+			if !((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
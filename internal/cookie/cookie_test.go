@@ -0,0 +1,73 @@
+package cookie
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Several pairs
+	cookies := Parse("a=1; b=two;c=")
+	require.Len(t, cookies, 3)
+	assert.Equal(t, &Cookie{Name: "a", Value: "1"}, cookies[0])
+	assert.Equal(t, &Cookie{Name: "b", Value: "two"}, cookies[1])
+	assert.Equal(t, &Cookie{Name: "c", Value: ""}, cookies[2])
+
+	// Test: Quoted values are unquoted
+	cookies = Parse(`id="abc"`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "abc", cookies[0].Value)
+
+	// Test: Invalid pairs are skipped
+	cookies = Parse("bad name=1; noequals; ok=1; v=a,b; =x")
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// Test: Empty header
+	assert.Empty(t, Parse(""))
+}
+
+func TestString(t *testing.T) {
+	// Test: Name and value only
+	assert.Equal(t, "a=1", (&Cookie{Name: "a", Value: "1"}).String())
+
+	// Test: All attributes
+	c := &Cookie{
+		Name:        "sid",
+		Value:       "xyz",
+		Path:        "/app",
+		Domain:      ".example.com",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	assert.Equal(t, "sid=xyz; Path=/app; Domain=example.com; Expires=Wed, 02 Jan 2030 03:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", c.String())
+
+	// Test: Negative MaxAge deletes the cookie
+	assert.Equal(t, "a=; Max-Age=0", (&Cookie{Name: "a", MaxAge: -1}).String())
+}
+
+func TestValid(t *testing.T) {
+	// Test: Valid cookie
+	require.NoError(t, (&Cookie{Name: "a", Value: "1", Domain: "example.com", SameSite: SameSiteStrict}).Valid())
+
+	// Test: Invalid fields
+	for _, c := range []*Cookie{
+		{Name: "", Value: "1"},
+		{Name: "a b", Value: "1"},
+		{Name: "a", Value: "x;y"},
+		{Name: "a", Value: "new\nline"},
+		{Name: "a", Path: "/x;Secure"},
+		{Name: "a", Domain: "exa mple.com"},
+		{Name: "a", SameSite: SameSiteNone},
+		{Name: "a", Partitioned: true},
+	} {
+		assert.ErrorIs(t, c.Valid(), ErrInvalidCookie, c.String())
+	}
+}
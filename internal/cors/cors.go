@@ -0,0 +1,174 @@
+package cors
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultMethods are allowed when Options.AllowedMethods is empty: the
+// CORS-safelisted methods.
+var DefaultMethods = []string{"GET", "HEAD", "POST"}
+
+// Options configures the CORS middleware.
+type Options struct {
+	// AllowedOrigins lists the origins that may make cross-origin
+	// requests. An entry is an exact origin such as
+	// "https://app.example.com", a pattern with one "*" standing for a
+	// run of host or port characters, such as "https://*.example.com" or
+	// "http://localhost:*", or "*" for any origin.
+	AllowedOrigins []string
+	// AllowOriginFunc, if set, is consulted for origins AllowedOrigins
+	// doesn't match.
+	AllowOriginFunc func(origin string) bool
+	// AllowedMethods defaults to DefaultMethods.
+	AllowedMethods []string
+	// AllowedHeaders lists request headers a preflight may ask for, or "*"
+	// for any. Matching ignores case.
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers scripts may read beyond the
+	// safelisted ones.
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and HTTP auth for the
+	// origins listed. It can't be combined with "*": letting any site make
+	// credentialed requests would expose every user's data to it.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight answer. Zero
+	// leaves it to the browser; it is sent in whole seconds.
+	MaxAge time.Duration
+}
+
+// Middleware adds CORS headers to responses for allowed origins and
+// answers preflight requests itself with 204 No Content, so they never
+// reach the handler. Responses to requests with an Origin carry
+// Vary: Origin, since the headers depend on it.
+//
+// Middleware panics if AllowedOrigins contains "*" and AllowCredentials is
+// set.
+func Middleware(opts Options) server.Middleware {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = DefaultMethods
+	}
+	anyOrigin := slices.Contains(opts.AllowedOrigins, "*")
+	if anyOrigin && opts.AllowCredentials {
+		panic(`cors: AllowCredentials can't be used with the "*" origin`)
+	}
+	anyHeader := slices.Contains(opts.AllowedHeaders, "*")
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	exposed := strings.Join(opts.ExposedHeaders, ", ")
+
+	allowed := func(origin string) bool {
+		if anyOrigin {
+			return true
+		}
+		for _, o := range opts.AllowedOrigins {
+			if matchOrigin(o, origin) {
+				return true
+			}
+		}
+		return opts.AllowOriginFunc != nil && opts.AllowOriginFunc(origin)
+	}
+	// setOrigin writes the headers shared by preflights and actual
+	// requests.
+	setOrigin := func(w *response.Writer, origin string) {
+		h := w.Header()
+		if anyOrigin {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+		if opts.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin := req.Headers.Get("Origin")
+			if origin == "" {
+				next(w, req)
+				return
+			}
+			h := w.Header()
+			h.Add("Vary", "Origin")
+
+			reqMethod := req.Headers.Get("Access-Control-Request-Method")
+			if req.RequestLine.Method == "OPTIONS" && reqMethod != "" {
+				h.Add("Vary", "Access-Control-Request-Method, Access-Control-Request-Headers")
+				w.SetStatus(response.StatusNoContent)
+				// A refused preflight is still answered, just without the
+				// headers that would let the browser go ahead.
+				if !allowed(origin) || !slices.Contains(opts.AllowedMethods, reqMethod) {
+					return
+				}
+				reqHeaders := splitList(req.Headers.Get("Access-Control-Request-Headers"))
+				if !anyHeader && !allHeadersAllowed(reqHeaders, opts.AllowedHeaders) {
+					return
+				}
+				setOrigin(w, origin)
+				h.Set("Access-Control-Allow-Methods", methods)
+				if len(reqHeaders) > 0 {
+					// Echoing the request keeps "*" working with
+					// credentials, where browsers take it literally.
+					h.Set("Access-Control-Allow-Headers", strings.Join(reqHeaders, ", "))
+				}
+				if opts.MaxAge > 0 {
+					h.Set("Access-Control-Max-Age", strconv.Itoa(int(opts.MaxAge/time.Second)))
+				}
+				return
+			}
+
+			if allowed(origin) {
+				setOrigin(w, origin)
+				if exposed != "" {
+					h.Set("Access-Control-Expose-Headers", exposed)
+				}
+			}
+			next(w, req)
+		}
+	}
+}
+
+// matchOrigin reports whether origin matches pattern. A "*" in pattern
+// matches a non-empty run of characters other than "/", ":" and "@", so it
+// stays within one host label sequence or port and can't swallow a scheme
+// or userinfo.
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wild := strings.Cut(pattern, "*")
+	if !wild {
+		return strings.EqualFold(pattern, origin)
+	}
+	if len(origin) <= len(prefix)+len(suffix) {
+		return false
+	}
+	lower := strings.ToLower(origin)
+	if !strings.HasPrefix(lower, strings.ToLower(prefix)) || !strings.HasSuffix(lower, strings.ToLower(suffix)) {
+		return false
+	}
+	middle := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(middle, "/:@")
+}
+
+func allHeadersAllowed(requested, allowed []string) bool {
+	for _, r := range requested {
+		if !slices.ContainsFunc(allowed, func(a string) bool { return strings.EqualFold(a, r) }) {
+			return false
+		}
+	}
+	return true
+}
+
+func splitList(v string) []string {
+	var out []string
+	for _, part := range strings.Split(v, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			out = append(out, strings.ToLower(part))
+		}
+	}
+	return out
+}
@@ -0,0 +1,131 @@
+package cors
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// serve starts a server behind the CORS middleware and returns a function
+// sending method with the given header lines.
+func serve(t *testing.T, opts Options) func(method string, lines ...string) string {
+	t.Helper()
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		_, _ = w.Write([]byte("handler\n"))
+	}, Middleware(opts))
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return func(method string, lines ...string) string {
+		conn, err := net.DialTimeout("tcp", s.Addr().String(), 2*time.Second)
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		fmt.Fprint(conn, method+" /api HTTP/1.1\r\nHost: localhost\r\n"+strings.Join(lines, "")+"\r\n")
+		raw, err := io.ReadAll(bufio.NewReader(conn))
+		require.NoError(t, err)
+		return string(raw)
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	assert.True(t, matchOrigin("https://app.example.com", "https://APP.example.com"))
+	assert.False(t, matchOrigin("https://app.example.com", "http://app.example.com"))
+	assert.True(t, matchOrigin("https://*.example.com", "https://a.b.example.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "https://example.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "https://evil.com/.example.com"))
+	assert.False(t, matchOrigin("https://*.example.com", "https://evil.com:1.example.com"))
+	assert.True(t, matchOrigin("http://localhost:*", "http://localhost:5173"))
+	assert.False(t, matchOrigin("http://localhost:*", "http://localhost"))
+}
+
+func TestMiddleware(t *testing.T) {
+	get := serve(t, Options{
+		AllowedOrigins: []string{"https://app.example.com", "http://localhost:*"},
+		AllowedMethods: []string{"GET", "PUT"},
+		AllowedHeaders: []string{"Content-Type", "X-Request-ID"},
+		ExposedHeaders: []string{"X-Total-Count"},
+		MaxAge:         10 * time.Minute,
+	})
+
+	// Test: Requests without Origin are untouched
+	resp := get("GET")
+	assert.NotContains(t, resp, "access-control-")
+	assert.NotContains(t, resp, "vary:")
+
+	// Test: An allowed origin gets the CORS headers and reaches the handler
+	resp = get("GET", "Origin: http://localhost:5173\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: http://localhost:5173\r\n")
+	assert.Contains(t, resp, "access-control-expose-headers: X-Total-Count\r\n")
+	assert.Contains(t, resp, "vary: Origin\r\n")
+	assert.NotContains(t, resp, "access-control-allow-credentials")
+	assert.True(t, strings.HasSuffix(resp, "handler\n"))
+
+	// Test: Other origins still reach the handler, without CORS headers
+	resp = get("GET", "Origin: https://evil.example\r\n")
+	assert.NotContains(t, resp, "access-control-")
+	assert.Contains(t, resp, "vary: Origin\r\n")
+	assert.True(t, strings.HasSuffix(resp, "handler\n"))
+
+	// Test: A preflight is answered without calling the handler
+	resp = get("OPTIONS", "Origin: https://app.example.com\r\n", "Access-Control-Request-Method: PUT\r\n", "Access-Control-Request-Headers: content-type, X-Request-ID\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"), resp)
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, resp, "access-control-allow-methods: GET, PUT\r\n")
+	assert.Contains(t, resp, "access-control-allow-headers: content-type, x-request-id\r\n")
+	assert.Contains(t, resp, "access-control-max-age: 600\r\n")
+	assert.Contains(t, resp, "vary: Origin, Access-Control-Request-Method, Access-Control-Request-Headers\r\n")
+	assert.NotContains(t, resp, "handler")
+
+	// Test: Refused preflights get 204 without permission
+	for _, lines := range [][]string{
+		{"Origin: https://evil.example\r\n", "Access-Control-Request-Method: PUT\r\n"},
+		{"Origin: https://app.example.com\r\n", "Access-Control-Request-Method: DELETE\r\n"},
+		{"Origin: https://app.example.com\r\n", "Access-Control-Request-Method: PUT\r\n", "Access-Control-Request-Headers: Authorization\r\n"},
+	} {
+		resp = get("OPTIONS", lines...)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"), resp)
+		assert.NotContains(t, resp, "access-control-allow-origin")
+		assert.NotContains(t, resp, "handler")
+	}
+
+	// Test: A plain OPTIONS request isn't a preflight
+	resp = get("OPTIONS", "Origin: https://app.example.com\r\n")
+	assert.True(t, strings.HasSuffix(resp, "handler\n"))
+}
+
+func TestMiddlewareWildcard(t *testing.T) {
+	// Test: "*" is sent as is without credentials
+	get := serve(t, Options{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}})
+	resp := get("GET", "Origin: https://anywhere.example\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+	resp = get("OPTIONS", "Origin: https://anywhere.example\r\n", "Access-Control-Request-Method: POST\r\n", "Access-Control-Request-Headers: X-Anything\r\n")
+	assert.Contains(t, resp, "access-control-allow-headers: x-anything\r\n")
+
+	// Test: "*" can't be combined with credentials
+	assert.Panics(t, func() {
+		Middleware(Options{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	})
+
+	// Test: With credentials a listed origin is echoed
+	get = serve(t, Options{AllowedOrigins: []string{"https://*.example"}, AllowCredentials: true})
+	resp = get("GET", "Origin: https://app.example\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example\r\n")
+	assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+
+	// Test: AllowOriginFunc decides for unmatched origins
+	get = serve(t, Options{AllowOriginFunc: func(o string) bool { return strings.HasSuffix(o, ".test") }})
+	assert.Contains(t, get("GET", "Origin: http://a.test\r\n"), "access-control-allow-origin: http://a.test\r\n")
+	assert.NotContains(t, get("GET", "Origin: http://a.example\r\n"), "access-control-allow-origin")
+}
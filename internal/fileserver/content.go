@@ -0,0 +1,161 @@
+package fileserver
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// ServeContent answers req with the bytes of content, honouring conditional
+// headers and Range requests. name is only used to pick a content type from
+// its extension when the handler hasn't set Content-Type. If the handler set
+// an ETag header it is used for If-Match, If-None-Match and If-Range; a
+// non-zero modTime is sent as Last-Modified and used for the date-based
+// checks. Failed preconditions are answered with 304 or 412.
+func ServeContent(w *response.Writer, req *request.Request, name string, modTime time.Time, content io.ReadSeeker) {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = content.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		log.Printf("fileserver: seeking %s: %v\n", name, err)
+		writeStatus(w, response.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	modTime = modTime.UTC().Truncate(time.Second)
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.Format(conditional.TimeFormat))
+	}
+	etag := h.Get("ETag")
+	if checkPreconditions(w, req, etag, modTime) {
+		return
+	}
+
+	if h.Get("Content-Type") == "" {
+		contentType, _, err := detectType(name, content)
+		if err != nil {
+			log.Printf("fileserver: reading %s: %v\n", name, err)
+			writeStatus(w, response.StatusInternalServerError)
+			return
+		}
+		h.Set("Content-Type", contentType)
+	}
+	h.Set("Accept-Ranges", "bytes")
+
+	var ranges []ByteRange
+	if rh := req.Headers.Get("Range"); rh != "" && req.RequestLine.Method == "GET" && rangeAllowed(req, etag, modTime) {
+		ranges, err = ParseRange(rh, size)
+		if errors.Is(err, ErrUnsatisfiableRange) {
+			h.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			writeStatus(w, response.StatusRangeNotSatisfiable)
+			return
+		}
+		// A malformed header, or one asking for more than the whole content
+		// (overlapping ranges can), is ignored and the full body is sent.
+		if err != nil || sumRanges(ranges) > size {
+			ranges = nil
+		}
+	}
+
+	switch len(ranges) {
+	case 0:
+		h.Set("Content-Length", strconv.FormatInt(size, 10))
+		w.SetStatus(response.StatusOk)
+		sendRange(w, content, name, ByteRange{Start: 0, Length: size})
+	case 1:
+		h.Set("Content-Range", ranges[0].ContentRange(size))
+		h.Set("Content-Length", strconv.FormatInt(ranges[0].Length, 10))
+		w.SetStatus(response.StatusPartialContent)
+		sendRange(w, content, name, ranges[0])
+	default:
+		serveMultipart(w, content, name, ranges, size)
+	}
+}
+
+// serveMultipart sends several ranges as a multipart/byteranges body. Part
+// headers are built up front so the total Content-Length is known and the
+// ranges can still go out through ReadFrom.
+func serveMultipart(w *response.Writer, content io.ReadSeeker, name string, ranges []ByteRange, size int64) {
+	boundary := newBoundary()
+	h := w.Header()
+	contentType := h.Get("Content-Type")
+
+	partHeaders := make([]string, len(ranges))
+	total := int64(0)
+	for i, r := range ranges {
+		partHeaders[i] = fmt.Sprintf("\r\n--%s\r\nContent-Type: %s\r\nContent-Range: %s\r\n\r\n",
+			boundary, contentType, r.ContentRange(size))
+		total += int64(len(partHeaders[i])) + r.Length
+	}
+	closing := fmt.Sprintf("\r\n--%s--\r\n", boundary)
+	total += int64(len(closing))
+
+	h.Set("Content-Type", "multipart/byteranges; boundary="+boundary)
+	h.Set("Content-Length", strconv.FormatInt(total, 10))
+	w.SetStatus(response.StatusPartialContent)
+	for i, r := range ranges {
+		if _, err := io.WriteString(w, partHeaders[i]); err != nil {
+			return
+		}
+		if !sendRange(w, content, name, r) {
+			return
+		}
+	}
+	io.WriteString(w, closing)
+}
+
+// sendRange copies one range of content to w, reporting whether it got
+// through without error.
+func sendRange(w *response.Writer, content io.ReadSeeker, name string, r ByteRange) bool {
+	if _, err := content.Seek(r.Start, io.SeekStart); err != nil {
+		log.Printf("fileserver: seeking %s: %v\n", name, err)
+		return false
+	}
+	if _, err := w.ReadFrom(io.LimitReader(content, r.Length)); err != nil {
+		log.Printf("fileserver: sending %s: %v\n", name, err)
+		return false
+	}
+	return true
+}
+
+// rangeAllowed evaluates If-Range: the Range header only applies when the
+// validator still matches. An entity tag must match strongly; a date must
+// equal Last-Modified exactly (RFC 9110 section 13.1.5).
+func rangeAllowed(req *request.Request, etag string, modTime time.Time) bool {
+	ir := req.Headers.Get("If-Range")
+	if ir == "" {
+		return true
+	}
+	if strings.HasPrefix(ir, "\"") || strings.HasPrefix(ir, "W/") {
+		return etag != "" && !strings.HasPrefix(etag, "W/") && ir == etag
+	}
+	t, err := time.Parse(conditional.TimeFormat, ir)
+	return err == nil && !modTime.IsZero() && t.Equal(modTime)
+}
+
+func sumRanges(ranges []ByteRange) int64 {
+	var total int64
+	for _, r := range ranges {
+		total += r.Length
+	}
+	return total
+}
+
+// newBoundary returns a random multipart boundary.
+func newBoundary() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,252 @@
+package fileserver
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"httpfromtcp/internal/conditional"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// sniffLen is how many bytes are inspected when the file extension doesn't
+// identify the content type.
+const sniffLen = 512
+
+// Options configures the handler returned by Handler.
+type Options struct {
+	// Prefix is stripped from the request path before it is looked up in
+	// the file system, so "/assets/" can serve the root of the FS.
+	Prefix string
+	// IndexFile is served for directory requests. Defaults to index.html.
+	IndexFile string
+	// ListDirectories enables an HTML listing for directories that have no
+	// index file. Without it such requests get 403.
+	ListDirectories bool
+}
+
+// Handler returns a server.Handler that serves files from root. Only GET
+// (and HEAD, which the server presents as GET) is allowed.
+func Handler(root fs.FS, opts Options) server.Handler {
+	if opts.IndexFile == "" {
+		opts.IndexFile = "index.html"
+	}
+	return func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.Method != "GET" {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeStatus(w, response.StatusMethodNotAllowed)
+			return
+		}
+
+		if req.Target().Form != request.OriginForm {
+			writeStatus(w, response.StatusBadRequest)
+			return
+		}
+		// Look up the path the router matched, with dot segments resolved,
+		// so "/x/../assets/a.txt" is "/assets/a.txt". They can't climb out
+		// of the prefix this way: "/assets/../a.txt" is outside it.
+		urlPath := req.CleanPath()
+		rest, found := strings.CutPrefix(urlPath, opts.Prefix)
+		if !found || (rest != "" && !strings.HasPrefix(rest, "/") && !strings.HasSuffix(opts.Prefix, "/")) {
+			writeStatus(w, response.StatusNotFound)
+			return
+		}
+		name, ok := fsName(rest)
+		if !ok {
+			writeStatus(w, response.StatusForbidden)
+			return
+		}
+
+		info, err := fs.Stat(root, name)
+		if err != nil {
+			writeStatus(w, statusForError(err))
+			return
+		}
+		if !info.IsDir() {
+			ServeFile(w, req, root, name)
+			return
+		}
+
+		// Directories are addressed with a trailing slash so relative links
+		// in the index or listing resolve inside them.
+		if !strings.HasSuffix(urlPath, "/") {
+			w.Header().Set("Location", (&url.URL{Path: urlPath + "/"}).EscapedPath())
+			writeStatus(w, response.StatusMovedPermanently)
+			return
+		}
+		index := path.Join(name, opts.IndexFile)
+		if _, err := fs.Stat(root, index); err == nil {
+			ServeFile(w, req, root, index)
+			return
+		}
+		if !opts.ListDirectories {
+			writeStatus(w, response.StatusForbidden)
+			return
+		}
+		listDirectory(w, root, name, urlPath)
+	}
+}
+
+// ServeFile answers req with the named file from fsys, handling the content
+// type, validators, conditional GET and, for seekable files, Range requests.
+// name must be a valid fs.FS path.
+func ServeFile(w *response.Writer, req *request.Request, fsys fs.FS, name string) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		writeStatus(w, statusForError(err))
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		writeStatus(w, statusForError(err))
+		return
+	}
+	if info.IsDir() {
+		writeStatus(w, response.StatusForbidden)
+		return
+	}
+
+	modTime := info.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf("\"%x-%x\"", modTime.Unix(), info.Size())
+	h := w.Header()
+	h.Set("ETag", etag)
+	if rs, ok := f.(io.ReadSeeker); ok {
+		ServeContent(w, req, name, modTime, rs)
+		return
+	}
+
+	// Without Seek there are no ranges; stream the whole file.
+	if !modTime.IsZero() {
+		h.Set("Last-Modified", modTime.Format(conditional.TimeFormat))
+	}
+	if checkPreconditions(w, req, etag, modTime) {
+		return
+	}
+	contentType, body, err := detectType(name, f)
+	if err != nil {
+		log.Printf("fileserver: reading %s: %v\n", name, err)
+		writeStatus(w, response.StatusInternalServerError)
+		return
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.SetStatus(response.StatusOk)
+	if _, err := w.ReadFrom(body); err != nil {
+		log.Printf("fileserver: sending %s: %v\n", name, err)
+	}
+}
+
+// fsName turns a decoded URL path into an fs.FS name. It refuses paths that
+// try to climb out of the root or smuggle separators or NUL bytes through
+// percent-encoding.
+func fsName(urlPath string) (string, bool) {
+	if strings.ContainsAny(urlPath, "\\\x00") {
+		return "", false
+	}
+	for _, seg := range strings.Split(urlPath, "/") {
+		if seg == ".." {
+			return "", false
+		}
+	}
+	name := strings.TrimPrefix(path.Clean("/"+urlPath), "/")
+	if name == "" {
+		name = "."
+	}
+	return name, fs.ValidPath(name)
+}
+
+// checkPreconditions answers req with 304 or 412 when its conditional
+// headers call for it, and reports whether it did.
+func checkPreconditions(w *response.Writer, req *request.Request, etag string, modTime time.Time) bool {
+	switch conditional.Evaluate(req, conditional.Validators{ETag: etag, LastModified: modTime}) {
+	case response.StatusNotModified:
+		w.SetStatus(response.StatusNotModified)
+	case response.StatusPreconditionFailed:
+		writeStatus(w, response.StatusPreconditionFailed)
+	default:
+		return false
+	}
+	return true
+}
+
+// detectType picks a content type from the file extension, sniffing the
+// first bytes when that fails. The returned reader yields the whole file.
+func detectType(name string, f io.Reader) (string, io.Reader, error) {
+	if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+		return ct, f, nil
+	}
+	// Seek back when possible so the file itself still reaches ReadFrom.
+	if s, ok := f.(io.ReadSeeker); ok {
+		buf := make([]byte, sniffLen)
+		n, err := io.ReadFull(s, buf)
+		if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+			return "", nil, err
+		}
+		if _, err := s.Seek(0, io.SeekStart); err != nil {
+			return "", nil, err
+		}
+		return http.DetectContentType(buf[:n]), f, nil
+	}
+	buf := make([]byte, sniffLen)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", nil, err
+	}
+	return http.DetectContentType(buf[:n]), io.MultiReader(bytes.NewReader(buf[:n]), f), nil
+}
+
+// listDirectory writes an HTML index of the entries in dir, which fs.ReadDir
+// returns sorted by name.
+func listDirectory(w *response.Writer, root fs.FS, dir, urlPath string) {
+	entries, err := fs.ReadDir(root, dir)
+	if err != nil {
+		writeStatus(w, statusForError(err))
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	title := html.EscapeString(urlPath)
+	fmt.Fprintf(w, "<html>\n<head><title>Index of %s</title></head>\n<body>\n<h1>Index of %s</h1>\n<ul>\n", title, title)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() {
+			name += "/"
+		}
+		// The "./" keeps names containing a colon from parsing as a scheme.
+		link := "./" + (&url.URL{Path: name}).EscapedPath()
+		fmt.Fprintf(w, "<li><a href=\"%s\">%s</a></li>\n", html.EscapeString(link), html.EscapeString(name))
+	}
+	fmt.Fprint(w, "</ul>\n</body>\n</html>\n")
+}
+
+// statusForError maps file system errors to response statuses.
+func statusForError(err error) response.StatusCode {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return response.StatusNotFound
+	case errors.Is(err, fs.ErrPermission):
+		return response.StatusForbidden
+	default:
+		return response.StatusInternalServerError
+	}
+}
+
+// writeStatus sends a plain-text body naming the status.
+func writeStatus(w *response.Writer, status response.StatusCode) {
+	w.SetStatus(status)
+	fmt.Fprintf(w, "%d %s\n", status, response.StatusText(status))
+}
@@ -0,0 +1,217 @@
+package fileserver
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+var testFS = fstest.MapFS{
+	"hello.txt":       {Data: []byte("hello world"), ModTime: modTime},
+	"site/index.html": {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+	"files/a b.txt":   {Data: []byte("a"), ModTime: modTime},
+	"files/<script>":  {Data: []byte("b"), ModTime: modTime},
+	"files/noext":     {Data: []byte("%PDF-1.4 rest"), ModTime: modTime},
+}
+
+// serve runs h against a raw request and returns the raw response.
+func serve(t *testing.T, h server.Handler, raw string) string {
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(testFS, Options{Prefix: "/static", ListDirectories: true})
+
+	// Test: Regular file with type, length and validators
+	out := serve(t, h, "GET /static/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/plain; charset=utf-8\r\n")
+	assert.Contains(t, out, "content-length: 11\r\n")
+	assert.Contains(t, out, "last-modified: Wed, 01 May 2024 12:00:00 GMT\r\n")
+	assert.Contains(t, out, "etag: \"")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+
+	// Test: Matching If-None-Match gives 304 without a body
+	etag := out[strings.Index(out, "etag: ")+6:]
+	etag = etag[:strings.Index(etag, "\r\n")]
+	out = serve(t, h, "GET /static/hello.txt HTTP/1.1\r\nHost: x\r\nIf-None-Match: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: If-Modified-Since at the modification time gives 304
+	out = serve(t, h, "GET /static/hello.txt HTTP/1.1\r\nHost: x\r\nIf-Modified-Since: Wed, 01 May 2024 12:00:00 GMT\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 304 Not Modified\r\n"))
+
+	// Test: Older If-Modified-Since sends the file
+	out = serve(t, h, "GET /static/hello.txt HTTP/1.1\r\nHost: x\r\nIf-Modified-Since: Tue, 30 Apr 2024 12:00:00 GMT\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: A stale If-Match gives 412
+	out = serve(t, h, "GET /static/hello.txt HTTP/1.1\r\nHost: x\r\nIf-Match: \"other\"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 412 Precondition Failed\r\n"))
+	out = serve(t, h, "GET /static/hello.txt HTTP/1.1\r\nHost: x\r\nIf-Match: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: Dot segments are resolved before the lookup
+	out = serve(t, h, "GET /x/../static/./hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello world"))
+
+	// Test: so they can't climb out of the prefix
+	out = serve(t, h, "GET /static/../hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	out = serve(t, h, "GET /static/%2e%2e/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Encoded separators are refused
+	out = serve(t, h, "GET /static/..%5chello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Missing file and paths outside the prefix are 404
+	out = serve(t, h, "GET /static/nope.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+	out = serve(t, h, "GET /staticky/hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 404 Not Found\r\n"))
+
+	// Test: Directory without slash redirects, with slash serves its index
+	out = serve(t, h, "GET /static/site HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 301 Moved Permanently\r\n"))
+	assert.Contains(t, out, "location: /static/site/\r\n")
+	out = serve(t, h, "GET /static/site/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, "content-type: text/html; charset=utf-8\r\n")
+	assert.True(t, strings.HasSuffix(out, "<h1>home</h1>"))
+
+	// Test: Listing escapes names
+	out = serve(t, h, "GET /static/files/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, `<a href="./a%20b.txt">a b.txt</a>`)
+	assert.Contains(t, out, `&lt;script&gt;`)
+	assert.NotContains(t, out, "<script>")
+
+	// Test: Unknown extensions are sniffed
+	out = serve(t, h, "GET /static/files/noext HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, "content-type: application/pdf\r\n")
+	assert.True(t, strings.HasSuffix(out, "%PDF-1.4 rest"))
+
+	// Test: Listing is off unless enabled
+	h = Handler(testFS, Options{})
+	out = serve(t, h, "GET /files/ HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Other methods are rejected
+	out = serve(t, h, "DELETE /hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 405 Method Not Allowed\r\n"))
+	assert.Contains(t, out, "allow: GET, HEAD\r\n")
+}
+
+func TestParseRange(t *testing.T) {
+	// Test: Single, open-ended and suffix ranges
+	r, err := ParseRange("bytes=0-4", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 5}}, r)
+	r, err = ParseRange("bytes=7-", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 7, Length: 3}}, r)
+	r, err = ParseRange("bytes=-3", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 7, Length: 3}}, r)
+
+	// Test: Ends past the content are clamped, starts past it dropped
+	r, err = ParseRange("bytes=5-100, 20-30", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 5, Length: 5}}, r)
+
+	// Test: Multiple ranges
+	r, err = ParseRange("bytes=0-0, -1", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []ByteRange{{Start: 0, Length: 1}, {Start: 9, Length: 1}}, r)
+
+	// Test: Unsatisfiable
+	_, err = ParseRange("bytes=10-", 10)
+	assert.ErrorIs(t, err, ErrUnsatisfiableRange)
+
+	// Test: Malformed
+	_, err = ParseRange("items=0-1", 10)
+	assert.Error(t, err)
+	_, err = ParseRange("bytes=5-1", 10)
+	assert.Error(t, err)
+	_, err = ParseRange("bytes=abc", 10)
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrUnsatisfiableRange)
+}
+
+func TestServeContentRanges(t *testing.T) {
+	h := Handler(testFS, Options{})
+	lastMod := "Wed, 01 May 2024 12:00:00 GMT"
+
+	// Test: Single range gives 206 with Content-Range
+	out := serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	assert.Contains(t, out, "content-range: bytes 0-4/11\r\n")
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Full responses advertise range support
+	out = serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\n\r\n")
+	assert.Contains(t, out, "accept-ranges: bytes\r\n")
+
+	// Test: Multiple ranges use multipart/byteranges
+	out = serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-1,-2\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	ct := out[strings.Index(out, "content-type: ")+len("content-type: "):]
+	ct = ct[:strings.Index(ct, "\r\n")]
+	require.True(t, strings.HasPrefix(ct, "multipart/byteranges; boundary="))
+	boundary := strings.TrimPrefix(ct, "multipart/byteranges; boundary=")
+	body := out[strings.Index(out, "\r\n\r\n")+4:]
+	assert.Equal(t, "\r\n--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 0-1/11\r\n\r\nhe"+
+		"\r\n--"+boundary+"\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Range: bytes 9-10/11\r\n\r\nld"+
+		"\r\n--"+boundary+"--\r\n", body)
+	assert.Contains(t, out, "content-length: "+strconv.Itoa(len(body))+"\r\n")
+
+	// Test: Unsatisfiable range gives 416
+	out = serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=50-60\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 416 Range Not Satisfiable\r\n"))
+	assert.Contains(t, out, "content-range: bytes */11\r\n")
+
+	// Test: If-Range with the current date or ETag applies the range
+	out = serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4\r\nIf-Range: "+lastMod+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+	etag := out[strings.Index(out, "etag: ")+6:]
+	etag = etag[:strings.Index(etag, "\r\n")]
+	out = serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4\r\nIf-Range: "+etag+"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 206 Partial Content\r\n"))
+
+	// Test: Stale If-Range sends the whole file
+	out = serve(t, h, "GET /hello.txt HTTP/1.1\r\nHost: x\r\nRange: bytes=0-4\r\nIf-Range: \"other\"\r\n\r\n")
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(out, "hello world"))
+
+	// Test: ServeContent works for any seekable source
+	req, err := request.RequestFromReader(strings.NewReader("GET /x HTTP/1.1\r\nHost: x\r\nRange: bytes=-3\r\n\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	ServeContent(w, req, "data.json", time.Time{}, strings.NewReader(`{"a":1}`))
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.Contains(t, out, "content-range: bytes 4-6/7\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n:1}"))
+}
@@ -0,0 +1,94 @@
+package fileserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsatisfiableRange is returned by ParseRange when none of the requested
+// ranges overlap the content.
+var ErrUnsatisfiableRange = errors.New("no requested range overlaps the content")
+
+// ByteRange is a resolved byte range: Length bytes starting at Start.
+type ByteRange struct {
+	Start  int64
+	Length int64
+}
+
+// ContentRange formats the range as a Content-Range value for content of the
+// given total size.
+func (r ByteRange) ContentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.Start, r.Start+r.Length-1, size)
+}
+
+// ParseRange parses a Range header value (RFC 9110 section 14.2) against
+// content of the given size. Ranges that start past the end are dropped and
+// ends past the end are clamped; ErrUnsatisfiableRange is returned when
+// nothing is left. Any other error means the header is malformed and should
+// be ignored.
+func ParseRange(s string, size int64) ([]ByteRange, error) {
+	spec, ok := strings.CutPrefix(s, "bytes=")
+	if !ok {
+		return nil, fmt.Errorf("unsupported range unit in %q", s)
+	}
+
+	var ranges []ByteRange
+	parsed := 0
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		first, last, ok := strings.Cut(part, "-")
+		if !ok {
+			return nil, fmt.Errorf("invalid range %q", part)
+		}
+		parsed++
+
+		var r ByteRange
+		if first == "" {
+			// Suffix range: the final N bytes.
+			n, err := strconv.ParseInt(last, 10, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("invalid suffix range %q", part)
+			}
+			if n == 0 || size == 0 {
+				continue
+			}
+			if n > size {
+				n = size
+			}
+			r = ByteRange{Start: size - n, Length: n}
+		} else {
+			start, err := strconv.ParseInt(first, 10, 64)
+			if err != nil || start < 0 {
+				return nil, fmt.Errorf("invalid range start %q", part)
+			}
+			end := size - 1
+			if last != "" {
+				end, err = strconv.ParseInt(last, 10, 64)
+				if err != nil || end < start {
+					return nil, fmt.Errorf("invalid range end %q", part)
+				}
+			}
+			if start >= size {
+				continue
+			}
+			if end >= size {
+				end = size - 1
+			}
+			r = ByteRange{Start: start, Length: end - start + 1}
+		}
+		ranges = append(ranges, r)
+	}
+
+	if parsed == 0 {
+		return nil, fmt.Errorf("empty range set in %q", s)
+	}
+	if len(ranges) == 0 {
+		return nil, ErrUnsatisfiableRange
+	}
+	return ranges, nil
+}
@@ -0,0 +1,232 @@
+package forwarded
+
+import (
+	"net/netip"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Options configures Resolve and the middleware.
+type Options struct {
+	// Trusted lists the proxies whose forwarding headers are believed.
+	// Anything the client itself sends is ignored, since the walk stops at
+	// the first hop not in this list. An empty list trusts nobody.
+	Trusted []netip.Prefix
+}
+
+// hop is what one proxy reported about the connection it received.
+type hop struct {
+	// ip is the zero Addr when the proxy hid or didn't know the address.
+	ip     netip.Addr
+	scheme string
+	host   string
+}
+
+// Middleware sets req.Client from the forwarding headers left by trusted
+// proxies, so handlers see the real client's address, scheme and host.
+func Middleware(opts Options) server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			req.Client = Resolve(req, opts)
+			next(w, req)
+		}
+	}
+}
+
+// Resolve works out the originating client of req. Starting from the peer
+// that connected to us, it walks the forwarding chain from the right for as
+// long as each hop is trusted, taking the address, scheme and host the last
+// trusted proxy reported. Forwarded (RFC 7239) wins over the X-Forwarded-*
+// headers when both are present; a malformed chain is ignored as a whole.
+func Resolve(req *request.Request, opts Options) request.ClientInfo {
+	client := req.ClientFromConn()
+	if !trusted(client.IP, opts.Trusted) {
+		return client
+	}
+	hops, ok := forwardedHops(req)
+	if !ok {
+		return client
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if h.scheme != "" {
+			client.Scheme = h.scheme
+		}
+		if h.host != "" {
+			client.Host = h.host
+		}
+		// An obfuscated or unknown address ends the walk: the last known
+		// hop is the best we have.
+		if !h.ip.IsValid() {
+			break
+		}
+		client.IP = h.ip
+		if !trusted(h.ip, opts.Trusted) {
+			break
+		}
+	}
+	return client
+}
+
+func trusted(ip netip.Addr, prefixes []netip.Prefix) bool {
+	if !ip.IsValid() {
+		return false
+	}
+	for _, p := range prefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedHops returns the chain from Forwarded if present, otherwise from
+// X-Forwarded-For and its companions. ok is false when there is none or it
+// can't be parsed.
+func forwardedHops(req *request.Request) ([]hop, bool) {
+	if v := req.Headers.Get("Forwarded"); v != "" {
+		return parseForwarded(v)
+	}
+	return parseXForwarded(
+		req.Headers.Get("X-Forwarded-For"),
+		req.Headers.Get("X-Forwarded-Proto"),
+		req.Headers.Get("X-Forwarded-Host"),
+	)
+}
+
+// parseForwarded parses a Forwarded field value: comma-separated elements
+// of semicolon-separated name=value pairs, values being tokens or quoted
+// strings.
+func parseForwarded(v string) ([]hop, bool) {
+	var hops []hop
+	for _, elem := range splitQuoted(v, ',') {
+		var h hop
+		for _, pair := range splitQuoted(elem, ';') {
+			pair = strings.TrimSpace(pair)
+			if pair == "" {
+				continue
+			}
+			name, value, found := strings.Cut(pair, "=")
+			if !found || name == "" {
+				return nil, false
+			}
+			value, ok := unquote(value)
+			if !ok {
+				return nil, false
+			}
+			switch strings.ToLower(name) {
+			case "for":
+				h.ip = nodeIP(value)
+			case "proto":
+				h.scheme = scheme(value)
+			case "host":
+				h.host = value
+			}
+		}
+		hops = append(hops, h)
+	}
+	return hops, len(hops) > 0
+}
+
+// parseXForwarded lines up X-Forwarded-Proto and -Host with X-Forwarded-For
+// from the right, so a single proto or host describes the nearest proxy.
+func parseXForwarded(forList, protoList, hostList string) ([]hop, bool) {
+	if forList == "" {
+		return nil, false
+	}
+	fors := splitList(forList)
+	protos := splitList(protoList)
+	hosts := splitList(hostList)
+	hops := make([]hop, len(fors))
+	for i, f := range fors {
+		hops[i].ip = nodeIP(f)
+		if j := len(protos) - len(fors) + i; j >= 0 {
+			hops[i].scheme = scheme(protos[j])
+		}
+		if j := len(hosts) - len(fors) + i; j >= 0 {
+			hops[i].host = hosts[j]
+		}
+	}
+	return hops, true
+}
+
+func splitList(v string) []string {
+	if strings.TrimSpace(v) == "" {
+		return nil
+	}
+	parts := strings.Split(v, ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return parts
+}
+
+// splitQuoted splits s on sep outside quoted strings.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	inQuote, escaped := false, false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case escaped:
+			escaped = false
+		case inQuote && c == '\\':
+			escaped = true
+		case c == '"':
+			inQuote = !inQuote
+		case !inQuote && c == sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unquote returns a token as is and a quoted string without its quotes and
+// escapes.
+func unquote(v string) (string, bool) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, `"`) {
+		return v, !strings.ContainsAny(v, "\" \t")
+	}
+	if len(v) < 2 || !strings.HasSuffix(v, `"`) {
+		return "", false
+	}
+	var b strings.Builder
+	for i := 1; i < len(v)-1; i++ {
+		if v[i] == '\\' && i+1 < len(v)-1 {
+			i++
+		}
+		b.WriteByte(v[i])
+	}
+	return b.String(), true
+}
+
+// nodeIP parses a node as found in Forwarded's "for" or X-Forwarded-For: an
+// IPv4 address or bracketed IPv6 one, either with an optional port, or a
+// bare IPv6 address. "unknown" and obfuscated identifiers give the zero
+// Addr.
+func nodeIP(node string) netip.Addr {
+	if ap, err := netip.ParseAddrPort(node); err == nil {
+		return ap.Addr().Unmap()
+	}
+	if strings.HasPrefix(node, "[") && strings.HasSuffix(node, "]") {
+		node = node[1 : len(node)-1]
+	}
+	if ip, err := netip.ParseAddr(node); err == nil && ip.Zone() == "" {
+		return ip.Unmap()
+	}
+	return netip.Addr{}
+}
+
+// scheme accepts the two schemes an HTTP server can be reached by.
+func scheme(v string) string {
+	v = strings.ToLower(v)
+	if v == "http" || v == "https" {
+		return v
+	}
+	return ""
+}
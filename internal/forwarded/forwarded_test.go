@@ -0,0 +1,93 @@
+package forwarded
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+)
+
+// newRequest parses a GET with the given extra header lines, as if it came
+// from remote.
+func newRequest(t *testing.T, remote string, lines ...string) *request.Request {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: internal:8080\r\n" + strings.Join(lines, "") + "\r\n"
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.Conn.RemoteAddr = net.TCPAddrFromAddrPort(netip.MustParseAddrPort(remote))
+	return req
+}
+
+func TestResolve(t *testing.T) {
+	opts := Options{Trusted: []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8:ffff::/48"),
+	}}
+	resolve := func(remote string, lines ...string) request.ClientInfo {
+		return Resolve(newRequest(t, remote, lines...), opts)
+	}
+	ip := netip.MustParseAddr
+
+	// Test: Without proxy headers the connection is the client
+	c := resolve("192.0.2.1:4000")
+	assert.Equal(t, request.ClientInfo{IP: ip("192.0.2.1"), Scheme: "http", Host: "internal:8080"}, c)
+
+	// Test: Headers from an untrusted peer are ignored
+	c = resolve("192.0.2.1:4000", "X-Forwarded-For: 203.0.113.9\r\n", "Forwarded: for=203.0.113.9;proto=https\r\n")
+	assert.Equal(t, ip("192.0.2.1"), c.IP)
+	assert.Equal(t, "http", c.Scheme)
+
+	// Test: Forwarded from a trusted proxy
+	c = resolve("10.0.0.2:4000", "Forwarded: for=192.0.2.60;proto=HTTPS;host=example.com\r\n")
+	assert.Equal(t, request.ClientInfo{IP: ip("192.0.2.60"), Scheme: "https", Host: "example.com"}, c)
+
+	// Test: The walk stops at the first untrusted hop, so a spoofed entry
+	// on the left is ignored
+	c = resolve("10.0.0.2:4000", `Forwarded: for=1.2.3.4, for=192.0.2.60;proto=https, for=10.0.0.3;proto=http`+"\r\n")
+	assert.Equal(t, ip("192.0.2.60"), c.IP)
+	assert.Equal(t, "https", c.Scheme)
+
+	// Test: Quoted IPv6 nodes with ports
+	c = resolve("[2001:db8:ffff::1]:4000", `Forwarded: for="[2001:db8:cafe::17]:4711";host="example.com:8443"`+"\r\n")
+	assert.Equal(t, ip("2001:db8:cafe::17"), c.IP)
+	assert.Equal(t, "example.com:8443", c.Host)
+
+	// Test: An obfuscated node ends the walk at the last known address
+	c = resolve("10.0.0.2:4000", "Forwarded: for=_hidden;proto=https\r\n")
+	assert.Equal(t, ip("10.0.0.2"), c.IP)
+	assert.Equal(t, "https", c.Scheme)
+
+	// Test: A malformed Forwarded header is ignored as a whole
+	c = resolve("10.0.0.2:4000", "Forwarded: for=\"192.0.2.60\r\n")
+	assert.Equal(t, ip("10.0.0.2"), c.IP)
+
+	// Test: Forwarded wins over X-Forwarded-For
+	c = resolve("10.0.0.2:4000", "Forwarded: for=192.0.2.60\r\n", "X-Forwarded-For: 192.0.2.99\r\n")
+	assert.Equal(t, ip("192.0.2.60"), c.IP)
+
+	// Test: X-Forwarded-For walked from the right, with proto and host
+	// lined up with it
+	c = resolve("10.0.0.2:4000",
+		"X-Forwarded-For: 1.2.3.4, 192.0.2.60, 10.0.0.3\r\n",
+		"X-Forwarded-Proto: https\r\n",
+		"X-Forwarded-Host: example.com\r\n",
+	)
+	assert.Equal(t, request.ClientInfo{IP: ip("192.0.2.60"), Scheme: "https", Host: "example.com"}, c)
+
+	// Test: Repeated header lines count as one list
+	c = resolve("10.0.0.2:4000", "X-Forwarded-For: 192.0.2.60\r\n", "X-Forwarded-For: 10.0.0.3\r\n")
+	assert.Equal(t, ip("192.0.2.60"), c.IP)
+
+	// Test: Unknown schemes are ignored
+	c = resolve("10.0.0.2:4000", "X-Forwarded-For: 192.0.2.60\r\n", "X-Forwarded-Proto: gopher\r\n")
+	assert.Equal(t, "http", c.Scheme)
+
+	// Test: An empty trusted list believes nothing
+	req := newRequest(t, "10.0.0.2:4000", "X-Forwarded-For: 192.0.2.60\r\n")
+	assert.Equal(t, ip("10.0.0.2"), Resolve(req, Options{}).IP)
+}
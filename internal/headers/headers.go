@@ -58,14 +58,8 @@ func (h Headers) Parse(data []byte) (n int, done bool, err error) {
 		}
 	}
 
-	// Normalize header name to lowercase for case-insensitive lookup and add to map
-	key := strings.ToLower(keyRaw)
-	if prev, ok := h[key]; ok && prev != "" {
-		// combine duplicate header values with a comma and space
-		h[key] = prev + ", " + value
-	} else {
-		h[key] = value
-	}
+	// Add normalizes the name to lowercase for case-insensitive lookup
+	h.Add(keyRaw, value)
 
 	// consumed is header line plus CRLF
 	consumed := idx + 2
@@ -79,3 +73,45 @@ func NewHeaders() Headers {
 func (h Headers) Get(key string) string {
 	return h[strings.ToLower(key)]
 }
+
+// Set stores value under the lowercased key, replacing any existing value.
+func (h Headers) Set(key, value string) {
+	h[strings.ToLower(key)] = value
+}
+
+// Del removes the header with the given name, if present.
+func (h Headers) Del(key string) {
+	delete(h, strings.ToLower(key))
+}
+
+// Add appends value to the header, combining it with any existing value.
+// Most fields are list-valued and join with ", ". Cookie pairs join with
+// "; " (RFC 9113 section 8.2.3). Set-Cookie values can't be combined at all,
+// so they are kept on separate lines of a newline-joined string; a newline
+// can't occur inside a field value, and Values splits them apart again.
+func (h Headers) Add(key, value string) {
+	key = strings.ToLower(key)
+	prev, ok := h[key]
+	if !ok || prev == "" {
+		h[key] = value
+		return
+	}
+	switch key {
+	case "set-cookie":
+		h[key] = prev + "\n" + value
+	case "cookie":
+		h[key] = prev + "; " + value
+	default:
+		h[key] = prev + ", " + value
+	}
+}
+
+// Values returns each line stored for key by Add. It is only useful for
+// fields that can't be combined, such as Set-Cookie.
+func (h Headers) Values(key string) []string {
+	v, ok := h[strings.ToLower(key)]
+	if !ok {
+		return nil
+	}
+	return strings.Split(v, "\n")
+}
@@ -80,3 +80,28 @@ func TestHeaders(t *testing.T) {
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
 }
+
+func TestHeadersAdd(t *testing.T) {
+	// Test: Repeated fields are combined with a comma
+	h := NewHeaders()
+	h.Add("Accept", "text/html")
+	h.Add("accept", "application/json")
+	assert.Equal(t, "text/html, application/json", h.Get("Accept"))
+
+	// Test: Set-Cookie lines stay separate
+	h.Add("Set-Cookie", "a=1")
+	h.Add("Set-Cookie", "b=2")
+	assert.Equal(t, []string{"a=1", "b=2"}, h.Values("set-cookie"))
+
+	// Test: Cookie fields are joined with a semicolon
+	data := []byte("Cookie: a=1\r\nCookie: b=2\r\n\r\n")
+	for done := false; !done; {
+		n, d, err := h.Parse(data)
+		require.NoError(t, err)
+		data, done = data[n:], d
+	}
+	assert.Equal(t, "a=1; b=2", h.Get("Cookie"))
+
+	// Test: Missing keys have no values
+	assert.Nil(t, h.Values("x-missing"))
+}
@@ -0,0 +1,198 @@
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// DefaultMaxBodySize bounds request bodies when DecodeOptions.MaxBytes is
+// zero.
+const DefaultMaxBodySize = 1 << 20
+
+// DecodeOptions tunes Decode.
+type DecodeOptions struct {
+	// MaxBytes is the largest body accepted. Zero means DefaultMaxBodySize.
+	MaxBytes int64
+	// AllowUnknownFields accepts object keys that don't match a field of
+	// the target instead of rejecting the request.
+	AllowUnknownFields bool
+}
+
+// DecodeError describes why a request body couldn't be decoded and which
+// status the client should get for it.
+type DecodeError struct {
+	Status response.StatusCode
+	Detail string
+}
+
+func (e *DecodeError) Error() string {
+	return e.Detail
+}
+
+// Decode reads the JSON request body into v, rejecting unknown fields,
+// trailing data and bodies over DefaultMaxBodySize. Errors are
+// *DecodeError values that WriteError turns into problem responses.
+func Decode(req *request.Request, v any) error {
+	return DecodeWithOptions(req, v, DecodeOptions{})
+}
+
+// DecodeWithOptions is Decode with explicit options.
+func DecodeWithOptions(req *request.Request, v any, opts DecodeOptions) error {
+	if opts.MaxBytes == 0 {
+		opts.MaxBytes = DefaultMaxBodySize
+	}
+	if !isJSON(req.Headers.Get("Content-Type")) {
+		return &DecodeError{Status: response.StatusUnsupportedMediaType, Detail: "request body must be application/json"}
+	}
+	// Checking the declared length first means an oversized upload is
+	// refused before the client is asked to send it.
+	tooLarge := &DecodeError{Status: response.StatusContentTooLarge, Detail: fmt.Sprintf("request body exceeds %d bytes", opts.MaxBytes)}
+	if req.ContentLength() > opts.MaxBytes {
+		return tooLarge
+	}
+	body, err := req.ReadBody()
+	switch {
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return tooLarge
+	case errors.Is(err, request.ErrUnsupportedEncoding):
+		return &DecodeError{Status: response.StatusUnsupportedMediaType, Detail: err.Error()}
+	case err != nil:
+		return &DecodeError{Status: response.StatusBadRequest, Detail: "reading request body: " + err.Error()}
+	}
+	if int64(len(body)) > opts.MaxBytes {
+		return tooLarge
+	}
+	if len(body) == 0 {
+		return &DecodeError{Status: response.StatusBadRequest, Detail: "request body is empty"}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if !opts.AllowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		return &DecodeError{Status: response.StatusBadRequest, Detail: describe(err)}
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return &DecodeError{Status: response.StatusBadRequest, Detail: "request body must contain a single JSON value"}
+	}
+	return nil
+}
+
+// describe turns encoding/json errors into messages fit for a client.
+func describe(err error) string {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return "malformed JSON: unexpected end of body"
+	case errors.As(err, &typeErr):
+		if typeErr.Field != "" {
+			return fmt.Sprintf("field %q must be %s", typeErr.Field, typeErr.Type)
+		}
+		return fmt.Sprintf("body must be %s", typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return "unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")
+	default:
+		return err.Error()
+	}
+}
+
+// isJSON accepts application/json and structured +json types.
+func isJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/json" || (strings.HasPrefix(mediaType, "application/") && strings.HasSuffix(mediaType, "+json"))
+}
+
+// Write sends v as a JSON response with the given status.
+func Write(w *response.Writer, status response.StatusCode, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.SetStatus(status)
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// Problem is an RFC 9457 problem details object.
+type Problem struct {
+	// Type is a URI identifying the kind of problem; "about:blank" when
+	// empty, meaning the status code says it all.
+	Type     string
+	Title    string
+	Status   response.StatusCode
+	Detail   string
+	Instance string
+	// Extensions are extra members serialized alongside the standard ones.
+	Extensions map[string]any
+}
+
+// NewProblem returns a problem for status whose title is the reason phrase.
+func NewProblem(status response.StatusCode, detail string) Problem {
+	return Problem{Title: response.StatusText(status), Status: status, Detail: detail}
+}
+
+// MarshalJSON flattens Extensions into the object, as RFC 9457 requires.
+func (p Problem) MarshalJSON() ([]byte, error) {
+	m := make(map[string]any, len(p.Extensions)+5)
+	for k, v := range p.Extensions {
+		m[k] = v
+	}
+	m["type"] = p.Type
+	if p.Type == "" {
+		m["type"] = "about:blank"
+	}
+	if p.Title != "" {
+		m["title"] = p.Title
+	}
+	if p.Status != 0 {
+		m["status"] = int(p.Status)
+	}
+	if p.Detail != "" {
+		m["detail"] = p.Detail
+	}
+	if p.Instance != "" {
+		m["instance"] = p.Instance
+	}
+	return json.Marshal(m)
+}
+
+// WriteProblem sends p as an application/problem+json response.
+func WriteProblem(w *response.Writer, p Problem) error {
+	if p.Status == 0 {
+		p.Status = response.StatusInternalServerError
+	}
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.SetStatus(p.Status)
+	_, err = w.Write(append(body, '\n'))
+	return err
+}
+
+// WriteError answers with a problem describing err. Decode errors keep their
+// status and detail; anything else becomes a 500 without internal details.
+func WriteError(w *response.Writer, err error) error {
+	var de *DecodeError
+	if errors.As(err, &de) {
+		return WriteProblem(w, NewProblem(de.Status, de.Detail))
+	}
+	return WriteProblem(w, NewProblem(response.StatusInternalServerError, ""))
+}
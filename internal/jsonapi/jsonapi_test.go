@@ -0,0 +1,101 @@
+package jsonapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+type greeting struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func jsonRequest(t *testing.T, contentType, body string) *request.Request {
+	raw := fmt.Sprintf("POST /api HTTP/1.1\r\nHost: x\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n%s", contentType, len(body), body)
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	return req
+}
+
+func decodeStatus(t *testing.T, err error) response.StatusCode {
+	var de *DecodeError
+	require.True(t, errors.As(err, &de), "want *DecodeError, got %v", err)
+	return de.Status
+}
+
+func TestDecode(t *testing.T) {
+	// Test: Valid body
+	var g greeting
+	require.NoError(t, Decode(jsonRequest(t, "application/json; charset=utf-8", `{"name":"ada","count":2}`), &g))
+	assert.Equal(t, greeting{Name: "ada", Count: 2}, g)
+
+	// Test: +json media types are accepted
+	require.NoError(t, Decode(jsonRequest(t, "application/merge-patch+json", `{"name":"x"}`), &g))
+
+	// Test: Wrong content type is 415
+	err := Decode(jsonRequest(t, "text/plain", `{}`), &g)
+	assert.Equal(t, response.StatusUnsupportedMediaType, decodeStatus(t, err))
+
+	// Test: Unknown fields are rejected unless allowed
+	req := jsonRequest(t, "application/json", `{"name":"x","extra":1}`)
+	err = Decode(req, &g)
+	assert.Equal(t, response.StatusBadRequest, decodeStatus(t, err))
+	assert.Contains(t, err.Error(), `"extra"`)
+	require.NoError(t, DecodeWithOptions(req, &g, DecodeOptions{AllowUnknownFields: true}))
+
+	// Test: Size limit is 413
+	err = DecodeWithOptions(jsonRequest(t, "application/json", `{"name":"toolong"}`), &g, DecodeOptions{MaxBytes: 5})
+	assert.Equal(t, response.StatusContentTooLarge, decodeStatus(t, err))
+
+	// Test: Syntax, type and trailing-data errors are 400
+	for _, body := range []string{`{"name":`, `{"count":"two"}`, `{} {}`, ``} {
+		err = Decode(jsonRequest(t, "application/json", body), &g)
+		assert.Equal(t, response.StatusBadRequest, decodeStatus(t, err), body)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	// Test: JSON response
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	require.NoError(t, Write(w, response.StatusOk, greeting{Name: "ada"}))
+	require.NoError(t, w.Finish())
+	out := buf.String()
+	assert.Contains(t, out, "content-type: application/json\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n{\"name\":\"ada\",\"count\":0}\n"))
+
+	// Test: Decode errors become problem+json
+	buf.Reset()
+	w = response.NewWriter(&buf)
+	require.NoError(t, WriteError(w, &DecodeError{Status: response.StatusBadRequest, Detail: "bad"}))
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "content-type: application/problem+json\r\n")
+	var p map[string]any
+	require.NoError(t, json.Unmarshal([]byte(out[strings.Index(out, "\r\n\r\n")+4:]), &p))
+	assert.Equal(t, map[string]any{"type": "about:blank", "title": "Bad Request", "status": float64(400), "detail": "bad"}, p)
+
+	// Test: Extensions sit next to the standard members
+	b, err := json.Marshal(Problem{Type: "https://example.com/out-of-credit", Status: 403, Extensions: map[string]any{"balance": 30}})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"https://example.com/out-of-credit","status":403,"balance":30}`, string(b))
+
+	// Test: Other errors hide their details
+	buf.Reset()
+	w = response.NewWriter(&buf)
+	require.NoError(t, WriteError(w, errors.New("db password is hunter2")))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 500 Internal Server Error\r\n"))
+	assert.NotContains(t, buf.String(), "hunter2")
+}
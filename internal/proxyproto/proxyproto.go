@@ -0,0 +1,289 @@
+package proxyproto
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultHeaderTimeout bounds how long a connection may take to send its
+// PROXY header when Options.HeaderTimeout is zero.
+const DefaultHeaderTimeout = 5 * time.Second
+
+// ErrInvalidHeader is returned by reads on a connection from a trusted peer
+// whose PROXY header is missing or malformed.
+var ErrInvalidHeader = errors.New("proxyproto: invalid PROXY header")
+
+// v2Signature starts every version 2 header.
+var v2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// v1MaxLen is the longest version 1 line, CRLF included.
+const v1MaxLen = 107
+
+// Options configures a Listener.
+type Options struct {
+	// Trusted lists the peers allowed to speak PROXY protocol, normally the
+	// load balancers. Connections from them must start with a header;
+	// others are passed through untouched, so a client can't spoof its
+	// address. An empty list trusts every peer, which is only safe when
+	// nothing but the load balancer can reach the listener.
+	Trusted []netip.Prefix
+	// HeaderTimeout bounds reading the header when RemoteAddr or LocalAddr
+	// is asked for before the first Read. Reads use the connection's own
+	// deadline. Zero means DefaultHeaderTimeout.
+	HeaderTimeout time.Duration
+}
+
+// Listener accepts connections that may start with a PROXY protocol v1 or
+// v2 header. The header is read on the connection's first Read, not in
+// Accept, so a slow peer can't hold up the accept loop.
+type Listener struct {
+	net.Listener
+	opts Options
+}
+
+// NewListener wraps ln so that connections from trusted peers report the
+// client addresses carried in their PROXY header.
+func NewListener(ln net.Listener, opts Options) *Listener {
+	if opts.HeaderTimeout == 0 {
+		opts.HeaderTimeout = DefaultHeaderTimeout
+	}
+	return &Listener{Listener: ln, opts: opts}
+}
+
+// Accept waits for the next connection. Connections from trusted peers are
+// wrapped in a *Conn.
+func (l *Listener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	if !l.trusted(c.RemoteAddr()) {
+		return c, nil
+	}
+	return &Conn{Conn: c, br: bufio.NewReaderSize(c, 256), timeout: l.opts.HeaderTimeout}, nil
+}
+
+func (l *Listener) trusted(addr net.Addr) bool {
+	if len(l.opts.Trusted) == 0 {
+		return true
+	}
+	ap, err := netip.ParseAddrPort(addr.String())
+	if err != nil {
+		return false
+	}
+	ip := ap.Addr().Unmap()
+	for _, p := range l.opts.Trusted {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Conn is a connection from a trusted peer. Its addresses are the ones in
+// the PROXY header; LOCAL and UNKNOWN headers, sent by health checks, leave
+// the real ones in place.
+type Conn struct {
+	net.Conn
+	br      *bufio.Reader
+	timeout time.Duration
+
+	once   sync.Once
+	err    error
+	remote net.Addr
+	local  net.Addr
+}
+
+// Read reads the header first if it hasn't been yet, then the data after it.
+func (c *Conn) Read(p []byte) (int, error) {
+	c.once.Do(c.readHeader)
+	if c.err != nil {
+		return 0, c.err
+	}
+	// Drain what was buffered with the header, then read the connection
+	// directly so deadlines and errors behave as usual.
+	if c.br.Buffered() > 0 {
+		return c.br.Read(p)
+	}
+	return c.Conn.Read(p)
+}
+
+// ReadFrom writes r to the connection. The header only concerns reads, so
+// writes go straight to the underlying connection, keeping sendfile and
+// splice available to the response writer.
+func (c *Conn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(c.Conn, r)
+}
+
+// RemoteAddr returns the client's address from the header, reading it if
+// needed.
+func (c *Conn) RemoteAddr() net.Addr {
+	c.readHeaderWithTimeout()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the address the client connected to, from the header.
+func (c *Conn) LocalAddr() net.Addr {
+	c.readHeaderWithTimeout()
+	if c.local != nil {
+		return c.local
+	}
+	return c.Conn.LocalAddr()
+}
+
+// ProxyAddr returns the address of the peer that sent the header.
+func (c *Conn) ProxyAddr() net.Addr {
+	return c.Conn.RemoteAddr()
+}
+
+func (c *Conn) readHeaderWithTimeout() {
+	c.once.Do(func() {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.readHeader()
+		_ = c.Conn.SetReadDeadline(time.Time{})
+	})
+}
+
+func (c *Conn) readHeader() {
+	c.remote, c.local, c.err = parseHeader(c.br)
+	if c.err != nil && !errors.Is(c.err, ErrInvalidHeader) {
+		c.err = fmt.Errorf("%w: %v", ErrInvalidHeader, c.err)
+	}
+}
+
+// parseHeader reads a v1 or v2 header from br. Nil addresses mean the
+// header didn't carry any.
+func parseHeader(br *bufio.Reader) (remote, local net.Addr, err error) {
+	first, err := br.Peek(1)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch first[0] {
+	case 'P':
+		return parseV1(br)
+	case v2Signature[0]:
+		return parseV2(br)
+	}
+	return nil, nil, fmt.Errorf("%w: no header", ErrInvalidHeader)
+}
+
+// parseV1 reads a text header such as
+// "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n".
+func parseV1(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var line []byte
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) == v1MaxLen {
+			return nil, nil, fmt.Errorf("%w: v1 line too long", ErrInvalidHeader)
+		}
+	}
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: v1 line not terminated by CRLF", ErrInvalidHeader)
+	}
+	fields := strings.Split(text, " ")
+	if fields[0] != "PROXY" || len(fields) < 2 {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("%w: %q", ErrInvalidHeader, text)
+	}
+	src, err := v1Addr(fields[2], fields[4], fields[1] == "TCP6")
+	if err != nil {
+		return nil, nil, err
+	}
+	dst, err := v1Addr(fields[3], fields[5], fields[1] == "TCP6")
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, dst, nil
+}
+
+func v1Addr(host, port string, v6 bool) (net.Addr, error) {
+	ip, err := netip.ParseAddr(host)
+	if err != nil || ip.Is6() != v6 || ip.Zone() != "" {
+		return nil, fmt.Errorf("%w: bad address %q", ErrInvalidHeader, host)
+	}
+	// Ports are plain decimal without leading zeros.
+	n, err := strconv.ParseUint(port, 10, 16)
+	if err != nil || (len(port) > 1 && port[0] == '0') {
+		return nil, fmt.Errorf("%w: bad port %q", ErrInvalidHeader, port)
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(n))), nil
+}
+
+// parseV2 reads a binary header: the signature, a version and command byte,
+// an address family and transport byte, a length and then the addresses,
+// possibly followed by TLVs, which are skipped.
+func parseV2(br *bufio.Reader) (net.Addr, net.Addr, error) {
+	var fixed [16]byte
+	if _, err := io.ReadFull(br, fixed[:]); err != nil {
+		return nil, nil, err
+	}
+	if !bytes.Equal(fixed[:12], v2Signature) {
+		return nil, nil, fmt.Errorf("%w: bad v2 signature", ErrInvalidHeader)
+	}
+	if fixed[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidHeader, fixed[12]>>4)
+	}
+	command := fixed[12] & 0x0f
+	if command > 1 {
+		return nil, nil, fmt.Errorf("%w: unknown command %d", ErrInvalidHeader, command)
+	}
+	family, transport := fixed[13]>>4, fixed[13]&0x0f
+	body := make([]byte, binary.BigEndian.Uint16(fixed[14:]))
+	if _, err := io.ReadFull(br, body); err != nil {
+		return nil, nil, err
+	}
+
+	// LOCAL comes from the proxy itself, and only TCP over IP is of use
+	// to an HTTP server; keep the real addresses for anything else.
+	if command == 0 || transport != 1 {
+		return nil, nil, nil
+	}
+	var size int
+	switch family {
+	case 1:
+		size = 4
+	case 2:
+		size = 16
+	default:
+		return nil, nil, nil
+	}
+	if len(body) < 2*size+4 {
+		return nil, nil, fmt.Errorf("%w: v2 address block too short", ErrInvalidHeader)
+	}
+	srcIP, _ := netip.AddrFromSlice(body[:size])
+	dstIP, _ := netip.AddrFromSlice(body[size : 2*size])
+	srcPort := binary.BigEndian.Uint16(body[2*size:])
+	dstPort := binary.BigEndian.Uint16(body[2*size+2:])
+	src := net.TCPAddrFromAddrPort(netip.AddrPortFrom(srcIP, srcPort))
+	dst := net.TCPAddrFromAddrPort(netip.AddrPortFrom(dstIP, dstPort))
+	return src, dst, nil
+}
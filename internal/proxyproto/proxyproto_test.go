@@ -0,0 +1,199 @@
+package proxyproto
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// v2Header builds a version 2 PROXY header for TCP over IPv4 or IPv6.
+func v2Header(command byte, src, dst netip.AddrPort, tlv []byte) []byte {
+	family := byte(0x11)
+	if src.Addr().Is6() {
+		family = 0x21
+	}
+	var addrs []byte
+	addrs = append(addrs, src.Addr().AsSlice()...)
+	addrs = append(addrs, dst.Addr().AsSlice()...)
+	addrs = binary.BigEndian.AppendUint16(addrs, src.Port())
+	addrs = binary.BigEndian.AppendUint16(addrs, dst.Port())
+	addrs = append(addrs, tlv...)
+
+	h := append([]byte(nil), v2Signature...)
+	h = append(h, 0x20|command, family)
+	h = binary.BigEndian.AppendUint16(h, uint16(len(addrs)))
+	return append(h, addrs...)
+}
+
+func TestParseHeader(t *testing.T) {
+	parse := func(s string) (net.Addr, net.Addr, string, error) {
+		br := bufio.NewReader(strings.NewReader(s))
+		remote, local, err := parseHeader(br)
+		rest, _ := io.ReadAll(br)
+		return remote, local, string(rest), err
+	}
+	src := netip.MustParseAddrPort("192.0.2.1:56324")
+	dst := netip.MustParseAddrPort("198.51.100.1:443")
+
+	// Test: v1 TCP4, leaving the request after it unread
+	remote, local, rest, err := parse("PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nGET / HTTP/1.1\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", remote.String())
+	assert.Equal(t, "198.51.100.1:443", local.String())
+	assert.Equal(t, "GET / HTTP/1.1\r\n", rest)
+
+	// Test: v1 TCP6
+	remote, _, _, err = parse("PROXY TCP6 2001:db8::1 2001:db8::2 4000 80\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:4000", remote.String())
+
+	// Test: v1 UNKNOWN keeps the real addresses
+	remote, local, _, err = parse("PROXY UNKNOWN\r\n")
+	require.NoError(t, err)
+	assert.Nil(t, remote)
+	assert.Nil(t, local)
+
+	// Test: Malformed v1 headers
+	for _, bad := range []string{
+		"GET / HTTP/1.1\r\n",
+		"POST / HTTP/1.1\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324\r\n",
+		"PROXY TCP4 2001:db8::1 198.51.100.1 56324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 056324 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 70000 443\r\n",
+		"PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\n",
+		"PROXY UDP4 192.0.2.1 198.51.100.1 56324 443\r\n",
+		"PROXY TCP4 " + strings.Repeat("1", 120) + "\r\n",
+	} {
+		_, _, _, err := parse(bad)
+		assert.ErrorIs(t, err, ErrInvalidHeader, bad)
+	}
+
+	// Test: v2 PROXY over IPv4 and IPv6, with TLVs skipped
+	remote, local, rest, err = parse(string(v2Header(1, src, dst, []byte{0x04, 0x00, 0x01, 0xff})) + "GET")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", remote.String())
+	assert.Equal(t, "198.51.100.1:443", local.String())
+	assert.Equal(t, "GET", rest)
+	remote, _, _, err = parse(string(v2Header(1, netip.MustParseAddrPort("[2001:db8::1]:4000"), netip.MustParseAddrPort("[2001:db8::2]:80"), nil)))
+	require.NoError(t, err)
+	assert.Equal(t, "[2001:db8::1]:4000", remote.String())
+
+	// Test: v2 LOCAL keeps the real addresses
+	remote, _, rest, err = parse(string(v2Header(0, src, dst, nil)) + "GET")
+	require.NoError(t, err)
+	assert.Nil(t, remote)
+	assert.Equal(t, "GET", rest)
+
+	// Test: Malformed v2 headers
+	h := v2Header(1, src, dst, nil)
+	badVersion := append([]byte(nil), h...)
+	badVersion[12] = 0x11
+	badCommand := append([]byte(nil), h...)
+	badCommand[12] = 0x22
+	short := append([]byte(nil), h...)
+	short[15] = 8
+	for _, bad := range [][]byte{badVersion, badCommand, short[:24], []byte("\r\n\r\n\x00\r\nQUIX\n\x21\x11\x00\x00")} {
+		_, _, _, err := parse(string(bad))
+		assert.ErrorIs(t, err, ErrInvalidHeader)
+	}
+	_, _, _, err = parse(string(h[:20]))
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+func TestListener(t *testing.T) {
+	listen := func(trusted ...string) *Listener {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { ln.Close() })
+		var opts Options
+		for _, p := range trusted {
+			opts.Trusted = append(opts.Trusted, netip.MustParsePrefix(p))
+		}
+		return NewListener(ln, opts)
+	}
+	// exchange sends data to l and returns the accepted connection and
+	// everything read from it.
+	exchange := func(l *Listener, data string) (net.Conn, string, error) {
+		client, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		defer client.Close()
+		_, err = client.Write([]byte(data))
+		require.NoError(t, err)
+		require.NoError(t, client.(*net.TCPConn).CloseWrite())
+		conn, err := l.Accept()
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		got, err := io.ReadAll(conn)
+		return conn, string(got), err
+	}
+
+	// Test: A trusted peer's header sets the addresses and is stripped
+	conn, got, err := exchange(listen("127.0.0.0/8"), "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\nhello")
+	require.NoError(t, err)
+	assert.Equal(t, "hello", got)
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+	assert.Equal(t, "198.51.100.1:443", conn.LocalAddr().String())
+	assert.Contains(t, conn.(*Conn).ProxyAddr().String(), "127.0.0.1:")
+
+	// Test: An empty trusted list trusts everyone
+	conn, _, err = exchange(listen(), "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "192.0.2.1:56324", conn.RemoteAddr().String())
+
+	// Test: A trusted peer without a header is an error
+	_, _, err = exchange(listen("127.0.0.0/8"), "GET / HTTP/1.1\r\n\r\n")
+	assert.ErrorIs(t, err, ErrInvalidHeader)
+
+	// Test: Untrusted peers are passed through, header and all
+	conn, got, err = exchange(listen("10.0.0.0/8"), "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n")
+	require.NoError(t, err)
+	assert.Equal(t, "PROXY TCP4 192.0.2.1 198.51.100.1 56324 443\r\n", got)
+	assert.Contains(t, conn.RemoteAddr().String(), "127.0.0.1:")
+
+	// Test: RemoteAddr before any Read reads the header itself
+	l := listen("127.0.0.0/8")
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	_, err = client.Write(v2Header(1, netip.MustParseAddrPort("192.0.2.7:1234"), netip.MustParseAddrPort("198.51.100.1:80"), nil))
+	require.NoError(t, err)
+	conn, err = l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "192.0.2.7:1234", conn.RemoteAddr().String())
+}
+
+func TestConnReadFrom(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer ln.Close()
+	l := NewListener(ln, Options{})
+	client, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+	conn, err := l.Accept()
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Test: The wrapper keeps the TCP conn's ReadFrom for the response writer
+	rf, ok := conn.(io.ReaderFrom)
+	require.True(t, ok)
+	require.IsType(t, &Conn{}, conn)
+
+	// Test: Writes reach the peer
+	n, err := rf.ReadFrom(strings.NewReader("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), n)
+	got := make([]byte, 5)
+	_, err = io.ReadFull(client, got)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+}
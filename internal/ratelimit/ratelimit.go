@@ -0,0 +1,323 @@
+package ratelimit
+
+import (
+	"container/list"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// DefaultMaxKeys bounds how many keys a limiter tracks when its maxKeys
+// argument is zero.
+const DefaultMaxKeys = 10000
+
+// Decision is a limiter's verdict on one request.
+type Decision struct {
+	Allowed bool
+	// Limit is the number of requests the policy allows per Window.
+	Limit  int
+	Window time.Duration
+	// Remaining is how many more requests would be allowed right now.
+	Remaining int
+	// Reset is how long until the key's quota is fully restored.
+	Reset time.Duration
+	// RetryAfter is how long a refused client should wait; zero when the
+	// request was allowed.
+	RetryAfter time.Duration
+}
+
+// Limiter decides whether the request identified by key may proceed,
+// counting it if so. Implementations must be safe for concurrent use.
+type Limiter interface {
+	Allow(key string) Decision
+}
+
+// KeyFunc picks the key a request is counted under. Requests with an empty
+// key aren't limited.
+type KeyFunc func(req *request.Request) string
+
+// ByIP keys requests by client address, as resolved into req.Client.
+func ByIP(req *request.Request) string {
+	if !req.Client.IP.IsValid() {
+		return ""
+	}
+	return req.Client.IP.String()
+}
+
+// ByHeader keys requests by the value of the named header, such as an API
+// key. Requests without it aren't limited; combine it with ByIP through
+// Join if they should be.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) string {
+		return req.Headers.Get(name)
+	}
+}
+
+// ByRoute keys requests by method and cleaned path, so each route gets one
+// shared budget.
+func ByRoute(req *request.Request) string {
+	return req.RequestLine.Method + " " + req.CleanPath()
+}
+
+// Join keys requests by all of fns together, for example ByIP and ByRoute
+// for a per-client budget on each route. The key is empty if any part is.
+func Join(fns ...KeyFunc) KeyFunc {
+	return func(req *request.Request) string {
+		parts := make([]string, len(fns))
+		for i, fn := range fns {
+			if parts[i] = fn(req); parts[i] == "" {
+				return ""
+			}
+		}
+		return strings.Join(parts, "\x00")
+	}
+}
+
+// Options configures the middleware.
+type Options struct {
+	// Limiter does the counting. Required.
+	Limiter Limiter
+	// Key picks the key for each request. Defaults to ByIP.
+	Key KeyFunc
+}
+
+// Middleware refuses requests over their limit with 429 Too Many Requests
+// and Retry-After. Every limited response carries the RateLimit-Limit,
+// RateLimit-Remaining, RateLimit-Reset and RateLimit-Policy headers from
+// the IETF RateLimit header fields draft.
+func Middleware(opts Options) server.Middleware {
+	if opts.Key == nil {
+		opts.Key = ByIP
+	}
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			key := opts.Key(req)
+			if key == "" {
+				next(w, req)
+				return
+			}
+			d := opts.Limiter.Allow(key)
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(d.Limit))
+			h.Set("RateLimit-Remaining", strconv.Itoa(d.Remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(seconds(d.Reset)))
+			h.Set("RateLimit-Policy", strconv.Itoa(d.Limit)+";w="+strconv.Itoa(seconds(d.Window)))
+			if !d.Allowed {
+				h.Set("Retry-After", strconv.Itoa(seconds(d.RetryAfter)))
+				w.SetStatus(response.StatusTooManyRequests)
+				w.Write([]byte(response.StatusText(response.StatusTooManyRequests) + "\n"))
+				return
+			}
+			next(w, req)
+		}
+	}
+}
+
+// seconds rounds d up to whole seconds, so a client waiting that long is
+// never early.
+func seconds(d time.Duration) int {
+	return int((d + time.Second - 1) / time.Second)
+}
+
+// keyTable holds per-key state, evicting the least recently used key once
+// it holds max of them. A client evicted early merely gets a fresh quota.
+type keyTable[T any] struct {
+	max   int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type keyEntry[T any] struct {
+	key   string
+	state T
+}
+
+func newKeyTable[T any](max int) *keyTable[T] {
+	if max <= 0 {
+		max = DefaultMaxKeys
+	}
+	return &keyTable[T]{max: max, items: map[string]*list.Element{}, order: list.New()}
+}
+
+// get returns the state for key, adding a zero one if it's new.
+func (t *keyTable[T]) get(key string) *T {
+	if e, ok := t.items[key]; ok {
+		t.order.MoveToFront(e)
+		return &e.Value.(*keyEntry[T]).state
+	}
+	if t.order.Len() >= t.max {
+		oldest := t.order.Back()
+		t.order.Remove(oldest)
+		delete(t.items, oldest.Value.(*keyEntry[T]).key)
+	}
+	entry := &keyEntry[T]{key: key}
+	t.items[key] = t.order.PushFront(entry)
+	return &entry.state
+}
+
+func (t *keyTable[T]) len() int {
+	return t.order.Len()
+}
+
+// checkWindow panics unless window gives each of limit requests at least a
+// nanosecond. A zero window would divide by zero, and a shorter one would
+// make a token take no time at all to refill.
+func checkWindow(limit int, window time.Duration) {
+	if window <= 0 {
+		panic("ratelimit: window must be positive")
+	}
+	if window < time.Duration(limit) {
+		panic("ratelimit: window is shorter than one nanosecond per request")
+	}
+}
+
+// TokenBucket allows bursts of up to limit requests per key, refilling at
+// limit per window.
+type TokenBucket struct {
+	limit  int
+	window time.Duration
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu   sync.Mutex
+	keys *keyTable[bucket]
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewTokenBucket returns a token bucket limiter tracking at most maxKeys
+// keys; zero means DefaultMaxKeys. It panics if window isn't positive or is
+// shorter than limit nanoseconds, which would leave no time to refill a
+// token.
+func NewTokenBucket(limit int, window time.Duration, maxKeys int) *TokenBucket {
+	limit = max(limit, 1)
+	checkWindow(limit, window)
+	return &TokenBucket{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		keys:   newKeyTable[bucket](maxKeys),
+	}
+}
+
+// Allow takes a token from key's bucket if there is one.
+func (l *TokenBucket) Allow(key string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	perToken := l.window / time.Duration(l.limit)
+
+	b := l.keys.get(key)
+	if b.last.IsZero() {
+		b.tokens = float64(l.limit)
+	} else {
+		b.tokens += float64(now.Sub(b.last)) / float64(perToken)
+		b.tokens = min(b.tokens, float64(l.limit))
+	}
+	b.last = now
+
+	d := Decision{Limit: l.limit, Window: l.window}
+	if b.tokens >= 1 {
+		b.tokens--
+		d.Allowed = true
+	} else {
+		d.RetryAfter = time.Duration((1 - b.tokens) * float64(perToken))
+	}
+	d.Remaining = int(b.tokens)
+	d.Reset = time.Duration((float64(l.limit) - b.tokens) * float64(perToken))
+	return d
+}
+
+// SlidingWindow allows limit requests per key in any window-long span,
+// approximated by weighting the previous fixed window's count by how much
+// of it still overlaps. Unlike TokenBucket it doesn't let a full burst
+// through right after a quiet spell ends the previous window.
+type SlidingWindow struct {
+	limit  int
+	window time.Duration
+	// now is replaced in tests.
+	now func() time.Time
+
+	mu   sync.Mutex
+	keys *keyTable[counter]
+}
+
+type counter struct {
+	start      time.Time
+	prev, curr int
+}
+
+// NewSlidingWindow returns a sliding window limiter tracking at most
+// maxKeys keys; zero means DefaultMaxKeys. It panics on the same windows
+// as NewTokenBucket.
+func NewSlidingWindow(limit int, window time.Duration, maxKeys int) *SlidingWindow {
+	limit = max(limit, 1)
+	checkWindow(limit, window)
+	return &SlidingWindow{
+		limit:  limit,
+		window: window,
+		now:    time.Now,
+		keys:   newKeyTable[counter](maxKeys),
+	}
+}
+
+// Allow counts the request against key if the estimated count for the last
+// window leaves room for it.
+func (l *SlidingWindow) Allow(key string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+
+	c := l.keys.get(key)
+	start := now.Truncate(l.window)
+	switch {
+	case c.start.Equal(start):
+	case c.start.Add(l.window).Equal(start):
+		c.prev, c.curr = c.curr, 0
+	default:
+		c.prev, c.curr = 0, 0
+	}
+	c.start = start
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(l.window)
+	estimate := float64(c.prev)*weight + float64(c.curr)
+
+	d := Decision{Limit: l.limit, Window: l.window}
+	if estimate+1 <= float64(l.limit) {
+		c.curr++
+		estimate++
+		d.Allowed = true
+	} else {
+		d.RetryAfter = l.retryAfter(c, elapsed)
+	}
+	d.Remaining = max(l.limit-int(estimate+0.999999), 0)
+	// The quota is whole again once neither window holds any requests.
+	d.Reset = 2*l.window - elapsed
+	if c.curr == 0 {
+		d.Reset = l.window - elapsed
+	}
+	return d
+}
+
+// retryAfter returns how long until the estimate leaves room for one more
+// request, given the window began elapsed ago.
+func (l *SlidingWindow) retryAfter(c *counter, elapsed time.Duration) time.Duration {
+	room := float64(l.limit - 1)
+	if float64(c.curr) <= room {
+		// Wait for the previous window's share to decay.
+		at := (1 - (room-float64(c.curr))/float64(c.prev)) * float64(l.window)
+		return time.Duration(at) - elapsed
+	}
+	// The current window is full: wait for it to become the previous one
+	// and decay in turn.
+	at := (1 - room/float64(c.curr)) * float64(l.window)
+	return l.window - elapsed + time.Duration(at)
+}
@@ -0,0 +1,169 @@
+package ratelimit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// clock is a settable time source for the limiters.
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+func newClock() *clock                   { return &clock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)} }
+
+// allowN makes n requests for key and returns how many were allowed.
+func allowN(l Limiter, key string, n int) int {
+	allowed := 0
+	for range n {
+		if l.Allow(key).Allowed {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestTokenBucket(t *testing.T) {
+	c := newClock()
+	l := NewTokenBucket(10, 10*time.Second, 0)
+	l.now = c.now
+
+	// Test: A full burst is allowed, then refused with a retry delay
+	d := l.Allow("a")
+	assert.True(t, d.Allowed)
+	assert.Equal(t, 10, d.Limit)
+	assert.Equal(t, 9, d.Remaining)
+	assert.Equal(t, time.Second, d.Reset)
+	assert.Equal(t, 9, allowN(l, "a", 9))
+	d = l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.Equal(t, time.Second, d.RetryAfter)
+
+	// Test: Keys are independent
+	assert.True(t, l.Allow("b").Allowed)
+
+	// Test: Tokens refill at limit per window
+	c.advance(2500 * time.Millisecond)
+	assert.Equal(t, 2, allowN(l, "a", 5))
+	c.advance(time.Hour)
+	assert.Equal(t, 10, allowN(l, "a", 20))
+}
+
+func TestSlidingWindow(t *testing.T) {
+	c := newClock()
+	l := NewSlidingWindow(10, time.Minute, 0)
+	l.now = c.now
+
+	// Test: limit requests per window, then refusal until the window ends
+	assert.Equal(t, 10, allowN(l, "a", 15))
+	c.advance(30 * time.Second)
+	d := l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 0, d.Remaining)
+	assert.InDelta(t, 36*time.Second, d.RetryAfter, float64(time.Millisecond))
+
+	// Test: The previous window's count decays across the current one
+	c.advance(45 * time.Second) // 15s into the next window: 7.5 still count
+	assert.Equal(t, 2, allowN(l, "a", 5))
+	d = l.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Positive(t, d.RetryAfter)
+
+	// Test: A burst right after a window boundary is still limited
+	c.advance(45*time.Second + 59*time.Second)
+	assert.Equal(t, 10, allowN(l, "b", 10))
+	c.advance(2 * time.Second)
+	assert.Equal(t, 0, allowN(l, "b", 5))
+
+	// Test: Long idle keys start over
+	c.advance(time.Hour)
+	assert.Equal(t, 10, allowN(l, "a", 12))
+}
+
+func TestKeyEviction(t *testing.T) {
+	l := NewTokenBucket(1, time.Hour, 2)
+
+	// Test: The least recently used key is evicted past maxKeys
+	assert.True(t, l.Allow("a").Allowed)
+	assert.True(t, l.Allow("b").Allowed)
+	assert.False(t, l.Allow("a").Allowed)
+	assert.True(t, l.Allow("c").Allowed)
+	assert.Equal(t, 2, l.keys.len())
+	assert.True(t, l.Allow("b").Allowed, "b was evicted and starts over")
+	assert.False(t, l.Allow("c").Allowed)
+}
+
+func TestInvalidWindow(t *testing.T) {
+	for _, window := range []time.Duration{0, -time.Second, 9} {
+		// Test: each window too short for the limit panics
+		assert.Panics(t, func() { NewTokenBucket(10, window, 0) }, window)
+		assert.Panics(t, func() { NewSlidingWindow(10, window, 0) }, window)
+	}
+
+	// Test: A nanosecond per request is enough
+	assert.NotPanics(t, func() { NewTokenBucket(10, 10, 0) })
+	assert.NotPanics(t, func() { NewSlidingWindow(10, 10, 0) })
+}
+
+func TestKeyFuncs(t *testing.T) {
+	req, err := request.RequestFromReader(strings.NewReader("GET /api/../items/ HTTP/1.1\r\nHost: x\r\nX-Api-Key: k1\r\n\r\n"))
+	require.NoError(t, err)
+	req.Client.IP = netip.MustParseAddr("192.0.2.1")
+
+	assert.Equal(t, "192.0.2.1", ByIP(req))
+	assert.Equal(t, "k1", ByHeader("X-Api-Key")(req))
+	assert.Equal(t, "", ByHeader("Authorization")(req))
+	assert.Equal(t, "GET /items/", ByRoute(req))
+	assert.Equal(t, "192.0.2.1\x00GET /items/", Join(ByIP, ByRoute)(req))
+	assert.Equal(t, "", Join(ByIP, ByHeader("Authorization"))(req))
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := NewTokenBucket(2, time.Minute, 0)
+	handler := server.Chain(func(w *response.Writer, req *request.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	}, Middleware(Options{Limiter: limiter}))
+	s, err := server.Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+
+	get := func() string {
+		conn, err := net.DialTimeout("tcp", s.Addr().String(), 2*time.Second)
+		require.NoError(t, err)
+		defer conn.Close()
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		raw, err := io.ReadAll(bufio.NewReader(conn))
+		require.NoError(t, err)
+		return string(raw)
+	}
+
+	// Test: Allowed responses carry the RateLimit headers
+	resp := get()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"), resp)
+	assert.Contains(t, resp, "ratelimit-limit: 2\r\n")
+	assert.Contains(t, resp, "ratelimit-remaining: 1\r\n")
+	assert.Contains(t, resp, "ratelimit-reset: 30\r\n")
+	assert.Contains(t, resp, "ratelimit-policy: 2;w=60\r\n")
+
+	// Test: Over the limit gets 429 with Retry-After
+	get()
+	resp = get()
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 429 Too Many Requests\r\n"), resp)
+	assert.Contains(t, resp, "retry-after: 30\r\n")
+	assert.Contains(t, resp, "ratelimit-remaining: 0\r\n")
+}
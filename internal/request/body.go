@@ -0,0 +1,195 @@
+package request
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ReadBody returns the request body, reading it first if the request came
+// from HeadFromReader. Callbacks registered with BeforeBodyRead run before
+// the first byte is read and those from AfterBodyRead once it is complete;
+// an error from either is returned by this and every later call.
+func (r *Request) ReadBody() ([]byte, error) {
+	if r.BodyPending() {
+		if r.bodyErr = r.runBeforeBody(); r.bodyErr != nil {
+			return nil, r.bodyErr
+		}
+		if r.bodyErr = r.readUntil(ParserDone); r.bodyErr != nil {
+			return nil, r.bodyErr
+		}
+		if r.bodyErr = r.runAfterBody(); r.bodyErr != nil {
+			return nil, r.bodyErr
+		}
+	}
+	if r.decompress && r.bodyErr == nil {
+		r.decompress = false
+		if r.bodyErr = r.DecompressBody(r.decompressLimit); r.bodyErr != nil {
+			return nil, r.bodyErr
+		}
+	}
+	return r.Body, r.bodyErr
+}
+
+// BodyReader returns the body as a stream, read from the connection as the
+// caller consumes it and capped at Content-Length, for handlers that would
+// rather not hold a large upload in memory. The BeforeBodyRead callbacks
+// run now and the AfterBodyRead ones once the stream reaches its end. A
+// body that has already been read is served from memory; one that is
+// streamed can't be read again, and ReadBody returns it empty.
+func (r *Request) BodyReader() (io.Reader, error) {
+	if !r.BodyPending() {
+		body, err := r.ReadBody()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(body), nil
+	}
+	// Without a usable Content-Length there is no body to stream, and
+	// ReadBody reports why.
+	n, err := strconv.ParseInt(r.Headers.Get("Content-Length"), 10, 64)
+	if err != nil || n <= 0 {
+		body, err := r.ReadBody()
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(body), nil
+	}
+	var codings []string
+	if r.decompress {
+		if codings, err = r.contentCodings(); err != nil {
+			r.bodyErr = err
+			return nil, err
+		}
+	}
+	if r.bodyErr = r.runBeforeBody(); r.bodyErr != nil {
+		return nil, r.bodyErr
+	}
+	r.state = ParserDone
+	var body io.Reader = &bodyReader{r: r, remaining: n}
+	if len(codings) > 0 {
+		r.decompress = false
+		if body, err = decodeStream(codings, body, r.decompressLimit); err != nil {
+			r.bodyErr = err
+			return nil, err
+		}
+		// The decoded length isn't known until the stream ends.
+		delete(r.Headers, "content-encoding")
+		delete(r.Headers, "content-length")
+	}
+	return body, nil
+}
+
+// bodyReader streams a body of known length: first the bytes buffered
+// while parsing the head, then the rest from the source.
+type bodyReader struct {
+	r         *Request
+	remaining int64
+	done      bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	r := b.r
+	if b.done {
+		return 0, io.EOF
+	}
+	if r.bodyErr != nil {
+		return 0, r.bodyErr
+	}
+	if b.remaining == 0 {
+		b.done = true
+		if r.bodyErr = r.runAfterBody(); r.bodyErr != nil {
+			return 0, r.bodyErr
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	var n int
+	if r.readTo > 0 {
+		n = copy(p, r.buf[:r.readTo])
+		copy(r.buf, r.buf[n:r.readTo])
+		r.readTo -= n
+	} else {
+		var err error
+		n, err = r.src.Read(p)
+		if err == io.EOF && int64(n) < b.remaining {
+			err = fmt.Errorf("incomplete body: %w", io.ErrUnexpectedEOF)
+		}
+		if err != nil && err != io.EOF {
+			r.bodyErr = err
+			b.remaining -= int64(n)
+			return n, err
+		}
+	}
+	b.remaining -= int64(n)
+	return n, nil
+}
+
+func (r *Request) runBeforeBody() error {
+	before := r.beforeBody
+	r.beforeBody = nil
+	for _, fn := range before {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Request) runAfterBody() error {
+	after := r.afterBody
+	r.afterBody = nil
+	for _, fn := range after {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BodyPending reports whether the body hasn't been read yet.
+func (r *Request) BodyPending() bool {
+	return r.src != nil && r.state != ParserDone && r.bodyErr == nil
+}
+
+// BeforeBodyRead registers fn to run when ReadBody or BodyReader is about
+// to read a pending body. The server uses it to send 100 Continue.
+func (r *Request) BeforeBodyRead(fn func() error) {
+	r.beforeBody = append(r.beforeBody, fn)
+}
+
+// AfterBodyRead registers fn to run once a pending body has been read, to
+// check it or release what reading it needed.
+func (r *Request) AfterBodyRead(fn func() error) {
+	r.afterBody = append(r.afterBody, fn)
+}
+
+// ContentLength returns the declared body length, or -1 when there is no
+// valid Content-Length header.
+func (r *Request) ContentLength() int64 {
+	n, err := strconv.ParseInt(r.Headers.Get("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+// ExpectsContinue reports whether the client sent "Expect: 100-continue"
+// and is waiting for an interim response before sending the body.
+func (r *Request) ExpectsContinue() bool {
+	return strings.EqualFold(strings.TrimSpace(r.Headers.Get("Expect")), "100-continue")
+}
+
+// Buffered returns bytes already read from the connection that follow the
+// request. They are the start of the next protocol's data when a handler
+// takes the connection over.
+func (r *Request) Buffered() []byte {
+	if r.readTo == 0 {
+		return nil
+	}
+	return append([]byte(nil), r.buf[:r.readTo]...)
+}
@@ -0,0 +1,33 @@
+package request
+
+import "net/netip"
+
+// ClientInfo is what the server knows about the client that originated a
+// request, possibly through proxies. The server fills it in from the
+// connection; middleware that trusts the proxies in front of it can replace
+// it with what they report.
+type ClientInfo struct {
+	// IP is the client's address, or the zero Addr if unknown.
+	IP netip.Addr
+	// Scheme is "http" or "https", as the client used it.
+	Scheme string
+	// Host is the host the client asked for, port included if it gave
+	// one.
+	Host string
+}
+
+// ClientFromConn returns the client info as seen by the server itself,
+// without taking any proxy headers into account.
+func (r *Request) ClientFromConn() ClientInfo {
+	c := ClientInfo{Scheme: "http", Host: r.Target().Host}
+	if ip, err := netip.ParseAddr(r.Conn.RemoteIP()); err == nil {
+		c.IP = ip.Unmap()
+	}
+	if r.Conn.TLS != nil {
+		c.Scheme = "https"
+	}
+	if c.Host == "" {
+		c.Host = r.Headers.Get("Host")
+	}
+	return c
+}
@@ -0,0 +1,39 @@
+package request
+
+import (
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// ConnInfo describes the connection a request arrived on. The server fills
+// it in before calling the handler; requests parsed by hand have the zero
+// value.
+type ConnInfo struct {
+	// RemoteAddr and LocalAddr are the connection's endpoints. Behind a
+	// proxy RemoteAddr is the proxy's address, unless the server takes the
+	// client's from a PROXY protocol header.
+	RemoteAddr net.Addr
+	LocalAddr  net.Addr
+	// ID identifies the connection among all those the server accepted,
+	// starting at 1.
+	ID uint64
+	// Seq is the request's position on its connection, starting at 1.
+	Seq int
+	// ReceivedAt is when the server finished reading the request head.
+	ReceivedAt time.Time
+	// TLS is the handshake state for requests over TLS, nil otherwise.
+	TLS *tls.ConnectionState
+}
+
+// RemoteIP returns the host part of RemoteAddr, or "" if it is unknown.
+func (c ConnInfo) RemoteIP() string {
+	if c.RemoteAddr == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(c.RemoteAddr.String())
+	if err != nil {
+		return c.RemoteAddr.String()
+	}
+	return host
+}
@@ -0,0 +1,24 @@
+package request
+
+import "context"
+
+// Context returns the request's context. The server cancels it when the
+// client disconnects, the server shuts down or the request's deadline
+// passes, so long-running handlers and outbound calls should watch it. It is
+// never nil.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// SetContext replaces the request's context. Middleware use it to attach
+// values or tighten the deadline, deriving the new context from Context so
+// cancellation still flows through.
+func (r *Request) SetContext(ctx context.Context) {
+	if ctx == nil {
+		panic("request: nil context")
+	}
+	r.ctx = ctx
+}
@@ -0,0 +1,19 @@
+package request
+
+import "httpfromtcp/internal/cookie"
+
+// Cookies returns the cookies sent in the Cookie header.
+func (r *Request) Cookies() []*cookie.Cookie {
+	return cookie.Parse(r.Headers.Get("Cookie"))
+}
+
+// Cookie returns the first cookie with the given name, or
+// cookie.ErrNoCookie.
+func (r *Request) Cookie(name string) (*cookie.Cookie, error) {
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return nil, cookie.ErrNoCookie
+}
@@ -0,0 +1,164 @@
+package request
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupportedEncoding is returned by DecompressBody for a Content-Encoding
+// it can't decode.
+var ErrUnsupportedEncoding = errors.New("unsupported content-encoding")
+
+// ErrBodyTooLarge is returned when a body grows past the allowed size.
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// DecompressBody decodes a gzip or deflate encoded Body in place, undoing
+// each coding listed in Content-Encoding in reverse order. The decoded body
+// may not exceed limit bytes, which stops small compressed uploads from
+// expanding into huge ones. On success Content-Encoding is removed and
+// Content-Length describes the decoded body.
+func (r *Request) DecompressBody(limit int64) error {
+	codings, err := r.contentCodings()
+	if err != nil || len(codings) == 0 {
+		return err
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return err
+	}
+	for i := len(codings) - 1; i >= 0; i-- {
+		decoded, err := decode(codings[i], body, limit)
+		if err != nil {
+			return err
+		}
+		body = decoded
+	}
+
+	r.Body = body
+	delete(r.Headers, "content-encoding")
+	r.Headers["content-length"] = strconv.Itoa(len(body))
+	return nil
+}
+
+// CheckContentEncoding returns ErrUnsupportedEncoding if DecompressBody
+// couldn't decode the body, without reading it.
+func (r *Request) CheckContentEncoding() error {
+	_, err := r.contentCodings()
+	return err
+}
+
+// contentCodings lists the codings in Content-Encoding, leaving out
+// identity, and checks each one is supported.
+func (r *Request) contentCodings() ([]string, error) {
+	var codings []string
+	for _, coding := range strings.Split(r.Headers.Get("Content-Encoding"), ",") {
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		switch coding {
+		case "", "identity":
+		case "gzip", "x-gzip", "deflate":
+			codings = append(codings, coding)
+		default:
+			return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+		}
+	}
+	return codings, nil
+}
+
+// DecompressOnRead arranges for the body to be decoded as DecompressBody(limit)
+// would when it is read, through ReadBody or, as a stream, BodyReader. The
+// server uses it so that bodies can stay unread until a handler asks.
+func (r *Request) DecompressOnRead(limit int64) {
+	r.decompress, r.decompressLimit = true, limit
+}
+
+// decode undoes a single content coding, reading at most limit bytes of
+// output.
+func decode(coding string, data []byte, limit int64) ([]byte, error) {
+	dec, err := newDecoder(coding, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	// Read one byte past the limit to tell "exactly limit" from "more".
+	out, err := io.ReadAll(io.LimitReader(dec, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s body: %w", coding, err)
+	}
+	if int64(len(out)) > limit {
+		return nil, fmt.Errorf("%w: decoded body exceeds %d bytes", ErrBodyTooLarge, limit)
+	}
+	return out, nil
+}
+
+// decodeStream undoes codings, listed in the order they were applied, as
+// src is read. Reading more than limit decoded bytes fails with
+// ErrBodyTooLarge.
+func decodeStream(codings []string, src io.Reader, limit int64) (io.Reader, error) {
+	for i := len(codings) - 1; i >= 0; i-- {
+		dec, err := newDecoder(codings[i], src)
+		if err != nil {
+			return nil, err
+		}
+		src = dec
+	}
+	return &limitedDecoder{r: src, left: limit}, nil
+}
+
+// newDecoder returns a reader undoing coding on src.
+func newDecoder(coding string, src io.Reader) (io.Reader, error) {
+	switch coding {
+	case "gzip", "x-gzip":
+		zr, err := gzip.NewReader(src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip body: %w", err)
+		}
+		return zr, nil
+	case "deflate":
+		// "deflate" means zlib-wrapped data, but enough clients send a raw
+		// deflate stream that it is accepted as a fallback.
+		br := bufio.NewReader(src)
+		if h, err := br.Peek(2); err == nil && isZlibHeader(h) {
+			zr, err := zlib.NewReader(br)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deflate body: %w", err)
+			}
+			return zr, nil
+		}
+		return flate.NewReader(br), nil
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, coding)
+	}
+}
+
+// isZlibHeader reports whether h starts a zlib stream that zlib.NewReader
+// accepts: deflate compression, a valid check value and no preset
+// dictionary (RFC 1950 section 2.2).
+func isZlibHeader(h []byte) bool {
+	return h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 && h[1]&0x20 == 0
+}
+
+// limitedDecoder fails once more than left bytes have been decoded, rather
+// than quietly truncating as io.LimitReader would.
+type limitedDecoder struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedDecoder) Read(p []byte) (int, error) {
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.left {
+		return int(l.left), fmt.Errorf("%w: decoded body exceeds limit", ErrBodyTooLarge)
+	}
+	l.left -= int64(n)
+	return n, err
+}
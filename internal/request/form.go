@@ -0,0 +1,221 @@
+package request
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"os"
+)
+
+// Defaults used when FormLimits fields are zero.
+const (
+	DefaultFormMaxMemory   = 1 << 20
+	DefaultFormMaxParts    = 100
+	DefaultFormMaxPartSize = 32 << 20
+)
+
+// ErrNotForm is returned when the request body isn't of the form type the
+// caller asked to parse.
+var ErrNotForm = errors.New("request body is not a form")
+
+// ErrTooManyParts is returned when a multipart body has more parts than
+// FormLimits allows.
+var ErrTooManyParts = errors.New("too many multipart parts")
+
+// FormLimits bounds the resources a multipart form may use.
+type FormLimits struct {
+	// MaxMemory is how many bytes of file data are kept in memory across
+	// all parts; files that don't fit are spilled to temporary files.
+	MaxMemory int64
+	// MaxParts caps the number of parts, fields and files together.
+	MaxParts int
+	// MaxPartSize caps the size of any single part.
+	MaxPartSize int64
+}
+
+func (l FormLimits) withDefaults() FormLimits {
+	if l.MaxMemory == 0 {
+		l.MaxMemory = DefaultFormMaxMemory
+	}
+	if l.MaxParts == 0 {
+		l.MaxParts = DefaultFormMaxParts
+	}
+	if l.MaxPartSize == 0 {
+		l.MaxPartSize = DefaultFormMaxPartSize
+	}
+	return l
+}
+
+// Form is a parsed multipart/form-data body.
+type Form struct {
+	Values Query
+	Files  map[string][]*FormFile
+}
+
+// FormFile is an uploaded file. Its content lives in memory or, when it was
+// too large, in a temporary file removed by Form.RemoveAll.
+type FormFile struct {
+	Filename    string
+	ContentType string
+	Size        int64
+
+	content []byte
+	tmpPath string
+}
+
+// Open returns a reader for the file's content.
+func (f *FormFile) Open() (io.ReadCloser, error) {
+	if f.tmpPath != "" {
+		return os.Open(f.tmpPath)
+	}
+	return io.NopCloser(bytes.NewReader(f.content)), nil
+}
+
+// RemoveAll deletes any temporary files backing the form's uploads.
+func (f *Form) RemoveAll() error {
+	var firstErr error
+	for _, files := range f.Files {
+		for _, file := range files {
+			if file.tmpPath == "" {
+				continue
+			}
+			if err := os.Remove(file.tmpPath); err != nil && firstErr == nil {
+				firstErr = err
+			}
+			file.tmpPath = ""
+		}
+	}
+	return firstErr
+}
+
+// ParseForm decodes an application/x-www-form-urlencoded body.
+func (r *Request) ParseForm() (Query, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "application/x-www-form-urlencoded" {
+		return nil, ErrNotForm
+	}
+	body, err := r.ReadBody()
+	if err != nil {
+		return nil, err
+	}
+	return ParseQuery(string(body)), nil
+}
+
+// MultipartReader returns a reader for streaming the parts of a
+// multipart/form-data body one at a time, for handlers that want to
+// process uploads without ParseMultipartForm's buffering. Parts are read
+// from the connection as they are consumed; see BodyReader.
+func (r *Request) MultipartReader() (*multipart.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/form-data" {
+		return nil, ErrNotForm
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, fmt.Errorf("%w: missing multipart boundary", ErrNotForm)
+	}
+	body, err := r.BodyReader()
+	if err != nil {
+		return nil, err
+	}
+	return multipart.NewReader(body, boundary), nil
+}
+
+// ParseMultipartForm reads a whole multipart/form-data body within limits.
+// Field values and small files are kept in memory; larger files are written
+// to temporary files, so callers should defer Form.RemoveAll.
+func (r *Request) ParseMultipartForm(limits FormLimits) (*Form, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	limits = limits.withDefaults()
+
+	form := &Form{Values: Query{}, Files: map[string][]*FormFile{}}
+	memLeft := limits.MaxMemory
+	parts := 0
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return form, nil
+		}
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+		parts++
+		if parts > limits.MaxParts {
+			part.Close()
+			form.RemoveAll()
+			return nil, fmt.Errorf("%w: limit is %d", ErrTooManyParts, limits.MaxParts)
+		}
+
+		err = form.addPart(part, limits.MaxPartSize, &memLeft)
+		part.Close()
+		if err != nil {
+			form.RemoveAll()
+			return nil, err
+		}
+	}
+}
+
+// addPart stores one part as a value or a file, spilling files to disk once
+// the shared memory budget runs out.
+func (f *Form) addPart(part *multipart.Part, maxSize int64, memLeft *int64) error {
+	name := part.FormName()
+	if name == "" {
+		return nil
+	}
+	// Read one byte past the limit to tell "exactly the limit" from "more".
+	limited := io.LimitReader(part, maxSize+1)
+
+	if part.FileName() == "" {
+		value, err := io.ReadAll(limited)
+		if err != nil {
+			return err
+		}
+		if int64(len(value)) > maxSize {
+			return fmt.Errorf("%w: field %q exceeds %d bytes", ErrBodyTooLarge, name, maxSize)
+		}
+		f.Values[name] = append(f.Values[name], string(value))
+		return nil
+	}
+
+	// Track the file before reading so a failed part is still cleaned up
+	// by RemoveAll.
+	file := &FormFile{Filename: part.FileName(), ContentType: part.Header.Get("Content-Type")}
+	f.Files[name] = append(f.Files[name], file)
+
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, limited, *memLeft+1)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	if n <= *memLeft {
+		*memLeft -= n
+		file.content, file.Size = buf.Bytes(), n
+	} else if err := file.spill(io.MultiReader(&buf, limited)); err != nil {
+		return err
+	}
+	if file.Size > maxSize {
+		return fmt.Errorf("%w: file %q exceeds %d bytes", ErrBodyTooLarge, file.Filename, maxSize)
+	}
+	return nil
+}
+
+// spill writes the file's content to a temporary file.
+func (f *FormFile) spill(r io.Reader) error {
+	tmp, err := os.CreateTemp("", "httpfromtcp-upload-")
+	if err != nil {
+		return err
+	}
+	f.tmpPath = tmp.Name()
+	f.Size, err = io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
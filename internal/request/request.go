@@ -2,6 +2,7 @@ package request
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"log"
@@ -16,7 +17,32 @@ type Request struct {
 	// State tracks the parser state for this request.
 	state   ParserState
 	Headers headers.Headers
-	Body    []byte
+	// Body holds the request body once it has been read. Requests from
+	// HeadFromReader have it empty until ReadBody is called.
+	Body []byte
+
+	// Conn describes the connection the request arrived on, see conn.go.
+	Conn ConnInfo
+	// Client describes the originating client, see client.go.
+	Client ClientInfo
+
+	// ctx is the request's context, see context.go.
+	ctx context.Context
+
+	// Reader state for a body that hasn't been read yet, see body.go.
+	src        io.Reader
+	buf        []byte
+	readTo     int
+	bodyErr    error
+	beforeBody []func() error
+	afterBody  []func() error
+	// hostFields counts the Host field lines, which the joined value in
+	// Headers can't show.
+	hostFields int
+
+	// decompressLimit is set by DecompressOnRead, see decompress.go.
+	decompress      bool
+	decompressLimit int64
 }
 
 type RequestLine struct {
@@ -40,22 +66,46 @@ const (
 	ParserDone
 )
 
+// RequestFromReader reads a whole request, body included, from reader.
 func RequestFromReader(reader io.Reader) (*Request, error) {
-	// Initialize a request and a fixed buffer for streaming reads.
-	req := &Request{state: ParserInitialized}
+	req := newRequest(reader)
+	if err := req.readUntil(ParserDone); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// HeadFromReader reads the request line and headers from reader and leaves
+// the body unread. It is loaded by the first ReadBody call, or by anything
+// that reads the body through it (ParseForm, say). The Request keeps using
+// reader until then.
+func HeadFromReader(reader io.Reader) (*Request, error) {
+	req := newRequest(reader)
+	if err := req.readUntil(requestStateParsingBody); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
 
-	buf := make([]byte, 8)
-	readTo := 0 // number of valid bytes in buf
+func newRequest(reader io.Reader) *Request {
+	return &Request{state: ParserInitialized, src: reader, buf: make([]byte, 8)}
+}
+
+// readUntil feeds the parser from r.src until it reaches state want.
+func (r *Request) readUntil(want ParserState) error {
+	buf, readTo := r.buf, r.readTo
+	defer func() { r.buf, r.readTo = buf, readTo }()
 	for {
-		// If parser already completed (could happen if previous chunk finished), break.
-		if req.state == ParserDone {
+		// If parser already reached the wanted state (could happen if
+		// previous chunk finished), stop.
+		if r.state >= want {
 			break
 		}
 
 		// Attempt to parse with current buffer first.
-		consumed, err := req.parse(buf[:readTo])
+		consumed, err := r.parse(buf[:readTo])
 		if err != nil {
-			return nil, err
+			return err
 		}
 		if consumed > 0 {
 			// Shift consumed bytes out by copying remaining bytes to the front
@@ -70,9 +120,9 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			continue
 		}
 
-		// If parser reached done state while consuming no bytes, break out
+		// If parser reached the wanted state while consuming no bytes, stop
 		// now instead of attempting another Read which may block.
-		if req.state == ParserDone {
+		if r.state >= want {
 			break
 		}
 
@@ -88,7 +138,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 			buf = newBuf
 		}
 
-		n, err := reader.Read(buf[readTo:])
+		n, err := r.src.Read(buf[readTo:])
 		log.Printf("RequestFromReader: read returned n=%d err=%v", n, err)
 		if n > 0 {
 			readTo += n
@@ -96,9 +146,9 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		if err != nil {
 			if err == io.EOF {
 				// final attempt: parse whatever is in buf
-				consumed, perr := req.parse(buf[:readTo])
+				consumed, perr := r.parse(buf[:readTo])
 				if perr != nil {
-					return nil, perr
+					return perr
 				}
 				if consumed > 0 {
 					if consumed < readTo {
@@ -108,19 +158,17 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 						readTo = 0
 					}
 				}
-				// If parser is done, return, otherwise EOF and incomplete
-				if req.state == ParserDone {
-					// Silence potential staticcheck unused-value warnings for bookkeeping vars
-					_ = readTo
-					return req, nil
+				// If parser got far enough, return, otherwise EOF and incomplete
+				if r.state >= want {
+					return nil
 				}
-				return nil, fmt.Errorf("incomplete request after EOF: need more data")
+				return fmt.Errorf("incomplete request after EOF: need more data")
 			}
-			return nil, err
+			return err
 		}
 	}
 
-	return req, nil
+	return nil
 }
 
 // parse consumes bytes from data to incrementally parse the request. It
@@ -158,6 +206,9 @@ func (r *Request) parse(data []byte) (int, error) {
 		if err != nil {
 			return 0, err
 		}
+		if n > 0 && !done && isHostLine(data[:n]) {
+			r.hostFields++
+		}
 		if n == 0 && !done {
 			// need more data
 			return 0, nil
@@ -174,10 +225,11 @@ func (r *Request) parse(data []byte) (int, error) {
 		// Check for Content-Length header
 		headerVal := r.Headers.Get("Content-Length")
 		if headerVal == "" {
-			// No body expected: mark done and consume any provided bytes so the
-			// caller can make forward progress rather than looping on 0-consume.
+			// No body expected: mark done and leave any further bytes unread.
+			// They belong to whatever follows the request, which Buffered
+			// hands to a handler taking over the connection.
 			r.state = ParserDone
-			return len(data), nil
+			return 0, nil
 		}
 
 		// Convert Content-Length to integer using strconv for clearer errors.
@@ -186,12 +238,11 @@ func (r *Request) parse(data []byte) (int, error) {
 			return 0, fmt.Errorf("invalid Content-Length: %q", headerVal)
 		}
 
-		// Special case: if content length is 0, transition to done and consume
-		// any provided data so the caller can discard extra bytes and make
-		// forward progress.
+		// Special case: if content length is 0, transition to done without
+		// consuming anything, as above.
 		if contentLength == 0 {
 			r.state = ParserDone
-			return len(data), nil
+			return 0, nil
 		}
 
 		// Initialize body if needed
@@ -226,6 +277,20 @@ func (r *Request) parse(data []byte) (int, error) {
 	}
 }
 
+// isHostLine reports whether line, a field line Headers.Parse accepted, is
+// a Host field.
+func isHostLine(line []byte) bool {
+	name, _, _ := bytes.Cut(line, []byte(":"))
+	return strings.EqualFold(string(name), "host")
+}
+
+// HostFields returns how many Host field lines the request had. Repeated
+// lines are joined in Headers, and an empty one vanishes there entirely,
+// so this is what tells one Host from several (RFC 9112 section 3.2).
+func (r *Request) HostFields() int {
+	return r.hostFields
+}
+
 // parseRequestLine examines the provided bytes for a CRLF-terminated
 // request-line. If a full line is found it parses and validates the
 // request-line and returns a populated RequestLine and the number of bytes
@@ -270,8 +335,17 @@ func requestLineFromString(line string) (*RequestLine, error) {
 	}
 
 	requestTarget := parts[1]
-	if !strings.HasPrefix(requestTarget, "/") {
-		return nil, fmt.Errorf("invalid request target in request line: %q", requestTarget)
+	target, err := ParseTarget(requestTarget)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request target in request line: %w", err)
+	}
+	// CONNECT names an authority and nothing else does; "*" is only for
+	// server-wide OPTIONS (RFC 9112 sections 3.2.3 and 3.2.4).
+	if (method == "CONNECT") != (target.Form == AuthorityForm) {
+		return nil, fmt.Errorf("invalid request target for %s: %q", method, requestTarget)
+	}
+	if target.Form == AsteriskForm && method != "OPTIONS" {
+		return nil, fmt.Errorf("asterisk request target only allowed for OPTIONS, got %s", method)
 	}
 
 	httpVersionToken := parts[2]
@@ -1,12 +1,23 @@
 package request
 
 import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"fmt"
 	"io"
+	"mime/multipart"
+	"net"
+	"os"
+	"strconv"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/cookie"
 )
 
 func TestRequestFromReader(t *testing.T) {
@@ -242,3 +253,409 @@ func TestParseBody(t *testing.T) {
 		assert.Nil(t, r.Body) // If no Content-Length, we ignore any body data
 	})
 }
+
+// encodedRequest builds a POST whose body is payload encoded with coding.
+func encodedRequest(t *testing.T, coding string, payload []byte) *Request {
+	var buf bytes.Buffer
+	var zw io.WriteCloser
+	switch coding {
+	case "gzip":
+		zw = gzip.NewWriter(&buf)
+	case "deflate":
+		zw = zlib.NewWriter(&buf)
+	default:
+		buf.Write(payload)
+	}
+	if zw != nil {
+		_, err := zw.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+	}
+	raw := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n", coding, buf.Len())
+	r, err := RequestFromReader(io.MultiReader(strings.NewReader(raw), &buf))
+	require.NoError(t, err)
+	return r
+}
+
+func TestDecompressBody(t *testing.T) {
+	payload := []byte(strings.Repeat("compressible ", 100))
+
+	t.Run("gzip", func(t *testing.T) {
+		r := encodedRequest(t, "gzip", payload)
+		require.NoError(t, r.DecompressBody(1<<20))
+		assert.Equal(t, payload, r.Body)
+		assert.Equal(t, "", r.Headers.Get("Content-Encoding"))
+		assert.Equal(t, strconv.Itoa(len(payload)), r.Headers.Get("Content-Length"))
+	})
+
+	t.Run("deflate", func(t *testing.T) {
+		r := encodedRequest(t, "deflate", payload)
+		require.NoError(t, r.DecompressBody(1<<20))
+		assert.Equal(t, payload, r.Body)
+	})
+
+	t.Run("Decoded size limit", func(t *testing.T) {
+		r := encodedRequest(t, "gzip", payload)
+		assert.ErrorIs(t, r.DecompressBody(int64(len(payload)-1)), ErrBodyTooLarge)
+		r = encodedRequest(t, "gzip", payload)
+		assert.NoError(t, r.DecompressBody(int64(len(payload))))
+	})
+
+	t.Run("Unsupported encoding", func(t *testing.T) {
+		r := encodedRequest(t, "br", payload)
+		assert.ErrorIs(t, r.DecompressBody(1<<20), ErrUnsupportedEncoding)
+	})
+
+	t.Run("Corrupt body", func(t *testing.T) {
+		r := encodedRequest(t, "identity", payload)
+		r.Headers["content-encoding"] = "gzip"
+		err := r.DecompressBody(1 << 20)
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrUnsupportedEncoding)
+	})
+}
+
+func TestRequestTarget(t *testing.T) {
+	// Test: Origin-form with encoded path and repeated query keys
+	r, err := RequestFromReader(strings.NewReader("GET /a%20b/./c/../d?x=1&y=two+words&x=%3D3&flag HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, OriginForm, r.Target().Form)
+	assert.Equal(t, "/a%20b/./c/../d", r.Target().RawPath)
+	assert.Equal(t, "/a b/./c/../d", r.Path())
+	assert.Equal(t, "/a b/d", r.CleanPath())
+	q := r.Query()
+	assert.Equal(t, []string{"1", "=3"}, q["x"])
+	assert.Equal(t, "1", q.Get("x"))
+	assert.Equal(t, "two words", q.Get("y"))
+	assert.True(t, q.Has("flag"))
+	assert.False(t, q.Has("missing"))
+
+	// Test: Absolute-form
+	r, err = RequestFromReader(strings.NewReader("GET http://example.com:8080/video?t=10 HTTP/1.1\r\nHost: example.com\r\n\r\n"))
+	require.NoError(t, err)
+	tg := r.Target()
+	assert.Equal(t, AbsoluteForm, tg.Form)
+	assert.Equal(t, "http", tg.Scheme)
+	assert.Equal(t, "example.com:8080", tg.Host)
+	assert.Equal(t, "/video", r.CleanPath())
+	assert.Equal(t, "10", r.Query().Get("t"))
+
+	// Test: Authority-form only for CONNECT
+	r, err = RequestFromReader(strings.NewReader("CONNECT example.com:443 HTTP/1.1\r\nHost: example.com:443\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AuthorityForm, r.Target().Form)
+	assert.Equal(t, "example.com:443", r.Target().Host)
+	_, err = RequestFromReader(strings.NewReader("GET example.com:443 HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.Error(t, err)
+	_, err = RequestFromReader(strings.NewReader("CONNECT /path HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Asterisk-form only for OPTIONS
+	r, err = RequestFromReader(strings.NewReader("OPTIONS * HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, AsteriskForm, r.Target().Form)
+	_, err = RequestFromReader(strings.NewReader("GET * HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Garbage targets are still rejected
+	_, err = RequestFromReader(strings.NewReader("GET ftp://x/y HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.Error(t, err)
+	_, err = RequestFromReader(strings.NewReader("GET coffee HTTP/1.1\r\nHost: x\r\n\r\n"))
+	require.Error(t, err)
+
+	// Test: Dot segments can't climb above the root
+	assert.Equal(t, "/", RemoveDotSegments("/../.."))
+	assert.Equal(t, "/b", RemoveDotSegments("/a/../../b"))
+	assert.Equal(t, "/a/", RemoveDotSegments("/a/b/.."))
+	assert.Equal(t, "/a/b/", RemoveDotSegments("/a/b/"))
+}
+
+// multipartRequest builds a multipart/form-data POST from the parts written
+// by fill.
+func multipartRequest(t *testing.T, fill func(mw *multipart.Writer)) *Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fill(mw)
+	require.NoError(t, mw.Close())
+	raw := fmt.Sprintf("POST /upload HTTP/1.1\r\nHost: x\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", mw.FormDataContentType(), body.Len())
+	r, err := RequestFromReader(io.MultiReader(strings.NewReader(raw), &body))
+	require.NoError(t, err)
+	return r
+}
+
+func TestForms(t *testing.T) {
+	t.Run("urlencoded", func(t *testing.T) {
+		body := "name=Ada+Lovelace&tag=a&tag=b"
+		r, err := RequestFromReader(strings.NewReader(fmt.Sprintf("POST /f HTTP/1.1\r\nHost: x\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n%s", len(body), body)))
+		require.NoError(t, err)
+		q, err := r.ParseForm()
+		require.NoError(t, err)
+		assert.Equal(t, "Ada Lovelace", q.Get("name"))
+		assert.Equal(t, []string{"a", "b"}, q["tag"])
+		_, err = r.ParseMultipartForm(FormLimits{})
+		assert.ErrorIs(t, err, ErrNotForm)
+	})
+
+	t.Run("multipart in memory and spilled", func(t *testing.T) {
+		big := strings.Repeat("x", 100)
+		r := multipartRequest(t, func(mw *multipart.Writer) {
+			mw.WriteField("note", "hi")
+			fw, _ := mw.CreateFormFile("file", "small.txt")
+			fw.Write([]byte("small"))
+			fw, _ = mw.CreateFormFile("file", "big.txt")
+			fw.Write([]byte(big))
+		})
+		form, err := r.ParseMultipartForm(FormLimits{MaxMemory: 10})
+		require.NoError(t, err)
+		defer form.RemoveAll()
+		assert.Equal(t, "hi", form.Values.Get("note"))
+		require.Len(t, form.Files["file"], 2)
+
+		small := form.Files["file"][0]
+		assert.Equal(t, "small.txt", small.Filename)
+		assert.Equal(t, int64(5), small.Size)
+		assert.Empty(t, small.tmpPath)
+
+		large := form.Files["file"][1]
+		assert.Equal(t, int64(100), large.Size)
+		require.NotEmpty(t, large.tmpPath)
+		f, err := large.Open()
+		require.NoError(t, err)
+		data, _ := io.ReadAll(f)
+		f.Close()
+		assert.Equal(t, big, string(data))
+
+		tmp := large.tmpPath
+		require.NoError(t, form.RemoveAll())
+		_, err = os.Stat(tmp)
+		assert.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("multipart limits", func(t *testing.T) {
+		r := multipartRequest(t, func(mw *multipart.Writer) {
+			mw.WriteField("a", "1")
+			mw.WriteField("b", "2")
+		})
+		_, err := r.ParseMultipartForm(FormLimits{MaxParts: 1})
+		assert.ErrorIs(t, err, ErrTooManyParts)
+
+		r = multipartRequest(t, func(mw *multipart.Writer) {
+			fw, _ := mw.CreateFormFile("file", "f.bin")
+			fw.Write(make([]byte, 50))
+		})
+		_, err = r.ParseMultipartForm(FormLimits{MaxPartSize: 49, MaxMemory: 10})
+		assert.ErrorIs(t, err, ErrBodyTooLarge)
+	})
+
+	t.Run("streaming parts", func(t *testing.T) {
+		r := multipartRequest(t, func(mw *multipart.Writer) {
+			fw, _ := mw.CreateFormFile("file", "s.txt")
+			fw.Write([]byte("streamed"))
+		})
+		mr, err := r.MultipartReader()
+		require.NoError(t, err)
+		part, err := mr.NextPart()
+		require.NoError(t, err)
+		assert.Equal(t, "s.txt", part.FileName())
+		data, _ := io.ReadAll(part)
+		assert.Equal(t, "streamed", string(data))
+	})
+}
+
+func TestRequestCookies(t *testing.T) {
+	// Test: Cookie fields sent on separate lines are all seen
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: x\r\nCookie: a=1; b=2\r\nCookie: c=3\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Len(t, r.Cookies(), 3)
+	c, err := r.Cookie("c")
+	require.NoError(t, err)
+	assert.Equal(t, "3", c.Value)
+
+	// Test: Missing cookie
+	_, err = r.Cookie("nope")
+	assert.ErrorIs(t, err, cookie.ErrNoCookie)
+}
+
+func TestHeadFromReader(t *testing.T) {
+	// Test: The body is left unread until ReadBody
+	raw := "POST /up HTTP/1.1\r\nHost: x\r\nExpect: 100-Continue\r\nContent-Length: 5\r\n\r\nhello"
+	r, err := HeadFromReader(&chunkReader{data: raw, numBytesPerRead: 3})
+	require.NoError(t, err)
+	assert.True(t, r.ExpectsContinue())
+	assert.True(t, r.BodyPending())
+	assert.Equal(t, int64(5), r.ContentLength())
+	assert.Empty(t, r.Body)
+
+	// Test: Hooks run around the first read only
+	var calls []string
+	r.BeforeBodyRead(func() error { calls = append(calls, "before"); return nil })
+	r.AfterBodyRead(func() error { calls = append(calls, "after:"+string(r.Body)); return nil })
+	body, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+	assert.False(t, r.BodyPending())
+	_, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"before", "after:hello"}, calls)
+
+	// Test: A failing hook stops the read and sticks
+	r, err = HeadFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	r.BeforeBodyRead(func() error { return io.ErrClosedPipe })
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+	_, err = r.ReadBody()
+	assert.ErrorIs(t, err, io.ErrClosedPipe)
+
+	// Test: Fully read requests return their body directly
+	r, err = RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	body, err = r.ReadBody()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestHostFields(t *testing.T) {
+	cases := map[string]int{
+		"GET / HTTP/1.1\r\n\r\n":                                      0,
+		"GET / HTTP/1.1\r\nHost: a\r\n\r\n":                           1,
+		"GET / HTTP/1.1\r\nHost: a\r\nX-Host: b\r\n\r\n":              1,
+		"GET / HTTP/1.1\r\nHOST:\r\nhost: a\r\n\r\n":                  2,
+		"GET / HTTP/1.1\r\nHost: a\r\nAccept: */*\r\nHost: a\r\n\r\n": 2,
+	}
+	for raw, want := range cases {
+		r, err := RequestFromReader(strings.NewReader(raw))
+		require.NoError(t, err)
+		// Test: each Host field line is counted, empty ones included
+		assert.Equal(t, want, r.HostFields(), raw)
+	}
+}
+
+func TestBodyReader(t *testing.T) {
+	// Test: The body is streamed from the source, capped at Content-Length
+	body := strings.Repeat("0123456789", 10000)
+	raw := fmt.Sprintf("POST /up HTTP/1.1\r\nHost: x\r\nContent-Length: %d\r\n\r\n%sNEXT", len(body), body)
+	src := &chunkReader{data: raw, numBytesPerRead: 64}
+	r, err := HeadFromReader(src)
+	require.NoError(t, err)
+	var calls []string
+	r.BeforeBodyRead(func() error { calls = append(calls, "before"); return nil })
+	r.AfterBodyRead(func() error { calls = append(calls, "after"); return nil })
+	br, err := r.BodyReader()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"before"}, calls)
+	start := make([]byte, 100)
+	_, err = io.ReadFull(br, start)
+	require.NoError(t, err)
+	assert.Equal(t, body[:100], string(start))
+	assert.Less(t, src.pos, len(raw)/2)
+	rest, err := io.ReadAll(br)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(start)+string(rest))
+	assert.Equal(t, []string{"before", "after"}, calls)
+	assert.Equal(t, len(raw)-len("NEXT"), src.pos)
+
+	// Test: A streamed body can't be read again
+	assert.False(t, r.BodyPending())
+	again, err := r.ReadBody()
+	require.NoError(t, err)
+	assert.Empty(t, again)
+
+	// Test: A body cut short is an error
+	r, err = HeadFromReader(strings.NewReader("POST /up HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\nshort"))
+	require.NoError(t, err)
+	br, err = r.BodyReader()
+	require.NoError(t, err)
+	_, err = io.ReadAll(br)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Multipart parts arrive before the rest of the upload is read
+	var mpBody bytes.Buffer
+	mw := multipart.NewWriter(&mpBody)
+	fw, _ := mw.CreateFormFile("file", "big.bin")
+	fw.Write(make([]byte, 1<<18))
+	mw.WriteField("note", "after the file")
+	require.NoError(t, mw.Close())
+	raw = fmt.Sprintf("POST /up HTTP/1.1\r\nHost: x\r\nContent-Type: %s\r\nContent-Length: %d\r\n\r\n", mw.FormDataContentType(), mpBody.Len()) + mpBody.String()
+	src = &chunkReader{data: raw, numBytesPerRead: 512}
+	r, err = HeadFromReader(src)
+	require.NoError(t, err)
+	mr, err := r.MultipartReader()
+	require.NoError(t, err)
+	part, err := mr.NextPart()
+	require.NoError(t, err)
+	assert.Equal(t, "big.bin", part.FileName())
+	assert.Less(t, src.pos, len(raw)/2)
+
+	// Test: Uploads too large for MaxMemory are spilled while streaming
+	src = &chunkReader{data: raw, numBytesPerRead: 512}
+	r, err = HeadFromReader(src)
+	require.NoError(t, err)
+	form, err := r.ParseMultipartForm(FormLimits{MaxMemory: 1024})
+	require.NoError(t, err)
+	defer form.RemoveAll()
+	require.Len(t, form.Files["file"], 1)
+	assert.NotEmpty(t, form.Files["file"][0].tmpPath)
+	assert.Equal(t, "after the file", form.Values.Get("note"))
+
+	// Test: DecompressOnRead decodes streams and whole bodies alike
+	payload := []byte(strings.Repeat("compressible ", 100))
+	for _, coding := range []string{"gzip", "deflate"} {
+		encoded := encodedRequest(t, coding, payload)
+		head := fmt.Sprintf("POST /up HTTP/1.1\r\nHost: x\r\nContent-Encoding: %s\r\nContent-Length: %d\r\n\r\n", coding, len(encoded.Body))
+
+		r, err = HeadFromReader(io.MultiReader(strings.NewReader(head), bytes.NewReader(encoded.Body)))
+		require.NoError(t, err)
+		r.DecompressOnRead(1 << 20)
+		br, err = r.BodyReader()
+		require.NoError(t, err)
+		decoded, err := io.ReadAll(br)
+		require.NoError(t, err)
+		assert.Equal(t, payload, decoded, coding)
+		assert.Empty(t, r.Headers.Get("Content-Encoding"))
+
+		r, err = HeadFromReader(io.MultiReader(strings.NewReader(head), bytes.NewReader(encoded.Body)))
+		require.NoError(t, err)
+		r.DecompressOnRead(1 << 20)
+		decoded, err = r.ReadBody()
+		require.NoError(t, err)
+		assert.Equal(t, payload, decoded, coding)
+	}
+
+	// Test: The decoded size limit applies to streams
+	encoded := encodedRequest(t, "gzip", payload)
+	r, err = HeadFromReader(io.MultiReader(
+		strings.NewReader(fmt.Sprintf("POST /up HTTP/1.1\r\nHost: x\r\nContent-Encoding: gzip\r\nContent-Length: %d\r\n\r\n", len(encoded.Body))),
+		bytes.NewReader(encoded.Body)))
+	require.NoError(t, err)
+	r.DecompressOnRead(int64(len(payload) - 1))
+	br, err = r.BodyReader()
+	require.NoError(t, err)
+	_, err = io.ReadAll(br)
+	assert.ErrorIs(t, err, ErrBodyTooLarge)
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: A parsed request has a usable context by default
+	r, err := RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	require.NotNil(t, r.Context())
+	assert.NoError(t, r.Context().Err())
+
+	// Test: SetContext replaces it
+	ctx, cancel := context.WithCancel(context.Background())
+	r.SetContext(ctx)
+	cancel()
+	assert.ErrorIs(t, r.Context().Err(), context.Canceled)
+	assert.Panics(t, func() { r.SetContext(nil) })
+}
+
+func TestConnInfoRemoteIP(t *testing.T) {
+	// Test: RemoteIP strips the port, IPv6 brackets included
+	assert.Equal(t, "192.0.2.1", ConnInfo{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4000}}.RemoteIP())
+	assert.Equal(t, "2001:db8::1", ConnInfo{RemoteAddr: &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4000}}.RemoteIP())
+
+	// Test: Unknown or portless addresses
+	assert.Equal(t, "", ConnInfo{}.RemoteIP())
+	assert.Equal(t, "/tmp/sock", ConnInfo{RemoteAddr: &net.UnixAddr{Name: "/tmp/sock", Net: "unix"}}.RemoteIP())
+}
@@ -0,0 +1,198 @@
+package request
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// TargetForm identifies which of the four request-target forms a request
+// used (RFC 9112 section 3.2).
+type TargetForm int
+
+const (
+	// OriginForm is "/path?query", used by almost every request.
+	OriginForm TargetForm = iota
+	// AbsoluteForm is "http://host/path?query", sent to proxies.
+	AbsoluteForm
+	// AuthorityForm is "host:port", only valid for CONNECT.
+	AuthorityForm
+	// AsteriskForm is "*", only valid for server-wide OPTIONS.
+	AsteriskForm
+)
+
+// Target is a parsed request-target.
+type Target struct {
+	Form TargetForm
+	// Scheme is set for the absolute form.
+	Scheme string
+	// Host is set for the absolute and authority forms.
+	Host string
+	// RawPath is the path as sent, still percent-encoded. It is empty for
+	// the authority and asterisk forms.
+	RawPath string
+	// RawQuery is everything after the first "?", without the "?".
+	RawQuery string
+}
+
+// Query holds decoded query parameters. A key may appear more than once, so
+// every value is kept in the order it was sent.
+type Query map[string][]string
+
+// Get returns the first value for key, or "" if there is none.
+func (q Query) Get(key string) string {
+	if vs := q[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Has reports whether key was present, even with an empty value.
+func (q Query) Has(key string) bool {
+	_, ok := q[key]
+	return ok
+}
+
+// ParseTarget splits a request-target into its parts and works out which
+// form it uses. It does not check that the form suits the method.
+func ParseTarget(raw string) (Target, error) {
+	switch {
+	case raw == "":
+		return Target{}, fmt.Errorf("empty request target")
+	case raw == "*":
+		return Target{Form: AsteriskForm}, nil
+	case strings.HasPrefix(raw, "/"):
+		t := Target{Form: OriginForm}
+		t.RawPath, t.RawQuery, _ = strings.Cut(raw, "?")
+		return t, nil
+	}
+
+	if scheme, rest, ok := strings.Cut(raw, "://"); ok {
+		scheme = strings.ToLower(scheme)
+		if scheme != "http" && scheme != "https" {
+			return Target{}, fmt.Errorf("unsupported scheme in request target: %q", raw)
+		}
+		rest, _, _ = strings.Cut(rest, "#")
+		t := Target{Form: AbsoluteForm, Scheme: scheme}
+		end := strings.IndexAny(rest, "/?")
+		if end == -1 {
+			end = len(rest)
+		}
+		t.Host = rest[:end]
+		if t.Host == "" {
+			return Target{}, fmt.Errorf("missing host in request target: %q", raw)
+		}
+		t.RawPath, t.RawQuery, _ = strings.Cut(rest[end:], "?")
+		if t.RawPath == "" {
+			t.RawPath = "/"
+		}
+		return t, nil
+	}
+
+	// authority-form is exactly host ":" port.
+	host, port, ok := strings.Cut(raw, ":")
+	if strings.HasPrefix(raw, "[") {
+		i := strings.Index(raw, "]:")
+		ok = i > 0
+		if ok {
+			host, port = raw[:i+1], raw[i+2:]
+		}
+	}
+	if !ok || host == "" || port == "" || strings.ContainsAny(raw, "/?#@") {
+		return Target{}, fmt.Errorf("invalid request target: %q", raw)
+	}
+	for _, ch := range port {
+		if ch < '0' || ch > '9' {
+			return Target{}, fmt.Errorf("invalid port in request target: %q", raw)
+		}
+	}
+	return Target{Form: AuthorityForm, Host: raw}, nil
+}
+
+// Target returns the parsed request-target.
+func (r *Request) Target() Target {
+	t, err := ParseTarget(r.RequestLine.RequestTarget)
+	if err != nil {
+		return Target{RawPath: r.RequestLine.RequestTarget}
+	}
+	return t
+}
+
+// Path returns the percent-decoded path of the request-target. Invalid
+// escapes are left as they were sent.
+func (r *Request) Path() string {
+	raw := r.Target().RawPath
+	p, err := url.PathUnescape(raw)
+	if err != nil {
+		return raw
+	}
+	return p
+}
+
+// CleanPath returns the decoded path with "." and ".." segments resolved as
+// in RFC 3986 section 5.2.4, so "/a/./b/../c" becomes "/a/c". A ".." can't
+// climb above "/". Routing should match on this rather than the raw target.
+func (r *Request) CleanPath() string {
+	return RemoveDotSegments(r.Path())
+}
+
+// Query parses the query string. Keys and values are decoded with "+" as a
+// space; pairs that fail to decode are skipped.
+func (r *Request) Query() Query {
+	return ParseQuery(r.Target().RawQuery)
+}
+
+// ParseQuery decodes a query string or urlencoded form into a Query.
+func ParseQuery(raw string) Query {
+	q := Query{}
+	for _, pair := range strings.Split(raw, "&") {
+		if pair == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(k)
+		if err != nil {
+			continue
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			continue
+		}
+		q[key] = append(q[key], value)
+	}
+	return q
+}
+
+// RemoveDotSegments applies the RFC 3986 dot-segment removal algorithm to an
+// absolute path. A trailing slash, or a trailing "." or "..", leaves the
+// result ending in "/".
+func RemoveDotSegments(p string) string {
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	out := make([]string, 0, len(segments))
+	for i, seg := range segments {
+		last := i == len(segments)-1
+		switch seg {
+		case ".":
+			if last {
+				out = append(out, "")
+			}
+		case "..":
+			if len(out) > 1 {
+				out = out[:len(out)-1]
+			}
+			if last {
+				out = append(out, "")
+			}
+		default:
+			out = append(out, seg)
+		}
+	}
+	cleaned := strings.Join(out, "/")
+	if !strings.HasPrefix(cleaned, "/") {
+		cleaned = "/" + cleaned
+	}
+	return cleaned
+}
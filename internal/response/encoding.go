@@ -0,0 +1,66 @@
+package response
+
+import (
+	"io"
+
+	"httpfromtcp/internal/headers"
+)
+
+// An EncoderFunc decides, once the status and headers of a response are
+// final, whether its body should be transformed (compressed, for example).
+// size is the body length when the whole body is known and -1 when it is
+// streamed. To encode, it edits h as needed (setting Content-Encoding, say)
+// and returns a WriteCloser that writes the encoded bytes to dst; returning
+// nil leaves the body untouched. Content-Length is handled by the Writer.
+type EncoderFunc func(status StatusCode, h headers.Headers, size int64, dst io.Writer) io.WriteCloser
+
+// SetBodyEncoder installs f to be consulted when the response commits. It
+// applies to the buffered API and to low-level chunked responses; a low-level
+// response with Content-Length can't change length and is sent as written.
+func (w *Writer) SetBodyEncoder(f EncoderFunc) {
+	w.encodeFunc = f
+}
+
+// startEncoder asks the EncoderFunc for an encoder writing to dst and
+// reports whether one was started.
+func (w *Writer) startEncoder(h headers.Headers, size int64, dst io.Writer) bool {
+	enc := w.encodeFunc(w.status, h, size, dst)
+	if enc == nil {
+		return false
+	}
+	w.encoder = enc
+	return true
+}
+
+// closeEncoder flushes and detaches the active encoder, if any.
+func (w *Writer) closeEncoder() error {
+	if w.encoder == nil {
+		return nil
+	}
+	enc := w.encoder
+	w.encoder = nil
+	return enc.Close()
+}
+
+// framedSink receives encoded bytes for a response written with the buffered
+// API and sends them with the framing chosen by commit.
+type framedSink struct {
+	w *Writer
+}
+
+func (s framedSink) Write(p []byte) (int, error) {
+	return s.w.writeFramed(p)
+}
+
+// chunkSink receives encoded bytes for a low-level chunked response and
+// writes each batch as a chunk.
+type chunkSink struct {
+	w *Writer
+}
+
+func (s chunkSink) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	return s.w.writeChunk(p)
+}
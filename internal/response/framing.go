@@ -0,0 +1,338 @@
+package response
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/headers"
+)
+
+// DefaultBufferSize is how many body bytes the buffered API holds before it
+// gives up on Content-Length and switches the response to chunked encoding.
+const DefaultBufferSize = 32 * 1024
+
+// ErrMixedWrites is returned when the buffered API is used after the handler
+// already wrote part of the response with the low-level methods.
+var ErrMixedWrites = errors.New("response already started with low-level writes")
+
+// ErrBodyTooLong is returned when a handler writes more bytes than the
+// Content-Length it declared.
+var ErrBodyTooLong = errors.New("body exceeds declared content-length")
+
+// Header returns the headers the buffered API will send. Changes made after
+// the response has been committed (by Flush, a large Write or Finish) are
+// ignored.
+func (w *Writer) Header() headers.Headers {
+	if w.header == nil {
+		w.header = headers.NewHeaders()
+	}
+	return w.header
+}
+
+// SetStatus records the status code to send. It does not write anything; the
+// status line goes out together with the headers when the response commits.
+func (w *Writer) SetStatus(statusCode StatusCode) {
+	w.status = statusCode
+}
+
+// Status returns the response's status: the one sent if the status line
+// has gone out, through either API, or else the one the buffered API will
+// send, 200 unless SetStatus changed it.
+func (w *Writer) Status() StatusCode {
+	return w.status
+}
+
+// SetBufferSize changes how many body bytes are buffered before the response
+// is committed. It has no effect once the response has been committed.
+func (w *Writer) SetBufferSize(n int) {
+	w.bufferSize = n
+}
+
+// Write buffers p as part of the response body. Small bodies stay in memory
+// so Finish can send them with an exact Content-Length. Once the buffer would
+// exceed its limit the headers are sent and the body is streamed, chunked
+// unless the handler set Content-Length itself.
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, ErrHijacked
+	}
+	if !w.managed {
+		if w.state != writerStateStatusLine {
+			return 0, ErrMixedWrites
+		}
+		if len(p) > 0 && !bodyAllowed(w.status) {
+			return 0, ErrBodyNotAllowed
+		}
+		if len(w.buf)+len(p) <= w.bufferSize {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		if err := w.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	return w.writeBody(p)
+}
+
+// ReadFrom copies r into the body. When the handler declared Content-Length
+// and the destination implements io.ReaderFrom, as a *net.TCPConn does, the
+// copy is handed to the destination so the kernel can sendfile an *os.File
+// straight to the socket.
+func (w *Writer) ReadFrom(r io.Reader) (int64, error) {
+	if !w.managed && w.state == writerStateStatusLine && len(w.buf) == 0 &&
+		w.Header().Get("Content-Length") != "" {
+		if err := w.commit(true); err != nil {
+			return 0, err
+		}
+	}
+	// Once the headers of a HEAD response are out it is complete, so don't
+	// read a body only to drop it. Before then the body is still needed to
+	// work out Content-Length.
+	if w.managed && w.state == writerStateBody && w.method == "HEAD" && bodyAllowed(w.status) {
+		if !w.chunked {
+			w.written = w.declaredLen
+		}
+		return 0, nil
+	}
+	rf, ok := w.dest.(io.ReaderFrom)
+	if !ok || !w.managed || w.chunked || w.digest != nil || w.encoder != nil || w.state != writerStateBody {
+		return io.Copy(writerOnly{w}, r)
+	}
+	if skip, _ := w.skipBody(nil); skip {
+		return io.Copy(writerOnly{w}, r)
+	}
+	// Pass an already limited reader through untouched: sendfile only looks
+	// through one *io.LimitedReader to find the file.
+	remaining := int64(w.declaredLen - w.written)
+	if lr, ok := r.(*io.LimitedReader); !ok || lr.N > remaining {
+		r = io.LimitReader(r, remaining)
+	}
+	n, err := rf.ReadFrom(r)
+	w.written += int(n)
+	return n, err
+}
+
+// writerOnly hides Writer.ReadFrom so io.Copy falls back to plain writes.
+type writerOnly struct {
+	io.Writer
+}
+
+// Flush sends the headers, if they haven't gone out yet, followed by any
+// buffered body bytes. A response that is flushed before it is finished is
+// always streamed, so its final length doesn't need to be known yet.
+func (w *Writer) Flush() error {
+	if !w.managed {
+		if w.state != writerStateStatusLine {
+			return ErrMixedWrites
+		}
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		if err := f.Flush(); err != nil {
+			return err
+		}
+	}
+	if f, ok := w.dest.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// Finish completes a response written with the buffered API: a buffered body
+// is sent with Content-Length, a streamed one gets its terminating chunk. It
+// is safe to call more than once and does nothing for responses the handler
+// wrote with the low-level methods.
+func (w *Writer) Finish() error {
+	if w.hijacked {
+		return nil
+	}
+	if !w.managed {
+		if w.state != writerStateStatusLine {
+			return nil
+		}
+		if err := w.commit(false); err != nil {
+			return err
+		}
+	}
+	if w.state != writerStateBody {
+		return nil
+	}
+	if err := w.closeEncoder(); err != nil {
+		return err
+	}
+	if w.chunked {
+		return w.finishTrailers()
+	}
+	if w.written != w.declaredLen {
+		return fmt.Errorf("body length %d does not match content-length %d", w.written, w.declaredLen)
+	}
+	w.state = writerStateDone
+	return nil
+}
+
+// commit picks the framing for the response, writes the status line and
+// headers, then sends whatever body bytes were buffered. streaming says more
+// body may follow, so the buffered length can't be used as Content-Length.
+func (w *Writer) commit(streaming bool) error {
+	w.managed = true
+	hooks := w.beforeCommit
+	w.beforeCommit = nil
+	w.bodyComplete = !streaming
+	for _, fn := range hooks {
+		fn()
+	}
+	w.bodyComplete = false
+	h := w.Header()
+
+	// Let the encoder see the headers before framing is chosen. A complete
+	// buffered body is encoded here so it can still get a Content-Length;
+	// a streamed one loses any declared length and goes out chunked.
+	if w.encodeFunc != nil && bodyAllowed(w.status) {
+		if streaming {
+			if w.startEncoder(h, -1, framedSink{w}) {
+				delete(h, "content-length")
+			}
+		} else if len(w.buf) > 0 {
+			var encoded bytes.Buffer
+			if w.startEncoder(h, int64(len(w.buf)), &encoded) {
+				if _, err := w.encoder.Write(w.buf); err != nil {
+					return err
+				}
+				if err := w.closeEncoder(); err != nil {
+					return err
+				}
+				w.buf = encoded.Bytes()
+				delete(h, "content-length")
+			}
+		}
+	}
+
+	if h.Get("Connection") == "" {
+		h["connection"] = "close"
+	}
+	if h.Get("Content-Type") == "" && bodyAllowed(w.status) {
+		h["content-type"] = "text/plain"
+	}
+
+	// Bodyless statuses get no framing and declared trailers need chunked
+	// encoding. Otherwise Content-Length set by the handler wins, then the
+	// buffered length when the body is complete, falling back to chunked
+	// when it isn't.
+	if !bodyAllowed(w.status) {
+		// No content follows, so there is nothing to frame. A 304 may still
+		// carry the Content-Length a 200 would have had.
+		if len(w.buf) > 0 {
+			return ErrBodyNotAllowed
+		}
+		delete(h, "transfer-encoding")
+		if w.status != StatusNotModified {
+			delete(h, "content-length")
+		}
+	} else if len(w.trailerNames) > 0 {
+		w.chunked = true
+		delete(h, "content-length")
+		h["transfer-encoding"] = "chunked"
+		h["trailer"] = strings.Join(w.trailerNames, ", ")
+	} else if v := h.Get("Content-Length"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid content-length %q", v)
+		}
+		w.declaredLen = n
+		delete(h, "transfer-encoding")
+	} else if streaming {
+		w.chunked = true
+		h["transfer-encoding"] = "chunked"
+	} else {
+		w.declaredLen = len(w.buf)
+		h["content-length"] = strconv.Itoa(len(w.buf))
+		delete(h, "transfer-encoding")
+	}
+
+	if err := w.WriteStatusLine(w.status); err != nil {
+		return err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return err
+	}
+
+	buffered := w.buf
+	w.buf = nil
+	if len(buffered) == 0 {
+		return nil
+	}
+	_, err := w.writeBody(buffered)
+	return err
+}
+
+// writeBody sends p through the encoder, if one is active, and then the
+// framing chosen by commit.
+func (w *Writer) writeBody(p []byte) (int, error) {
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+	return w.writeFramed(p)
+}
+
+// writeFramed sends p using the framing chosen by commit.
+func (w *Writer) writeFramed(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.digest != nil {
+		w.digest.Write(p)
+	}
+	if w.chunked {
+		if err := w.checkState(writerStateBody, "chunked body"); err != nil {
+			return 0, err
+		}
+		return w.writeChunk(p)
+	}
+	if w.written+len(p) > w.declaredLen {
+		return 0, ErrBodyTooLong
+	}
+	n, err := w.WriteBody(p)
+	w.written += n
+	return n, err
+}
+
+// BeforeCommit registers fn to run when a response written with the
+// buffered API is about to send its status line and headers, which is the
+// last chance to change them. Callbacks run once, in registration order.
+// Responses written with the low-level API never run them.
+func (w *Writer) BeforeCommit(fn func()) {
+	w.beforeCommit = append(w.beforeCommit, fn)
+}
+
+// BufferedBody returns the body bytes held back by the buffered API, and
+// whether they are the whole body. From a BeforeCommit callback, it is
+// complete when the handler returned without the response outgrowing the
+// buffer or being flushed; otherwise more may follow. The slice must not be
+// modified.
+func (w *Writer) BufferedBody() ([]byte, bool) {
+	return w.buf, w.bodyComplete
+}
+
+// DiscardBody drops the buffered body bytes, for a BeforeCommit callback
+// that turns the response into one without content, such as 304. If the
+// body was being streamed, the handler's later writes fail.
+func (w *Writer) DiscardBody() {
+	w.buf = nil
+}
+
+// SetCookie adds a Set-Cookie field for c to the response headers. Each
+// call adds its own field line, so several cookies can be set.
+func (w *Writer) SetCookie(c *cookie.Cookie) error {
+	if err := c.Valid(); err != nil {
+		return err
+	}
+	w.Header().Add("Set-Cookie", c.String())
+	return nil
+}
@@ -0,0 +1,61 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+// ErrHijacked is returned by writes to a Writer whose connection was taken
+// over with Hijack.
+var ErrHijacked = errors.New("connection has been hijacked")
+
+// ErrNotHijackable is returned by Hijack when the Writer has no connection
+// to hand over.
+var ErrNotHijackable = errors.New("connection cannot be hijacked")
+
+// A Hijacker hands over the connection behind a Writer. The returned reader
+// yields any bytes already read from the connection past the request,
+// followed by the rest of the stream.
+type Hijacker func() (net.Conn, *bufio.Reader, error)
+
+// SetHijacker makes Hijack available. The server sets it for every
+// connection it serves.
+func (w *Writer) SetHijacker(h Hijacker) {
+	w.hijacker = h
+}
+
+// Hijack takes the connection away from the server, for protocols such as
+// WebSocket that stop speaking HTTP. Whatever the handler already wrote with
+// the low-level API (a 101 response, say) has been sent; data buffered by
+// the buffered API would be lost, so Hijack refuses if there is any. After
+// it returns, the Writer rejects writes and the server leaves the
+// connection alone: closing it is the caller's job.
+func (w *Writer) Hijack() (net.Conn, *bufio.Reader, error) {
+	if w.hijacked {
+		return nil, nil, ErrHijacked
+	}
+	if w.hijacker == nil {
+		return nil, nil, ErrNotHijackable
+	}
+	if !w.managed && len(w.buf) > 0 {
+		return nil, nil, errors.New("hijack with unsent buffered response data")
+	}
+	if w.managed {
+		if err := w.Flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+	conn, br, err := w.hijacker()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.hijacked = true
+	w.state = writerStateDone
+	return conn, br, nil
+}
+
+// Hijacked reports whether Hijack has taken the connection.
+func (w *Writer) Hijacked() bool {
+	return w.hijacked
+}
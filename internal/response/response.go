@@ -1,39 +1,178 @@
 package response
 
 import (
+	"errors"
 	"fmt"
+	"hash"
 	"httpfromtcp/internal/headers"
 	"io"
 	"strconv"
+	"strings"
 )
 
 type StatusCode int
 
 const (
-	StatusOk                  StatusCode = 200
-	StatusBadRequest          StatusCode = 400
-	StatusInternalServerError StatusCode = 500
-	StatusRequestTimeout      StatusCode = 408
+	StatusContinue             StatusCode = 100
+	StatusSwitchingProtocols   StatusCode = 101
+	StatusOk                   StatusCode = 200
+	StatusNoContent            StatusCode = 204
+	StatusPartialContent       StatusCode = 206
+	StatusMovedPermanently     StatusCode = 301
+	StatusNotModified          StatusCode = 304
+	StatusBadRequest           StatusCode = 400
+	StatusUnauthorized         StatusCode = 401
+	StatusForbidden            StatusCode = 403
+	StatusNotFound             StatusCode = 404
+	StatusMethodNotAllowed     StatusCode = 405
+	StatusNotAcceptable        StatusCode = 406
+	StatusPreconditionFailed   StatusCode = 412
+	StatusContentTooLarge      StatusCode = 413
+	StatusUnsupportedMediaType StatusCode = 415
+	StatusRangeNotSatisfiable  StatusCode = 416
+	StatusExpectationFailed    StatusCode = 417
+	StatusUpgradeRequired      StatusCode = 426
+	StatusTooManyRequests      StatusCode = 429
+	StatusInternalServerError  StatusCode = 500
+	StatusRequestTimeout       StatusCode = 408
 )
 
+// bodyAllowed reports whether a response with this status may carry content.
+// 1xx, 204 and 304 responses end after the header section (RFC 9112 section 6.3).
+func bodyAllowed(statusCode StatusCode) bool {
+	return statusCode >= 200 && statusCode != StatusNoContent && statusCode != StatusNotModified
+}
+
+// writerState tracks which part of the response the Writer expects next so
+// the low-level methods can't be called out of order.
+type writerState int
+
+const (
+	writerStateStatusLine writerState = iota
+	writerStateHeaders
+	writerStateBody
+	writerStateTrailers
+	writerStateDone
+)
+
+// ErrConflictingFraming is returned by WriteHeaders when a header set carries
+// both Content-Length and Transfer-Encoding.
+var ErrConflictingFraming = errors.New("response has both content-length and transfer-encoding")
+
+// ErrNotInterim is returned by WriteInterim for a status outside 1xx, or for
+// 101, which ends the HTTP exchange rather than preceding a final response.
+var ErrNotInterim = errors.New("not an interim status code")
+
+// ErrBodyNotAllowed is returned when body bytes are written for a status that
+// can't carry content.
+var ErrBodyNotAllowed = errors.New("response status does not allow a body")
+
 type Writer struct {
-	dest io.Writer
+	dest  io.Writer
+	state writerState
+	// method is the request method being answered. HEAD responses send
+	// headers as for GET but discard the body.
+	method string
+
+	// Fields below back the buffered API in framing.go.
+	header      headers.Headers
+	status      StatusCode
+	buf         []byte
+	bufferSize  int
+	managed     bool
+	chunked     bool
+	declaredLen int
+	written     int
+
+	// Trailer state, see trailers.go.
+	declaredTrailers map[string]bool
+	trailerNames     []string
+	trailers         headers.Headers
+	digest           hash.Hash
+
+	// Body encoding, see encoding.go.
+	encodeFunc EncoderFunc
+	encoder    io.WriteCloser
+
+	// beforeCommit holds the callbacks registered with BeforeCommit.
+	// bodyComplete tells them whether buf holds the whole body.
+	beforeCommit []func()
+	bodyComplete bool
+
+	// Connection takeover, see hijack.go.
+	hijacker Hijacker
+	hijacked bool
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	var reason string
+// checkState returns an error if the Writer isn't in the expected state.
+func (w *Writer) checkState(want writerState, what string) error {
+	if w.hijacked {
+		return ErrHijacked
+	}
+	if w.state != want {
+		return fmt.Errorf("cannot write %s in writer state %d", what, w.state)
+	}
+	return nil
+}
+
+// StatusText returns the reason phrase for statusCode, or "" if it is one
+// this package doesn't know.
+func StatusText(statusCode StatusCode) string {
 	switch statusCode {
+	case StatusContinue:
+		return "Continue"
+	case StatusSwitchingProtocols:
+		return "Switching Protocols"
 	case StatusOk:
-		reason = "OK"
+		return "OK"
+	case StatusNoContent:
+		return "No Content"
+	case StatusPartialContent:
+		return "Partial Content"
+	case StatusMovedPermanently:
+		return "Moved Permanently"
+	case StatusNotModified:
+		return "Not Modified"
 	case StatusBadRequest:
-		reason = "Bad Request"
+		return "Bad Request"
+	case StatusUnauthorized:
+		return "Unauthorized"
+	case StatusForbidden:
+		return "Forbidden"
+	case StatusNotFound:
+		return "Not Found"
+	case StatusMethodNotAllowed:
+		return "Method Not Allowed"
+	case StatusNotAcceptable:
+		return "Not Acceptable"
+	case StatusPreconditionFailed:
+		return "Precondition Failed"
+	case StatusContentTooLarge:
+		return "Content Too Large"
+	case StatusUnsupportedMediaType:
+		return "Unsupported Media Type"
+	case StatusRangeNotSatisfiable:
+		return "Range Not Satisfiable"
+	case StatusExpectationFailed:
+		return "Expectation Failed"
+	case StatusUpgradeRequired:
+		return "Upgrade Required"
+	case StatusTooManyRequests:
+		return "Too Many Requests"
 	case StatusInternalServerError:
-		reason = "Internal Server Error"
+		return "Internal Server Error"
 	case StatusRequestTimeout:
-		reason = "Request Timeout"
+		return "Request Timeout"
 	default:
-		reason = ""
+		return ""
+	}
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	if err := w.checkState(writerStateStatusLine, "status line"); err != nil {
+		return err
 	}
+	reason := StatusText(statusCode)
 
 	var n int
 	var err error
@@ -48,9 +187,31 @@ func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
 	if n <= 0 {
 		return fmt.Errorf("no bytes written for status line")
 	}
+	w.status = statusCode
+	w.state = writerStateHeaders
 	return nil
 }
 
+// WriteInterim sends an informational (1xx) response, such as 100 Continue
+// or 103 Early Hints, ahead of the final one. It may be called any number of
+// times before the final status line goes out, whichever API writes it.
+// Framing headers in h are dropped, as they are for any 1xx response.
+func (w *Writer) WriteInterim(statusCode StatusCode, h headers.Headers) error {
+	if err := w.checkState(writerStateStatusLine, "interim response"); err != nil {
+		return err
+	}
+	if statusCode < 100 || statusCode > 199 || statusCode == StatusSwitchingProtocols {
+		return fmt.Errorf("%w: %d", ErrNotInterim, statusCode)
+	}
+	if _, err := fmt.Fprintf(w.dest, "HTTP/1.1 %d %s\r\n", statusCode, StatusText(statusCode)); err != nil {
+		return err
+	}
+	h = copyHeaders(h)
+	h.Del("Content-Length")
+	h.Del("Transfer-Encoding")
+	return w.writeFields(h)
+}
+
 func GetDefaultHeaders(contentLen int) headers.Headers {
 	h := headers.NewHeaders()
 	h["content-length"] = strconv.Itoa(contentLen)
@@ -60,13 +221,85 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 }
 
 func (w *Writer) WriteHeaders(h headers.Headers) error {
-	for key, value := range h {
-		n, err := fmt.Fprintf(w.dest, "%s: %s\r\n", key, value)
+	if err := w.checkState(writerStateHeaders, "headers"); err != nil {
+		return err
+	}
+	// A message framed by both is ambiguous (RFC 9112 section 6.3), so refuse
+	// to send it rather than let the client guess.
+	if h.Get("Content-Length") != "" && h.Get("Transfer-Encoding") != "" {
+		return ErrConflictingFraming
+	}
+	// 1xx and 204 responses must not carry framing headers at all.
+	if w.status < 200 || w.status == StatusNoContent {
+		if h.Get("Content-Length") != "" || h.Get("Transfer-Encoding") != "" {
+			h = copyHeaders(h)
+			h.Del("Content-Length")
+			h.Del("Transfer-Encoding")
+		}
+	}
+	if err := w.recordDeclaredTrailers(h); err != nil {
+		return err
+	}
+	if !w.managed && w.encodeFunc != nil && strings.EqualFold(h.Get("Transfer-Encoding"), "chunked") {
+		h = copyHeaders(h)
+		w.startEncoder(h, -1, chunkSink{w})
+	}
+	if err := w.writeFields(h); err != nil {
+		return err
+	}
+	w.state = writerStateBody
+	return nil
+}
+
+func (w *Writer) WriteBody(p []byte) (int, error) {
+	if err := w.checkState(writerStateBody, "body"); err != nil {
+		return 0, err
+	}
+	if skip, err := w.skipBody(p); skip {
 		if err != nil {
-			return err
+			return 0, err
 		}
-		if n <= 0 {
-			return fmt.Errorf("no bytes written for header %q", key)
+		return len(p), nil
+	}
+	return w.dest.Write(p)
+}
+
+// SetRequestMethod tells the Writer which method it is answering so that
+// HEAD responses can be sent without a body.
+func (w *Writer) SetRequestMethod(method string) {
+	w.method = method
+}
+
+// RequestMethod returns the method set with SetRequestMethod.
+func (w *Writer) RequestMethod() string {
+	return w.method
+}
+
+// skipBody reports whether body bytes must be kept off the wire: they are
+// silently dropped for HEAD and rejected for statuses that can't have a body.
+func (w *Writer) skipBody(p []byte) (bool, error) {
+	if !bodyAllowed(w.status) {
+		if len(p) > 0 {
+			return true, ErrBodyNotAllowed
+		}
+		return true, nil
+	}
+	return w.method == "HEAD", nil
+}
+
+// writeFields writes each field line of h followed by the blank line that
+// ends a header or trailer section. Values holding several lines, as Add
+// stores repeated Set-Cookie fields, go out as one field line per value.
+func (w *Writer) writeFields(h headers.Headers) error {
+	for key, value := range h {
+		for _, line := range strings.Split(value, "\n") {
+			n, err := fmt.Fprintf(w.dest, "%s: %s\r\n", key, strings.TrimRight(line, "\r"))
+			if err != nil {
+				return err
+			}
+			if n <= 0 {
+				return fmt.Errorf("no bytes written for header %q", key)
+			}
 		}
 	}
 	// Write final CRLF to end headers section
@@ -80,15 +313,38 @@ func (w *Writer) WriteHeaders(h headers.Headers) error {
 	return nil
 }
 
-func (w *Writer) WriteBody(p []byte) (int, error) {
-	return w.dest.Write(p)
+// copyHeaders returns a shallow copy of h so callers' maps aren't modified.
+func copyHeaders(h headers.Headers) headers.Headers {
+	c := headers.NewHeaders()
+	for k, v := range h {
+		c[k] = v
+	}
+	return c
 }
 
 func NewWriter(dest io.Writer) *Writer {
-	return &Writer{dest: dest}
+	return &Writer{dest: dest, status: StatusOk, bufferSize: DefaultBufferSize}
 }
 
 func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
+	if err := w.checkState(writerStateBody, "chunked body"); err != nil {
+		return 0, err
+	}
+	// Handlers writing chunks themselves still get their body encoded.
+	if w.encoder != nil && !w.managed {
+		return w.encoder.Write(p)
+	}
+	return w.writeChunk(p)
+}
+
+// writeChunk frames p as a single chunk and writes it to dest.
+func (w *Writer) writeChunk(p []byte) (int, error) {
+	if skip, err := w.skipBody(p); skip {
+		if err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
 	// Write chunk-size in hex followed by CRLF
 	size := len(p)
 	n, err := fmt.Fprintf(w.dest, "%x\r\n", size)
@@ -123,6 +379,16 @@ func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
 }
 
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
+	if err := w.checkState(writerStateBody, "final chunk"); err != nil {
+		return 0, err
+	}
+	if err := w.closeEncoder(); err != nil {
+		return 0, err
+	}
+	if skip, _ := w.skipBody(nil); skip {
+		w.state = writerStateTrailers
+		return 0, nil
+	}
 	// Final zero-length chunk indicates end of chunked body
 	n, err := fmt.Fprintf(w.dest, "0\r\n")
 	if err != nil {
@@ -131,26 +397,26 @@ func (w *Writer) WriteChunkedBodyDone() (int, error) {
 	if n <= 0 {
 		return 0, fmt.Errorf("no bytes written for final chunk marker")
 	}
+	w.state = writerStateTrailers
 	return 0, nil
 }
 
 func (w *Writer) WriteTrailers(h headers.Headers) error {
-	for key, value := range h {
-		n, err := fmt.Fprintf(w.dest, "%s: %s\r\n", key, value)
-		if err != nil {
-			return err
-		}
-		if n <= 0 {
-			return fmt.Errorf("no bytes written for header %q", key)
+	if err := w.checkState(writerStateTrailers, "trailers"); err != nil {
+		return err
+	}
+	for key := range h {
+		if !w.declaredTrailers[strings.ToLower(key)] {
+			return fmt.Errorf("%w: %q", ErrUndeclaredTrailer, key)
 		}
 	}
-	// Write final CRLF to end headers section
-	n, err := fmt.Fprintf(w.dest, "\r\n")
-	if err != nil {
-		return err
+	if skip, _ := w.skipBody(nil); skip {
+		w.state = writerStateDone
+		return nil
 	}
-	if n <= 0 {
-		return fmt.Errorf("no bytes written for final CRLF after headers")
+	if err := w.writeFields(h); err != nil {
+		return err
 	}
+	w.state = writerStateDone
 	return nil
 }
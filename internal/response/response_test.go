@@ -0,0 +1,265 @@
+package response
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/cookie"
+)
+
+func TestWriterFraming(t *testing.T) {
+	// Test: Small body is buffered and sent with Content-Length
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, 0, buf.Len())
+	require.NoError(t, w.Finish())
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.NotContains(t, out, "transfer-encoding")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nhello"))
+
+	// Test: Writing past the buffer size switches to chunked
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetBufferSize(4)
+	_, err = w.Write([]byte("abc"))
+	require.NoError(t, err)
+	_, err = w.Write([]byte("defg"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, out, "content-length")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n3\r\nabc\r\n4\r\ndefg\r\n0\r\n\r\n"))
+
+	// Test: Flush commits to chunked even for a small body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.Header().Set("Content-Type", "text/html")
+	w.SetStatus(StatusBadRequest)
+	_, err = w.Write([]byte("hi"))
+	require.NoError(t, err)
+	require.NoError(t, w.Flush())
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 400 Bad Request\r\n"))
+	assert.Contains(t, out, "content-type: text/html\r\n")
+	assert.True(t, strings.HasSuffix(out, "2\r\nhi\r\n"))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+
+	// Test: Handler-declared Content-Length is streamed without chunking
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetBufferSize(2)
+	w.Header().Set("Content-Length", "6")
+	w.Header().Set("Transfer-Encoding", "chunked")
+	_, err = w.Write([]byte("abcdef"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.Contains(t, out, "content-length: 6\r\n")
+	assert.NotContains(t, out, "transfer-encoding")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\nabcdef"))
+
+	// Test: Writing more than the declared Content-Length fails
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetBufferSize(0)
+	w.Header().Set("Content-Length", "2")
+	_, err = w.Write([]byte("abc"))
+	assert.ErrorIs(t, err, ErrBodyTooLong)
+
+	// Test: Buffered API can't be mixed with low-level writes
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	_, err = w.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrMixedWrites)
+
+	// Test: Low-level WriteHeaders rejects conflicting framing headers
+	h := GetDefaultHeaders(3)
+	h["transfer-encoding"] = "chunked"
+	assert.ErrorIs(t, w.WriteHeaders(h), ErrConflictingFraming)
+}
+
+func TestWriterTrailers(t *testing.T) {
+	// Test: Declared trailers force chunked encoding and are sent by Finish
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.Header().Set("Content-Length", "5")
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	require.NoError(t, w.EnableContentDigest())
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	out := buf.String()
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, out, "trailer: X-Checksum, Content-Digest\r\n")
+	assert.NotContains(t, out, "content-length")
+	assert.Contains(t, out, "5\r\nhello\r\n0\r\n")
+	assert.Contains(t, out, "x-checksum: abc\r\n")
+	assert.Contains(t, out, "content-digest: sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: Setting an undeclared trailer fails
+	w = NewWriter(&buf)
+	assert.ErrorIs(t, w.SetTrailer("X-Other", "1"), ErrUndeclaredTrailer)
+
+	// Test: Framing fields can't be declared as trailers
+	assert.Error(t, w.DeclareTrailer("Content-Length"))
+
+	// Test: A declared trailer that is never set is reported by Finish,
+	// which leaves the body unterminated
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	assert.ErrorIs(t, w.Finish(), ErrMissingTrailer)
+	assert.NotContains(t, buf.String(), "0\r\n")
+
+	// Test: Low-level WriteTrailers is checked against the Trailer header
+	buf.Reset()
+	w = NewWriter(&buf)
+	h := GetDefaultHeaders(0)
+	delete(h, "content-length")
+	h["transfer-encoding"] = "chunked"
+	h["trailer"] = "X-Content-SHA256"
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	require.NoError(t, w.WriteHeaders(h))
+	_, err = w.WriteChunkedBodyDone()
+	require.NoError(t, err)
+	assert.ErrorIs(t, w.WriteTrailers(map[string]string{"X-Content-Length": "0"}), ErrUndeclaredTrailer)
+
+	// Test: A Trailer header naming a forbidden field is refused
+	buf.Reset()
+	w = NewWriter(&buf)
+	h["trailer"] = "X-Content-SHA256, Content-Length"
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	assert.Error(t, w.WriteHeaders(h))
+	assert.NotContains(t, buf.String(), "trailer:")
+}
+
+func TestWriterBodyless(t *testing.T) {
+	// Test: HEAD keeps Content-Length but drops the body
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	_, err := w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	out := buf.String()
+	assert.Contains(t, out, "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.NotContains(t, out, "hello")
+
+	// Test: HEAD on a streamed response sends no chunks or trailers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	require.NoError(t, w.DeclareTrailer("X-Checksum"))
+	_, err = w.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, w.SetTrailer("X-Checksum", "abc"))
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+	assert.NotContains(t, out, "hello")
+	assert.NotContains(t, out, "x-checksum")
+
+	// Test: ReadFrom on a HEAD with a declared length never reads the body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequestMethod("HEAD")
+	w.Header().Set("Content-Length", "1000000")
+	n, err := w.ReadFrom(failingReader{t})
+	require.NoError(t, err)
+	assert.Zero(t, n)
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.Contains(t, out, "content-length: 1000000\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: 204 rejects a body and sends no framing headers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetStatus(StatusNoContent)
+	_, err = w.Write([]byte("x"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 204 No Content\r\n"))
+	assert.NotContains(t, out, "content-length")
+	assert.NotContains(t, out, "content-type")
+
+	// Test: 304 keeps a handler-set Content-Length but refuses a body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetStatus(StatusNotModified)
+	w.Header().Set("Content-Length", "42")
+	require.NoError(t, w.Finish())
+	out = buf.String()
+	assert.Contains(t, out, "content-length: 42\r\n")
+	assert.True(t, strings.HasSuffix(out, "\r\n\r\n"))
+
+	// Test: Low-level writes are checked too
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteStatusLine(StatusNoContent))
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	assert.NotContains(t, buf.String(), "content-length")
+	_, err = w.WriteBody([]byte("abc"))
+	assert.ErrorIs(t, err, ErrBodyNotAllowed)
+}
+
+// failingReader fails the test if it is read.
+type failingReader struct{ t *testing.T }
+
+func (r failingReader) Read(p []byte) (int, error) {
+	r.t.Error("body was read")
+	return 0, io.EOF
+}
+
+func TestWriterSetCookie(t *testing.T) {
+	// Test: Each cookie gets its own Set-Cookie line
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "a", Value: "1", Path: "/", HttpOnly: true}))
+	require.NoError(t, w.SetCookie(&cookie.Cookie{Name: "b", Value: "2"}))
+	require.NoError(t, w.Finish())
+	out := buf.String()
+	assert.Contains(t, out, "set-cookie: a=1; Path=/; HttpOnly\r\n")
+	assert.Contains(t, out, "set-cookie: b=2\r\n")
+
+	// Test: Invalid cookies are refused
+	w = NewWriter(&buf)
+	err := w.SetCookie(&cookie.Cookie{Name: "c", Value: "x", SameSite: cookie.SameSiteNone})
+	assert.ErrorIs(t, err, cookie.ErrInvalidCookie)
+	assert.Empty(t, w.Header().Get("Set-Cookie"))
+}
+
+func TestWriterInterim(t *testing.T) {
+	// Test: Interim responses precede the final one
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	require.NoError(t, w.WriteInterim(StatusContinue, nil))
+	_, err := w.Write([]byte("ok"))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 100 Continue\r\n\r\nHTTP/1.1 200 OK\r\n"))
+
+	// Test: Only 1xx other than 101 is interim, and only before the final response
+	w = NewWriter(&buf)
+	assert.ErrorIs(t, w.WriteInterim(StatusOk, nil), ErrNotInterim)
+	assert.ErrorIs(t, w.WriteInterim(StatusSwitchingProtocols, nil), ErrNotInterim)
+	require.NoError(t, w.WriteStatusLine(StatusOk))
+	assert.Error(t, w.WriteInterim(StatusContinue, nil))
+}
@@ -0,0 +1,143 @@
+package response
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+)
+
+// ErrUndeclaredTrailer is returned when a trailer is set or written that was
+// not announced in the Trailer header.
+var ErrUndeclaredTrailer = errors.New("trailer was not declared")
+
+// ErrMissingTrailer is returned by Finish when a declared trailer never
+// received a value.
+var ErrMissingTrailer = errors.New("declared trailer was not set")
+
+// forbiddenTrailers lists fields a sender must not put in a trailer section
+// because recipients need them before the body (RFC 9110 section 6.5.1).
+var forbiddenTrailers = map[string]bool{
+	"authorization":     true,
+	"cache-control":     true,
+	"content-encoding":  true,
+	"content-length":    true,
+	"content-range":     true,
+	"content-type":      true,
+	"host":              true,
+	"set-cookie":        true,
+	"te":                true,
+	"trailer":           true,
+	"transfer-encoding": true,
+}
+
+// DeclareTrailer announces trailer fields the handler will set with
+// SetTrailer while the body streams. It must be called before the response
+// commits, and because trailers only exist in chunked messages it forces
+// chunked encoding.
+func (w *Writer) DeclareTrailer(names ...string) error {
+	if w.managed || w.state != writerStateStatusLine {
+		return fmt.Errorf("cannot declare trailers after the response has started")
+	}
+	for _, name := range names {
+		key := strings.ToLower(name)
+		if forbiddenTrailers[key] {
+			return fmt.Errorf("%q is not allowed in trailers", name)
+		}
+		if _, ok := w.declaredTrailers[key]; ok {
+			continue
+		}
+		if w.declaredTrailers == nil {
+			w.declaredTrailers = map[string]bool{}
+		}
+		w.declaredTrailers[key] = true
+		w.trailerNames = append(w.trailerNames, name)
+	}
+	return nil
+}
+
+// SetTrailer records the value of a declared trailer. Values can be set at
+// any point before Finish and are written after the last chunk.
+func (w *Writer) SetTrailer(name, value string) error {
+	if !w.declaredTrailers[strings.ToLower(name)] {
+		return fmt.Errorf("%w: %q", ErrUndeclaredTrailer, name)
+	}
+	if w.trailers == nil {
+		w.trailers = headers.NewHeaders()
+	}
+	w.trailers.Set(name, value)
+	return nil
+}
+
+// EnableContentDigest declares a Content-Digest trailer holding the SHA-256
+// of the body (RFC 9530) and computes it as the body is written.
+func (w *Writer) EnableContentDigest() error {
+	if err := w.DeclareTrailer("Content-Digest"); err != nil {
+		return err
+	}
+	w.digest = sha256.New()
+	return nil
+}
+
+// contentDigest formats the running body hash as a Content-Digest value.
+func contentDigest(h hash.Hash) string {
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(h.Sum(nil)) + ":"
+}
+
+// finishTrailers writes the trailer section of a chunked response. A
+// declared trailer that was never set is reported before anything is
+// written, so the response is left unterminated rather than completed
+// without it.
+func (w *Writer) finishTrailers() error {
+	if w.digest != nil {
+		if err := w.SetTrailer("Content-Digest", contentDigest(w.digest)); err != nil {
+			return err
+		}
+	}
+	t := w.trailers
+	if t == nil {
+		t = headers.NewHeaders()
+	}
+	for _, name := range w.trailerNames {
+		if t.Get(name) == "" {
+			return fmt.Errorf("%w: %q", ErrMissingTrailer, name)
+		}
+	}
+	if _, err := w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+	return w.WriteTrailers(t)
+}
+
+// recordDeclaredTrailers remembers the fields announced by a Trailer header
+// so WriteTrailers can check the trailer section against it. Like
+// DeclareTrailer it refuses fields that can't be sent as trailers.
+func (w *Writer) recordDeclaredTrailers(h headers.Headers) error {
+	v := h.Get("Trailer")
+	if v == "" {
+		return nil
+	}
+	declared := map[string]bool{}
+	for _, name := range strings.Split(v, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		key := strings.ToLower(name)
+		if forbiddenTrailers[key] {
+			return fmt.Errorf("%q is not allowed in trailers", name)
+		}
+		declared[key] = true
+	}
+	if w.declaredTrailers == nil {
+		w.declaredTrailers = map[string]bool{}
+	}
+	for key := range declared {
+		w.declaredTrailers[key] = true
+	}
+	return nil
+}
@@ -1,38 +1,93 @@
 package server
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"httpfromtcp/internal/proxyproto"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 )
 
+// DefaultMaxDecompressedSize caps decoded request bodies when
+// Config.MaxDecompressedSize is zero.
+const DefaultMaxDecompressedSize = 10 << 20
+
 // Contains the state of the server
 type Server struct {
 	listener net.Listener
 	closed   atomic.Bool
 	wg       sync.WaitGroup
 	handler  Handler
+	config   Config
+	// connIDs numbers accepted connections for request.ConnInfo.
+	connIDs atomic.Uint64
+	// ctx is the parent of every request context; Close cancels it.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Config holds optional server settings. The zero value gives the behaviour
+// of Serve.
+type Config struct {
+	// DecompressRequests decodes gzip and deflate request bodies before the
+	// handler sees them. Other encodings are answered with 415. Bodies held
+	// back by "Expect: 100-continue" are decoded when the handler reads
+	// them, and ReadBody reports request.ErrBodyTooLarge instead of a 413.
+	DecompressRequests bool
+	// MaxDecompressedSize limits a decoded request body; larger ones are
+	// answered with 413. Zero means DefaultMaxDecompressedSize.
+	MaxDecompressedSize int64
+	// RequestTimeout, if positive, is how long a request's context lives
+	// once its head has been read. Handlers must watch the context; the
+	// server doesn't cut them off.
+	RequestTimeout time.Duration
+	// ProxyProtocol, if set, accepts PROXY protocol headers from the
+	// trusted peers it lists, so requests report the client's address
+	// rather than the load balancer's.
+	ProxyProtocol *proxyproto.Options
 }
 
 // Creates a net.Listener and returns a new Server instance. Starts listening for requests inside a goroutine.
 func Serve(port int, handler Handler) (*Server, error) {
+	return ServeWithConfig(port, handler, Config{})
+}
+
+// ServeWithConfig is like Serve but applies the settings in cfg.
+func ServeWithConfig(port int, handler Handler, cfg Config) (*Server, error) {
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
 	if err != nil {
 		return nil, err
 	}
+	if cfg.ProxyProtocol != nil {
+		ln = proxyproto.NewListener(ln, *cfg.ProxyProtocol)
+	}
 
-	s := &Server{listener: ln, handler: handler}
+	if cfg.MaxDecompressedSize == 0 {
+		cfg.MaxDecompressedSize = DefaultMaxDecompressedSize
+	}
+	s := &Server{listener: ln, handler: handler, config: cfg}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
 	s.closed.Store(false)
 	go s.listen()
 	return s, nil
 }
 
+// Addr returns the address the server listens on, useful when it was started
+// on port 0.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
 // Closes the listener and the server
 func (s *Server) Close() error {
 	// Mark as closed so listen loop can exit cleanly on Accept errors
@@ -42,7 +97,8 @@ func (s *Server) Close() error {
 	if s.listener != nil {
 		_ = s.listener.Close()
 	}
-	// Wait for any active handlers to finish
+	// Tell running handlers to give up, then wait for them to finish
+	s.cancel()
 	s.wg.Wait()
 	return nil
 }
@@ -62,48 +118,181 @@ func (s *Server) listen() {
 		}
 		// Handle connection in its own goroutine and track with waitgroup
 		s.wg.Add(1)
-		go s.handle(conn)
+		go s.handle(conn, s.connIDs.Add(1))
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
+func (s *Server) handle(conn net.Conn, connID uint64) {
 	defer s.wg.Done()
-	defer conn.Close()
 	log.Println("handle: new connection")
 	w := response.NewWriter(conn)
+	// A hijacked connection belongs to the handler that took it.
+	defer func() {
+		if !w.Hijacked() {
+			conn.Close()
+		}
+	}()
 
 	// Add a read deadline so a client that stops sending can't hang the server
 	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	// Parse the request from the connection
-	req, err := request.RequestFromReader(conn)
+	// Parse the request line and headers; the body is read below or, for
+	// clients waiting on 100 Continue, when the handler asks for it.
+	body := &idleReader{conn: conn, timeout: 5 * time.Second}
+	req, err := request.HeadFromReader(body)
 	log.Printf("handle: RequestFromReader returned, err=%v\n", err)
 	if err != nil {
-		w.WriteStatusLine(response.StatusBadRequest)
-		body := []byte(err.Error())
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+		writeError(w, response.StatusBadRequest, err.Error())
 		return
 	}
-	// Clear the read deadline now that we've successfully read the request
-	_ = conn.SetReadDeadline(time.Time{})
-	log.Printf("handle: parsed request line: method=%s target=%s version=%s\n",
+	// Connections carry one request each, so every request is the first.
+	req.Conn = request.ConnInfo{
+		RemoteAddr: conn.RemoteAddr(),
+		LocalAddr:  conn.LocalAddr(),
+		ID:         connID,
+		Seq:        1,
+		ReceivedAt: time.Now(),
+	}
+	if tc, ok := conn.(*tls.Conn); ok {
+		state := tc.ConnectionState()
+		req.Conn.TLS = &state
+	}
+	req.Client = req.ClientFromConn()
+
+	log.Printf("handle: parsed request line: conn=%d remote=%s method=%s target=%s version=%s\n",
+		connID,
+		req.Conn.RemoteAddr,
 		req.RequestLine.Method,
 		req.RequestLine.RequestTarget,
 		req.RequestLine.HttpVersion,
 	)
 
+	// The context ends with the server, the deadline or the connection,
+	// whichever goes first; watcher notices the connection going away.
+	ctx, cancel := context.WithCancel(s.ctx)
+	defer cancel()
+	if s.config.RequestTimeout > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, s.config.RequestTimeout)
+		defer cancelTimeout()
+	}
+	req.SetContext(ctx)
+	watcher := newConnWatcher(conn, cancel)
+
+	// 100-continue is the only expectation defined (RFC 9110 section 10.1.1).
+	if req.Headers.Get("Expect") != "" && !req.ExpectsContinue() {
+		writeError(w, response.StatusExpectationFailed, "unsupported expectation")
+		return
+	}
+	if s.config.DecompressRequests {
+		if err := req.CheckContentEncoding(); err != nil {
+			w.Header().Set("Accept-Encoding", "gzip, deflate")
+			writeError(w, response.StatusUnsupportedMediaType, err.Error())
+			return
+		}
+	}
+
+	if s.config.DecompressRequests {
+		req.DecompressOnRead(s.config.MaxDecompressedSize)
+	}
+	if req.ContentLength() > 0 {
+		// The body is left on the connection until the handler reads it,
+		// whole with ReadBody or streamed with BodyReader, so a large upload
+		// never has to sit in memory first. A client waiting for 100
+		// Continue gets it then; a handler that answers from the headers
+		// alone, with 417 or anything else, never asks. While the body
+		// streams, the read deadline is an idle timeout.
+		req.BeforeBodyRead(func() error {
+			if req.ExpectsContinue() {
+				if err := w.WriteInterim(response.StatusContinue, nil); err != nil {
+					return err
+				}
+			}
+			body.idle = true
+			return nil
+		})
+		req.AfterBodyRead(func() error {
+			body.idle = false
+			if err := conn.SetReadDeadline(time.Time{}); err != nil {
+				return err
+			}
+			watcher.start()
+			return nil
+		})
+	} else if _, err := req.ReadBody(); err != nil {
+		// No body, or a Content-Length the parser rejects.
+		writeError(w, response.StatusBadRequest, err.Error())
+		return
+	}
+	// Clear the read deadline now that we've successfully read the request
+	if !req.BodyPending() {
+		_ = conn.SetReadDeadline(time.Time{})
+		watcher.start()
+	}
+
+	w.SetHijacker(func() (net.Conn, *bufio.Reader, error) {
+		early := append(req.Buffered(), watcher.stop()...)
+		_ = conn.SetDeadline(time.Time{})
+		return conn, bufio.NewReader(io.MultiReader(bytes.NewReader(early), conn)), nil
+	})
+
+	// HEAD is answered by the GET handler; the writer keeps the headers,
+	// Content-Length included, and drops the body bytes.
+	w.SetRequestMethod(req.RequestLine.Method)
+	if req.RequestLine.Method == "HEAD" {
+		req.RequestLine.Method = "GET"
+	}
+
 	log.Println("handle: calling handler")
 
 	// Call the handler
 	if s.handler == nil {
-		w.WriteStatusLine(response.StatusInternalServerError)
-		body := []byte("no handler")
-		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		w.WriteBody(body)
+		writeError(w, response.StatusInternalServerError, "no handler")
 		return
 	}
 
 	s.handler(w, req)
+
+	// Send whatever the handler left buffered and close out streamed bodies.
+	if err := w.Finish(); err != nil {
+		log.Printf("handle: finishing response: %v\n", err)
+	}
+}
+
+// idleReader is what the request reads the connection through. Once idle
+// is set, for the body, each read pushes the deadline back, so an upload
+// may take as long as it needs while it keeps arriving.
+type idleReader struct {
+	conn    net.Conn
+	timeout time.Duration
+	idle    bool
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	if r.idle {
+		if err := r.conn.SetReadDeadline(time.Now().Add(r.timeout)); err != nil {
+			return 0, err
+		}
+	}
+	return r.conn.Read(p)
+}
+
+// writeError sends a short plain-text error response.
+func writeError(w *response.Writer, status response.StatusCode, msg string) {
+	w.SetStatus(status)
+	_, _ = w.Write([]byte(msg))
+	_ = w.Finish()
 }
 
 type Handler func(w *response.Writer, req *request.Request)
+
+// Middleware wraps a Handler to add behaviour before or after it runs.
+type Middleware func(Handler) Handler
+
+// Chain wraps h with the given middleware. The first one listed is the
+// outermost, so it sees the request first.
+func Chain(h Handler, mws ...Middleware) Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
@@ -2,11 +2,14 @@ package server
 
 import (
 	"bufio"
+	"context"
 	"fmt"
+	"httpfromtcp/internal/proxyproto"
 	"httpfromtcp/internal/request"
 	"httpfromtcp/internal/response"
 	"io"
 	"net"
+	"net/netip"
 	"strconv"
 	"strings"
 	"testing"
@@ -14,17 +17,24 @@ import (
 )
 
 func doRequest(t *testing.T, addr string, path string) (int, string, error) {
+	code, _, body, err := doMethodRequest(t, addr, "GET", path)
+	return code, body, err
+}
+
+// doMethodRequest sends a bodyless request and returns the status code, the
+// Content-Length header and everything the server sent after the headers.
+func doMethodRequest(t *testing.T, addr string, method string, path string) (int, int, string, error) {
 	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
 	if err != nil {
-		return 0, "", err
+		return 0, 0, "", err
 	}
 	defer conn.Close()
 
 	// send request (use Write and CloseWrite to ensure server receives bytes promptly)
-	req := fmt.Sprintf("GET %s HTTP/1.1\r\nHost: localhost\r\n\r\n", path)
+	req := fmt.Sprintf("%s %s HTTP/1.1\r\nHost: localhost\r\n\r\n", method, path)
 	_, err = conn.Write([]byte(req))
 	if err != nil {
-		return 0, "", err
+		return 0, 0, "", err
 	}
 	if tcpConn, ok := conn.(*net.TCPConn); ok {
 		_ = tcpConn.CloseWrite()
@@ -34,17 +44,17 @@ func doRequest(t *testing.T, addr string, path string) (int, string, error) {
 	r := bufio.NewReader(conn)
 	statusLine, err := r.ReadString('\n')
 	if err != nil {
-		return 0, "", err
+		return 0, 0, "", err
 	}
 	statusLine = strings.TrimRight(statusLine, "\r\n")
 	// parse status code from "HTTP/1.1 XXX Reason"
 	parts := strings.SplitN(statusLine, " ", 3)
 	if len(parts) < 2 {
-		return 0, "", fmt.Errorf("malformed status line: %q", statusLine)
+		return 0, 0, "", fmt.Errorf("malformed status line: %q", statusLine)
 	}
 	code, err := strconv.Atoi(parts[1])
 	if err != nil {
-		return 0, "", err
+		return 0, 0, "", err
 	}
 
 	// read headers
@@ -52,7 +62,7 @@ func doRequest(t *testing.T, addr string, path string) (int, string, error) {
 	for {
 		line, err := r.ReadString('\n')
 		if err != nil {
-			return code, "", err
+			return code, 0, "", err
 		}
 		if line == "\r\n" {
 			break
@@ -67,16 +77,12 @@ func doRequest(t *testing.T, addr string, path string) (int, string, error) {
 		}
 	}
 
-	var body string
-	if contentLen > 0 {
-		buf := make([]byte, contentLen)
-		_, err := io.ReadFull(r, buf)
-		if err != nil {
-			return code, "", err
-		}
-		body = string(buf)
+	// read to EOF so callers can check nothing follows a bodyless response
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return code, contentLen, "", err
 	}
-	return code, body, nil
+	return code, contentLen, string(rest), nil
 }
 
 func TestServerIntegration(t *testing.T) {
@@ -139,4 +145,288 @@ func TestServerIntegration(t *testing.T) {
 			t.Fatalf("%s: got body %q want %q", tt.path, body, tt.wantBody)
 		}
 	}
+
+	// Test: HEAD runs the GET handler but sends no body
+	code, contentLen, body, err := doMethodRequest(t, addr, "HEAD", "/")
+	if err != nil {
+		t.Fatalf("HEAD request failed: %v", err)
+	}
+	if code != 200 || contentLen != len("All good, frfr\n") || body != "" {
+		t.Fatalf("HEAD: got code %d content-length %d body %q", code, contentLen, body)
+	}
+}
+
+func TestServerExpectContinue(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/reject" {
+			w.SetStatus(response.StatusContentTooLarge)
+			_, _ = w.Write([]byte("too big\n"))
+			return
+		}
+		body, err := req.ReadBody()
+		if err != nil {
+			w.SetStatus(response.StatusBadRequest)
+			return
+		}
+		_, _ = w.Write(body)
+	}
+	s, err := Serve(0, handler)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer s.Close()
+	addr := fmt.Sprintf("127.0.0.1:%d", s.listener.Addr().(*net.TCPAddr).Port)
+
+	// send writes the request head and returns the connection and a reader
+	// over its responses.
+	send := func(path, expect string) (net.Conn, *bufio.Reader) {
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+		head := fmt.Sprintf("POST %s HTTP/1.1\r\nHost: localhost\r\nContent-Length: 5\r\nExpect: %s\r\n\r\n", path, expect)
+		if _, err := conn.Write([]byte(head)); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return conn, bufio.NewReader(conn)
+	}
+
+	// Test: 100 Continue is sent once the handler reads the body
+	conn, r := send("/echo", "100-continue")
+	defer conn.Close()
+	line, err := r.ReadString('\n')
+	if err != nil || line != "HTTP/1.1 100 Continue\r\n" {
+		t.Fatalf("want 100 Continue, got %q (%v)", line, err)
+	}
+	if blank, _ := r.ReadString('\n'); blank != "\r\n" {
+		t.Fatalf("interim response not terminated: %q", blank)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("write body: %v", err)
+	}
+	rest, _ := io.ReadAll(r)
+	if !strings.HasPrefix(string(rest), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(string(rest), "\r\n\r\nhello") {
+		t.Fatalf("unexpected final response: %q", rest)
+	}
+
+	// Test: A handler answering from the headers alone skips 100 Continue
+	conn, r = send("/reject", "100-continue")
+	defer conn.Close()
+	line, _ = r.ReadString('\n')
+	if line != "HTTP/1.1 413 Content Too Large\r\n" {
+		t.Fatalf("want 413, got %q", line)
+	}
+
+	// Test: Unknown expectations get 417
+	conn, r = send("/echo", "something-else")
+	defer conn.Close()
+	line, _ = r.ReadString('\n')
+	if line != "HTTP/1.1 417 Expectation Failed\r\n" {
+		t.Fatalf("want 417, got %q", line)
+	}
+}
+
+func TestServerStreamsBody(t *testing.T) {
+	firstPart := make(chan string, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		mr, err := req.MultipartReader()
+		if err != nil {
+			w.SetStatus(response.StatusBadRequest)
+			return
+		}
+		var names []string
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				w.SetStatus(response.StatusBadRequest)
+				return
+			}
+			if len(names) == 0 {
+				firstPart <- part.FormName()
+			}
+			names = append(names, part.FormName())
+			_, _ = io.Copy(io.Discard, part)
+		}
+		_, _ = w.Write([]byte(strings.Join(names, ",")))
+	}
+	s, err := Serve(0, handler)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer s.Close()
+
+	conn, err := net.DialTimeout("tcp", s.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	first := "--b\r\nContent-Disposition: form-data; name=\"a\"\r\n\r\n1\r\n"
+	rest := "--b\r\nContent-Disposition: form-data; name=\"b\"\r\n\r\n2\r\n--b--\r\n"
+	head := fmt.Sprintf("POST /up HTTP/1.1\r\nHost: localhost\r\nContent-Type: multipart/form-data; boundary=b\r\nContent-Length: %d\r\n\r\n", len(first)+len(rest))
+
+	// Test: The handler sees the first part before the rest is sent
+	if _, err := conn.Write([]byte(head + first)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	select {
+	case name := <-firstPart:
+		if name != "a" {
+			t.Fatalf("want part a first, got %q", name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handler didn't get the first part while the upload was incomplete")
+	}
+	if _, err := conn.Write([]byte(rest)); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	resp, _ := io.ReadAll(conn)
+	if !strings.HasPrefix(string(resp), "HTTP/1.1 200 OK\r\n") || !strings.HasSuffix(string(resp), "\r\n\r\na,b") {
+		t.Fatalf("unexpected response: %q", resp)
+	}
+}
+
+func TestServerRequestContext(t *testing.T) {
+	ended := make(chan error, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		select {
+		case <-req.Context().Done():
+			ended <- req.Context().Err()
+		case <-time.After(2 * time.Second):
+			ended <- nil
+		}
+	}
+	s, err := ServeWithConfig(0, handler, Config{})
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer s.Close()
+	addr := s.Addr().String()
+
+	open := func() net.Conn {
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			t.Fatalf("dial: %v", err)
+		}
+		if _, err := conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: localhost\r\n\r\n")); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		return conn
+	}
+
+	// Test: The context is cancelled when the client hangs up
+	conn := open()
+	time.Sleep(50 * time.Millisecond)
+	conn.Close()
+	if err := <-ended; err != context.Canceled {
+		t.Fatalf("want context.Canceled after disconnect, got %v", err)
+	}
+
+	// Test: Closing the server cancels running requests
+	conn = open()
+	defer conn.Close()
+	time.Sleep(50 * time.Millisecond)
+	go s.Close()
+	if err := <-ended; err != context.Canceled {
+		t.Fatalf("want context.Canceled on shutdown, got %v", err)
+	}
+
+	// Test: RequestTimeout puts a deadline on the context
+	s2, err := ServeWithConfig(0, handler, Config{RequestTimeout: 50 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer s2.Close()
+	addr = s2.Addr().String()
+	conn = open()
+	defer conn.Close()
+	if err := <-ended; err != context.DeadlineExceeded {
+		t.Fatalf("want context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestServerConnInfo(t *testing.T) {
+	infos := make(chan request.ConnInfo, 2)
+	clients := make(chan request.ClientInfo, 2)
+	handler := func(w *response.Writer, req *request.Request) {
+		infos <- req.Conn
+		clients <- req.Client
+		_, _ = w.Write([]byte("ok\n"))
+	}
+	s, err := Serve(0, handler)
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer s.Close()
+	addr := s.Addr().String()
+
+	// Test: Handlers see the connection's endpoints, ID and receive time
+	before := time.Now()
+	if _, _, err := doRequest(t, addr, "/"); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	first := <-infos
+	if first.RemoteAddr == nil || !net.ParseIP(first.RemoteIP()).IsLoopback() {
+		t.Fatalf("unexpected remote address %v", first.RemoteAddr)
+	}
+	if first.LocalAddr == nil || first.LocalAddr.(*net.TCPAddr).Port != s.Addr().(*net.TCPAddr).Port {
+		t.Fatalf("unexpected local address %v", first.LocalAddr)
+	}
+	if first.ID == 0 || first.Seq != 1 || first.TLS != nil {
+		t.Fatalf("unexpected conn info %+v", first)
+	}
+	if first.ReceivedAt.Before(before) || first.ReceivedAt.After(time.Now()) {
+		t.Fatalf("receive time %v out of range", first.ReceivedAt)
+	}
+
+	// Test: The client defaults to the connection's peer
+	client := <-clients
+	if client.IP.String() != first.RemoteIP() || client.Scheme != "http" || client.Host != "localhost" {
+		t.Fatalf("unexpected client info %+v", client)
+	}
+
+	// Test: Each connection gets a new ID
+	if _, _, err := doRequest(t, addr, "/"); err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	if second := <-infos; second.ID <= first.ID {
+		t.Fatalf("connection IDs not increasing: %d then %d", first.ID, second.ID)
+	}
+}
+
+func TestServerProxyProtocol(t *testing.T) {
+	remotes := make(chan string, 1)
+	handler := func(w *response.Writer, req *request.Request) {
+		remotes <- req.Conn.RemoteAddr.String()
+	}
+	s, err := ServeWithConfig(0, handler, Config{
+		ProxyProtocol: &proxyproto.Options{Trusted: []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8"), netip.MustParsePrefix("::1/128")}},
+	})
+	if err != nil {
+		t.Fatalf("Serve failed: %v", err)
+	}
+	defer s.Close()
+
+	// Test: The client address from the PROXY header reaches the handler
+	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", s.Addr().(*net.TCPAddr).Port), 2*time.Second)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("PROXY TCP4 192.0.2.1 127.0.0.1 56324 80\r\nGET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	if err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || line != "HTTP/1.1 200 OK\r\n" {
+		t.Fatalf("want 200, got %q (%v)", line, err)
+	}
+	if remote := <-remotes; remote != "192.0.2.1:56324" {
+		t.Fatalf("want client address 192.0.2.1:56324, got %s", remote)
+	}
 }
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// connWatcher notices a client hanging up while its request is handled. Once
+// the request has been read the client has nothing more to send, so a
+// background read that returns EOF or an error means the connection is
+// gone, and the request's context is cancelled.
+type connWatcher struct {
+	conn   net.Conn
+	cancel context.CancelFunc
+
+	started  bool
+	stopping atomic.Bool
+	done     chan struct{}
+	// buf holds a byte the client sent anyway, such as the start of a
+	// pipelined request, so it isn't lost if the connection is hijacked.
+	buf [1]byte
+	n   int
+}
+
+func newConnWatcher(conn net.Conn, cancel context.CancelFunc) *connWatcher {
+	return &connWatcher{conn: conn, cancel: cancel, done: make(chan struct{})}
+}
+
+// start begins watching. It must be called once the whole request, body
+// included, has been read, and at most once.
+func (cw *connWatcher) start() {
+	cw.started = true
+	go func() {
+		defer close(cw.done)
+		n, err := cw.conn.Read(cw.buf[:])
+		cw.n = n
+		if n == 0 && err != nil && !cw.stopping.Load() {
+			cw.cancel()
+		}
+	}()
+}
+
+// stop ends the background read and returns any byte it consumed. It is
+// needed before another reader, such as a hijacking handler, takes over.
+func (cw *connWatcher) stop() []byte {
+	if !cw.started {
+		return nil
+	}
+	cw.started = false
+	cw.stopping.Store(true)
+	// A deadline in the past wakes the pending Read.
+	_ = cw.conn.SetReadDeadline(time.Unix(1, 0))
+	<-cw.done
+	_ = cw.conn.SetReadDeadline(time.Time{})
+	return cw.buf[:cw.n]
+}
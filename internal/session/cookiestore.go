@@ -0,0 +1,128 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MaxCookieTokenSize bounds the encoded record a CookieStore will send.
+// Browsers cap a whole cookie at about 4096 bytes including its name and
+// attributes.
+const MaxCookieTokenSize = 3800
+
+// ErrCookieTooLarge is returned when a session holds too much data to fit
+// in a cookie.
+var ErrCookieTooLarge = errors.New("session too large for cookie store")
+
+// CookieStore keeps the whole session record in the cookie itself, so no
+// server-side state is needed. Records are signed with HMAC-SHA256 and, when
+// a block key is given, encrypted with AES-GCM so clients can't read them.
+//
+// A client can replay an old cookie until it expires, and Delete can't
+// revoke one already handed out; use a server-side store when that
+// matters.
+type CookieStore struct {
+	hashKey []byte
+	aead    cipher.AEAD
+	now     func() time.Time
+}
+
+// NewCookieStore returns a CookieStore signing with hashKey, which must be
+// at least 32 bytes. blockKey, if not nil, must be 16, 24 or 32 bytes and
+// selects AES-128, -192 or -256 encryption. Both keys should be random and
+// kept secret; changing either invalidates every session.
+func NewCookieStore(hashKey, blockKey []byte) (*CookieStore, error) {
+	if len(hashKey) < 32 {
+		return nil, fmt.Errorf("session: hash key must be at least 32 bytes, got %d", len(hashKey))
+	}
+	s := &CookieStore{hashKey: hashKey, now: time.Now}
+	if blockKey != nil {
+		block, err := aes.NewCipher(blockKey)
+		if err != nil {
+			return nil, fmt.Errorf("session: block key: %w", err)
+		}
+		s.aead, err = cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Load verifies and decodes a token produced by Save. Tokens that were
+// tampered with, can't be decrypted or have expired are ErrNotFound.
+func (s *CookieStore) Load(token string) (*Record, error) {
+	encPayload, encMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrNotFound
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return nil, ErrNotFound
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(encMAC)
+	if err != nil || !hmac.Equal(mac, s.sign(payload)) {
+		return nil, ErrNotFound
+	}
+	if s.aead != nil {
+		n := s.aead.NonceSize()
+		if len(payload) < n {
+			return nil, ErrNotFound
+		}
+		payload, err = s.aead.Open(nil, payload[:n], payload[n:], nil)
+		if err != nil {
+			return nil, ErrNotFound
+		}
+	}
+
+	var rec Record
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, ErrNotFound
+	}
+	if !s.now().Before(rec.Expires) {
+		return nil, ErrNotFound
+	}
+	return &rec, nil
+}
+
+// Save encodes rec into a token.
+func (s *CookieStore) Save(rec *Record) (string, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	if s.aead != nil {
+		nonce := make([]byte, s.aead.NonceSize())
+		if _, err := rand.Read(nonce); err != nil {
+			return "", err
+		}
+		payload = s.aead.Seal(nonce, nonce, payload, nil)
+	}
+	token := base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.sign(payload))
+	if len(token) > MaxCookieTokenSize {
+		return "", fmt.Errorf("%w: %d bytes encoded", ErrCookieTooLarge, len(token))
+	}
+	return token, nil
+}
+
+// Delete does nothing: the record lives in the client's cookie, which the
+// Manager expires.
+func (s *CookieStore) Delete(id string) error {
+	return nil
+}
+
+func (s *CookieStore) sign(payload []byte) []byte {
+	m := hmac.New(sha256.New, s.hashKey)
+	m.Write(payload)
+	return m.Sum(nil)
+}
@@ -0,0 +1,115 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileStore keeps each session as a JSON file in a directory, so sessions
+// survive restarts. Session IDs are only used as file names after checking
+// they have the form the Manager generates.
+type FileStore struct {
+	dir string
+	now func() time.Time
+}
+
+// NewFileStore returns a FileStore writing to dir, creating it if needed
+// with permissions only the current user can read.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir, now: time.Now}, nil
+}
+
+// Load reads the record with the given ID. Expired records are removed.
+func (s *FileStore) Load(token string) (*Record, error) {
+	if !validID(token) {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(s.path(token))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var rec Record
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	if !s.now().Before(rec.Expires) {
+		return nil, errors.Join(ErrNotFound, s.Delete(token))
+	}
+	return &rec, nil
+}
+
+// Save writes rec to a temporary file and renames it into place, so a
+// concurrent Load never sees a partial record.
+func (s *FileStore) Save(rec *Record) (string, error) {
+	if !validID(rec.ID) {
+		return "", errors.New("session: invalid session ID")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(s.dir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), s.path(rec.ID))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return rec.ID, nil
+}
+
+// Delete removes the record with the given ID. A missing record isn't an
+// error.
+func (s *FileStore) Delete(id string) error {
+	if !validID(id) {
+		return nil
+	}
+	err := os.Remove(s.path(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// Cleanup removes the records of expired sessions. Unlike MemoryStore, a
+// FileStore doesn't sweep on its own; call Cleanup periodically.
+func (s *FileStore) Cleanup() error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".json")
+		if name == e.Name() || !validID(name) {
+			continue
+		}
+		// Load deletes expired records as a side effect.
+		if _, err := s.Load(name); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,210 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"httpfromtcp/internal/cookie"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Defaults used when Options fields are zero.
+const (
+	DefaultCookieName      = "session"
+	DefaultIdleTimeout     = 30 * time.Minute
+	DefaultAbsoluteTimeout = 24 * time.Hour
+)
+
+// Options configures a Manager.
+type Options struct {
+	// CookieName names the session cookie. Defaults to DefaultCookieName.
+	CookieName string
+	// Path and Domain scope the cookie. Path defaults to "/".
+	Path   string
+	Domain string
+	// Secure marks the cookie HTTPS-only. Set it whenever the server is
+	// reached over TLS.
+	Secure bool
+	// SameSite defaults to Lax.
+	SameSite cookie.SameSite
+	// IdleTimeout ends a session that goes unused this long.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends a session this long after it started, however
+	// active it is.
+	AbsoluteTimeout time.Duration
+}
+
+// Manager loads sessions for incoming requests and saves them before the
+// response goes out.
+type Manager struct {
+	store Store
+	opts  Options
+	// now is replaced in tests.
+	now func() time.Time
+}
+
+// sessionKey holds a request's session in its context. It names the
+// Manager so requests passing through two managers keep both sessions.
+type sessionKey struct {
+	m *Manager
+}
+
+// NewManager returns a Manager keeping sessions in store.
+func NewManager(store Store, opts Options) *Manager {
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCookieName
+	}
+	if opts.Path == "" {
+		opts.Path = "/"
+	}
+	if opts.SameSite == cookie.SameSiteDefault {
+		opts.SameSite = cookie.SameSiteLax
+	}
+	if opts.IdleTimeout == 0 {
+		opts.IdleTimeout = DefaultIdleTimeout
+	}
+	if opts.AbsoluteTimeout == 0 {
+		opts.AbsoluteTimeout = DefaultAbsoluteTimeout
+	}
+	return &Manager{store: store, opts: opts, now: time.Now}
+}
+
+// Middleware attaches a session to every request, available to handlers
+// through Get. The session is saved just before the response headers are
+// sent, so handlers must use the buffered response API; new sessions are
+// only stored once something is put in them.
+//
+// Concurrent requests in the same session each work on their own copy and
+// the last one to finish wins.
+func (m *Manager) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			sess, err := m.load(req)
+			if err != nil {
+				log.Printf("session: load: %v", err)
+				writeStatus(w, response.StatusInternalServerError)
+				return
+			}
+			// The session is only reachable while the handler runs.
+			ctx := req.Context()
+			req.SetContext(context.WithValue(ctx, sessionKey{m}, sess))
+			defer req.SetContext(ctx)
+
+			w.BeforeCommit(func() {
+				if err := m.save(w, sess); err != nil {
+					log.Printf("session: save: %v", err)
+				}
+			})
+			next(w, req)
+		}
+	}
+}
+
+// Get returns the session for req. It returns nil when req didn't pass
+// through the Manager's middleware.
+func (m *Manager) Get(req *request.Request) *Session {
+	sess, _ := req.Context().Value(sessionKey{m}).(*Session)
+	return sess
+}
+
+// load returns the live session named by the request's cookie, or a new one
+// if there is none or it has expired.
+func (m *Manager) load(req *request.Request) (*Session, error) {
+	now := m.now()
+	if c, err := req.Cookie(m.opts.CookieName); err == nil && c.Value != "" {
+		rec, err := m.store.Load(c.Value)
+		switch {
+		case err == nil && m.live(rec, now):
+			return &Session{rec: *rec}, nil
+		case err == nil:
+			// Expired: forget it and start over.
+			if err := m.store.Delete(rec.ID); err != nil {
+				return nil, err
+			}
+		case !errors.Is(err, ErrNotFound):
+			return nil, err
+		}
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	return &Session{
+		rec:   Record{ID: id, Values: map[string]string{}, Created: now, LastSeen: now},
+		isNew: true,
+	}, nil
+}
+
+// live reports whether rec is within both its idle and absolute limits.
+func (m *Manager) live(rec *Record, now time.Time) bool {
+	return now.Before(rec.LastSeen.Add(m.opts.IdleTimeout)) &&
+		now.Before(rec.Created.Add(m.opts.AbsoluteTimeout))
+}
+
+// save stores the session and sets the cookie that names it.
+func (m *Manager) save(w *response.Writer, s *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range s.staleIDs {
+		if err := m.store.Delete(id); err != nil {
+			return err
+		}
+	}
+	s.staleIDs = nil
+
+	if s.destroyed {
+		if s.isNew {
+			return nil
+		}
+		if err := m.store.Delete(s.rec.ID); err != nil {
+			return err
+		}
+		return m.setCookie(w, "", -1)
+	}
+	if s.isNew && !s.modified {
+		return nil
+	}
+
+	// Every request refreshes LastSeen, so the record and cookie are
+	// written even when no value changed.
+	now := m.now()
+	s.rec.LastSeen = now
+	s.rec.Expires = now.Add(m.opts.IdleTimeout)
+	if abs := s.rec.Created.Add(m.opts.AbsoluteTimeout); abs.Before(s.rec.Expires) {
+		s.rec.Expires = abs
+	}
+	rec := s.rec
+	token, err := m.store.Save(&rec)
+	if err != nil {
+		return err
+	}
+	return m.setCookie(w, token, 0)
+}
+
+// setCookie sends the session cookie. It is a browser-session cookie;
+// expiry is enforced server-side from the record.
+func (m *Manager) setCookie(w *response.Writer, value string, maxAge int) error {
+	w.Header().Add("Vary", "Cookie")
+	return w.SetCookie(&cookie.Cookie{
+		Name:     m.opts.CookieName,
+		Value:    value,
+		Path:     m.opts.Path,
+		Domain:   m.opts.Domain,
+		MaxAge:   maxAge,
+		Secure:   m.opts.Secure,
+		HttpOnly: true,
+		SameSite: m.opts.SameSite,
+	})
+}
+
+// writeStatus sends a plain-text response consisting of the reason phrase.
+func writeStatus(w *response.Writer, status response.StatusCode) {
+	w.SetStatus(status)
+	w.Write([]byte(response.StatusText(status) + "\n"))
+}
@@ -0,0 +1,69 @@
+package session
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore drops expired records.
+const sweepInterval = time.Minute
+
+// MemoryStore keeps sessions in process memory. They are lost on restart
+// and not shared between processes.
+type MemoryStore struct {
+	mu        sync.Mutex
+	records   map[string]*Record
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: map[string]*Record{}, now: time.Now}
+}
+
+// Load returns a copy of the record with the given ID.
+func (s *MemoryStore) Load(token string) (*Record, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.records[token]
+	if !ok || !s.now().Before(rec.Expires) {
+		return nil, ErrNotFound
+	}
+	return copyRecord(rec), nil
+}
+
+// Save stores a copy of rec. Expired records are swept out now and then
+// so abandoned sessions don't pile up.
+func (s *MemoryStore) Save(rec *Record) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if now.Sub(s.lastSweep) >= sweepInterval {
+		for id, r := range s.records {
+			if !now.Before(r.Expires) {
+				delete(s.records, id)
+			}
+		}
+		s.lastSweep = now
+	}
+	s.records[rec.ID] = copyRecord(rec)
+	return rec.ID, nil
+}
+
+// Delete removes the record with the given ID.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// copyRecord returns a deep copy of rec so callers can't reach the stored
+// Values map.
+func copyRecord(rec *Record) *Record {
+	c := *rec
+	c.Values = maps.Clone(rec.Values)
+	return &c
+}
@@ -0,0 +1,147 @@
+package session
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by a Store when it holds no live session for a
+// token.
+var ErrNotFound = errors.New("session not found")
+
+// Record is the state a Store keeps for one session.
+type Record struct {
+	ID     string            `json:"id"`
+	Values map[string]string `json:"values"`
+	// Created is when the session started; it bounds the absolute
+	// lifetime and survives ID rotation.
+	Created time.Time `json:"created"`
+	// LastSeen is when the session was last used; it drives idle expiry.
+	LastSeen time.Time `json:"last_seen"`
+	// Expires is when the session stops being valid, the earlier of its
+	// idle and absolute deadlines. Stores may drop records past it.
+	Expires time.Time `json:"expires"`
+}
+
+// Store persists session records. The Manager calls it from many goroutines
+// at once, so implementations must be safe for concurrent use.
+type Store interface {
+	// Load returns the record for the token the client sent in its
+	// session cookie, or ErrNotFound.
+	Load(token string) (*Record, error)
+	// Save stores rec and returns the token to send back in the cookie.
+	// Stores that keep records server-side return rec.ID; stores that
+	// keep them client-side return the encoded record.
+	Save(rec *Record) (string, error)
+	// Delete forgets the session with the given ID.
+	Delete(id string) error
+}
+
+// Session is the session attached to one request. Its methods are safe to
+// call from several goroutines, though handlers rarely need to.
+type Session struct {
+	mu        sync.Mutex
+	rec       Record
+	isNew     bool
+	modified  bool
+	destroyed bool
+	// staleIDs are IDs given up by RenewID, deleted from the store on save.
+	staleIDs []string
+}
+
+// Get returns the value stored under key, or "" if there is none.
+func (s *Session) Get(key string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.Values[key]
+}
+
+// Set stores value under key.
+func (s *Session) Set(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.rec.Values == nil {
+		s.rec.Values = map[string]string{}
+	}
+	s.rec.Values[key] = value
+	s.modified = true
+}
+
+// Delete removes key from the session.
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rec.Values[key]; ok {
+		delete(s.rec.Values, key)
+		s.modified = true
+	}
+}
+
+// ID returns the session ID. A new session has an ID even before anything
+// is stored in it.
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.rec.ID
+}
+
+// IsNew reports whether the session was started by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+// RenewID gives the session a fresh ID and discards the old one, keeping
+// its values. Call it whenever the privilege level changes, such as at
+// login or logout, so an ID planted before the change (session fixation)
+// becomes useless.
+func (s *Session) RenewID() error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew {
+		s.staleIDs = append(s.staleIDs, s.rec.ID)
+	}
+	s.rec.ID = id
+	s.modified = true
+	return nil
+}
+
+// Destroy ends the session: its record is deleted and the client is told to
+// drop the cookie. Values set afterwards are discarded.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+}
+
+// newID returns 32 random bytes, base64url encoded.
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// validID reports whether id could have come from newID, so it is safe to
+// use as a map key or file name.
+func validID(id string) bool {
+	if len(id) != base64.RawURLEncoding.EncodedLen(32) {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		ch := id[i]
+		if !((ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') || ch == '-' || ch == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,162 @@
+package session
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+var setCookieRe = regexp.MustCompile(`(?m)^set-cookie: session=([^;\r]*)`)
+
+// roundTrip sends a request carrying cookie (if any) through the manager's
+// middleware and returns the session cookie value from the response, or
+// "<none>" when no cookie was set.
+func roundTrip(t *testing.T, m *Manager, cookie string, h func(s *Session)) string {
+	raw := "GET / HTTP/1.1\r\nHost: x\r\n"
+	if cookie != "" {
+		raw += "Cookie: session=" + cookie + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	m.Middleware()(func(w *response.Writer, req *request.Request) {
+		s := m.Get(req)
+		require.NotNil(t, s)
+		h(s)
+		w.Write([]byte("ok"))
+	})(w, req)
+	require.NoError(t, w.Finish())
+	assert.Nil(t, m.Get(req))
+
+	match := setCookieRe.FindStringSubmatch(buf.String())
+	if match == nil {
+		return "<none>"
+	}
+	return match[1]
+}
+
+func testStore(t *testing.T, store Store) {
+	clock := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	m := NewManager(store, Options{IdleTimeout: 10 * time.Minute, AbsoluteTimeout: time.Hour})
+	m.now = func() time.Time { return clock }
+	setClock(store, func() time.Time { return clock })
+
+	// Test: Untouched new sessions aren't stored
+	assert.Equal(t, "<none>", roundTrip(t, m, "", func(s *Session) { assert.True(t, s.IsNew()) }))
+
+	// Test: Values survive between requests
+	token := roundTrip(t, m, "", func(s *Session) { s.Set("user", "ada") })
+	require.NotEqual(t, "<none>", token)
+	var id string
+	token = roundTrip(t, m, token, func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "ada", s.Get("user"))
+		id = s.ID()
+	})
+
+	// Test: RenewID keeps values but changes the ID
+	renewed := roundTrip(t, m, token, func(s *Session) {
+		require.NoError(t, s.RenewID())
+		assert.NotEqual(t, id, s.ID())
+	})
+	roundTrip(t, m, renewed, func(s *Session) {
+		assert.False(t, s.IsNew())
+		assert.Equal(t, "ada", s.Get("user"))
+	})
+	if _, ok := store.(*CookieStore); !ok {
+		roundTrip(t, m, token, func(s *Session) { assert.True(t, s.IsNew(), "old ID must be dead") })
+	}
+
+	// Test: Idle expiry
+	clock = clock.Add(11 * time.Minute)
+	roundTrip(t, m, renewed, func(s *Session) { assert.True(t, s.IsNew()) })
+
+	// Test: Absolute expiry applies even to active sessions
+	token = roundTrip(t, m, "", func(s *Session) { s.Set("k", "v") })
+	for range 6 {
+		clock = clock.Add(9 * time.Minute)
+		token = roundTrip(t, m, token, func(s *Session) { assert.False(t, s.IsNew()) })
+	}
+	clock = clock.Add(9 * time.Minute)
+	roundTrip(t, m, token, func(s *Session) { assert.True(t, s.IsNew()) })
+
+	// Test: Destroy clears the cookie
+	token = roundTrip(t, m, "", func(s *Session) { s.Set("k", "v") })
+	assert.Equal(t, "", roundTrip(t, m, token, func(s *Session) { s.Destroy() }))
+	if _, ok := store.(*CookieStore); !ok {
+		roundTrip(t, m, token, func(s *Session) { assert.True(t, s.IsNew()) })
+	}
+}
+
+func setClock(store Store, now func() time.Time) {
+	switch s := store.(type) {
+	case *MemoryStore:
+		s.now = now
+	case *FileStore:
+		s.now = now
+	case *CookieStore:
+		s.now = now
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	testStore(t, store)
+
+	// Test: IDs that aren't ours never reach the file system
+	_, err = store.Load("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCookieStore(t *testing.T) {
+	hashKey := bytes.Repeat([]byte("h"), 32)
+	blockKey := bytes.Repeat([]byte("b"), 32)
+
+	for _, block := range [][]byte{nil, blockKey} {
+		store, err := NewCookieStore(hashKey, block)
+		require.NoError(t, err)
+		testStore(t, store)
+	}
+
+	// Test: Short keys are refused
+	_, err := NewCookieStore([]byte("short"), nil)
+	assert.Error(t, err)
+	_, err = NewCookieStore(hashKey, []byte("short"))
+	assert.Error(t, err)
+
+	// Test: Tampered and foreign tokens are rejected
+	store, err := NewCookieStore(hashKey, blockKey)
+	require.NoError(t, err)
+	token, err := store.Save(&Record{ID: "x", Values: map[string]string{"user": "ada"}, Expires: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	assert.NotContains(t, token, "ada")
+	_, err = store.Load(token)
+	require.NoError(t, err)
+	tampered := []byte(token)
+	tampered[5] ^= 1
+	_, err = store.Load(string(tampered))
+	assert.ErrorIs(t, err, ErrNotFound)
+	other, err := NewCookieStore(bytes.Repeat([]byte("o"), 32), blockKey)
+	require.NoError(t, err)
+	_, err = other.Load(token)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Test: Oversized sessions are refused
+	_, err = store.Save(&Record{ID: "x", Values: map[string]string{"big": strings.Repeat("a", 4000)}})
+	assert.ErrorIs(t, err, ErrCookieTooLarge)
+}
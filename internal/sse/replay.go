@@ -0,0 +1,76 @@
+package sse
+
+import (
+	"strconv"
+	"sync"
+)
+
+// ReplayBuffer remembers the most recent events of a feed so a client that
+// reconnects with Last-Event-ID gets what it missed. It is safe for
+// concurrent use, typically one writer adding events and a stream per
+// client replaying them.
+type ReplayBuffer struct {
+	mu     sync.Mutex
+	events []Event
+	size   int
+	nextID uint64
+}
+
+// NewReplayBuffer returns a buffer keeping the last size events, at least
+// one.
+func NewReplayBuffer(size int) *ReplayBuffer {
+	size = max(size, 1)
+	return &ReplayBuffer{size: size, nextID: 1}
+}
+
+// Add stores ev and returns it as stored. Events without an ID get the
+// next number in sequence, so every buffered event can be resumed from.
+func (b *ReplayBuffer) Add(ev Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ev.ID == "" {
+		ev.ID = strconv.FormatUint(b.nextID, 10)
+		b.nextID++
+	}
+	if len(b.events) == b.size {
+		copy(b.events, b.events[1:])
+		b.events = b.events[:b.size-1]
+	}
+	b.events = append(b.events, ev)
+	return ev
+}
+
+// Since returns the events added after the one with ID lastID. If lastID
+// isn't buffered, because it is empty, unknown or too old, it returns every
+// buffered event and false, so the caller can tell the client may have
+// missed more.
+func (b *ReplayBuffer) Since(lastID string) ([]Event, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastID != "" {
+		for i := len(b.events) - 1; i >= 0; i-- {
+			if b.events[i].ID == lastID {
+				return append([]Event(nil), b.events[i+1:]...), true
+			}
+		}
+	}
+	return append([]Event(nil), b.events...), false
+}
+
+// Replay sends the events from buf that the client missed, judging by its
+// Last-Event-ID. A client connecting for the first time gets nothing; one
+// whose ID is no longer buffered gets everything still held. It reports
+// whether the client's ID was found.
+func (s *Stream) Replay(buf *ReplayBuffer) (bool, error) {
+	lastID := s.LastEventID()
+	if lastID == "" {
+		return true, nil
+	}
+	events, found := buf.Since(lastID)
+	for _, ev := range events {
+		if err := s.Send(ev); err != nil {
+			return found, err
+		}
+	}
+	return found, nil
+}
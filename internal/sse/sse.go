@@ -0,0 +1,220 @@
+package sse
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// ErrInvalidField is returned for an event name or ID that would break the
+// stream: both must fit on one line, and IDs can't contain NUL.
+var ErrInvalidField = errors.New("sse: invalid event field")
+
+// ErrStreamClosed is returned by writes after Close, or after an earlier
+// write failed because the client went away.
+var ErrStreamClosed = errors.New("sse: stream closed")
+
+// Event is one Server-Sent Event.
+type Event struct {
+	// ID becomes the client's last event ID, sent back in Last-Event-ID
+	// when it reconnects.
+	ID string
+	// Event names the event type; empty means "message".
+	Event string
+	// Data is the payload. It may span several lines.
+	Data string
+	// Retry, when positive, tells the client how long to wait before
+	// reconnecting.
+	Retry time.Duration
+}
+
+// MarshalText encodes the event in the text/event-stream format, ending
+// with the blank line that dispatches it.
+func (ev Event) MarshalText() ([]byte, error) {
+	if strings.ContainsAny(ev.Event, "\r\n") {
+		return nil, fmt.Errorf("%w: event name %q", ErrInvalidField, ev.Event)
+	}
+	if strings.ContainsAny(ev.ID, "\r\n\x00") {
+		return nil, fmt.Errorf("%w: id %q", ErrInvalidField, ev.ID)
+	}
+
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: " + ev.ID + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + ev.Event + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(ev.Retry.Milliseconds(), 10) + "\n")
+	}
+	// An event with neither a name nor data only updates the ID or retry
+	// delay; writing a data line would make the browser dispatch it.
+	if ev.Data != "" || ev.Event != "" {
+		for _, line := range splitLines(ev.Data) {
+			b.WriteString("data: " + line + "\n")
+		}
+	}
+	b.WriteString("\n")
+	return []byte(b.String()), nil
+}
+
+// splitLines splits s on any of the line endings the format accepts, so a
+// stray CR can't end a field early and smuggle in another one.
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+// Stream writes events to one client. Its methods are safe for concurrent
+// use, so a heartbeat can run while the handler sends events.
+type Stream struct {
+	w   *response.Writer
+	req *request.Request
+
+	mu     sync.Mutex
+	err    error
+	done   chan struct{}
+	stop   chan struct{}
+	ticker sync.WaitGroup
+}
+
+// NewStream starts an event stream in response to req: it sends the
+// text/event-stream headers straight away, so the client sees the
+// connection open before the first event. The handler must call Close
+// before returning.
+func NewStream(w *response.Writer, req *request.Request) (*Stream, error) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.SetStatus(response.StatusOk)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	s := &Stream{w: w, req: req, done: make(chan struct{}), stop: make(chan struct{})}
+	go s.watch(req.Context())
+	return s, nil
+}
+
+// watch ends the stream when the request's context does, which is how a
+// disconnect is noticed even between writes.
+func (s *Stream) watch(ctx context.Context) {
+	select {
+	case <-ctx.Done():
+		s.mu.Lock()
+		if s.err == nil {
+			s.end(ctx.Err())
+		}
+		s.mu.Unlock()
+	case <-s.done:
+	}
+}
+
+// LastEventID returns the ID the client last saw, from the Last-Event-ID
+// header a reconnecting EventSource sends, or "".
+func (s *Stream) LastEventID() string {
+	return s.req.Headers.Get("Last-Event-ID")
+}
+
+// Send writes ev and flushes it to the client.
+func (s *Stream) Send(ev Event) error {
+	b, err := ev.MarshalText()
+	if err != nil {
+		return err
+	}
+	return s.write(b)
+}
+
+// Comment writes a comment line, which clients ignore. Each line of text
+// becomes its own comment.
+func (s *Stream) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(":" + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write([]byte(b.String()))
+}
+
+// StartHeartbeat sends an empty comment every interval until Close. It keeps
+// proxies from timing out an idle stream, and a failing heartbeat is how a
+// quiet stream notices that the client left.
+func (s *Stream) StartHeartbeat(interval time.Duration) {
+	s.ticker.Add(1)
+	go func() {
+		defer s.ticker.Done()
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-t.C:
+				if s.Comment("") != nil {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// Done is closed once the stream can no longer be written: the client
+// disconnected, the request's context ended, a write failed or Close was
+// called.
+func (s *Stream) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns why the stream ended early: the failed write's error or the
+// context's. It is nil while the stream runs and after Close.
+func (s *Stream) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if errors.Is(s.err, ErrStreamClosed) {
+		return nil
+	}
+	return s.err
+}
+
+// Close stops the heartbeat and waits for it to exit. The server finishes
+// the response once the handler returns.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	if s.err == nil {
+		s.end(ErrStreamClosed)
+	}
+	s.mu.Unlock()
+	s.ticker.Wait()
+}
+
+// write sends b and flushes it, ending the stream on failure.
+func (s *Stream) write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return ErrStreamClosed
+	}
+	_, err := s.w.Write(b)
+	if err == nil {
+		err = s.w.Flush()
+	}
+	if err != nil {
+		s.end(err)
+	}
+	return err
+}
+
+// end records why the stream stopped and wakes anyone waiting on Done.
+// s.mu must be held.
+func (s *Stream) end(err error) {
+	s.err = err
+	close(s.done)
+	close(s.stop)
+}
@@ -0,0 +1,150 @@
+package sse
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+func TestEventMarshal(t *testing.T) {
+	// Test: All fields, with multi-line data in every line-ending style
+	b, err := Event{ID: "7", Event: "update", Data: "a\nb\r\nc\rd", Retry: 3 * time.Second}.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "id: 7\nevent: update\nretry: 3000\ndata: a\ndata: b\ndata: c\ndata: d\n\n", string(b))
+
+	// Test: Empty data on a named event is still dispatched
+	b, err = Event{Event: "ping"}.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "event: ping\ndata: \n\n", string(b))
+
+	// Test: An ID-only event carries no data
+	b, err = Event{ID: "9"}.MarshalText()
+	require.NoError(t, err)
+	assert.Equal(t, "id: 9\n\n", string(b))
+
+	// Test: Newlines can't be injected through the name or ID
+	_, err = Event{Event: "x\ndata: evil"}.MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidField)
+	_, err = Event{ID: "1\r"}.MarshalText()
+	assert.ErrorIs(t, err, ErrInvalidField)
+}
+
+// lockedBuffer is a bytes.Buffer safe for the heartbeat goroutine.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// failWriter accepts n writes, then fails like a closed connection.
+type failWriter struct{ n int }
+
+func (f *failWriter) Write(p []byte) (int, error) {
+	if f.n == 0 {
+		return 0, errors.New("broken pipe")
+	}
+	f.n--
+	return len(p), nil
+}
+
+func newRequest(t *testing.T, lastID string) *request.Request {
+	raw := "GET /events HTTP/1.1\r\nHost: x\r\n"
+	if lastID != "" {
+		raw += "Last-Event-ID: " + lastID + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+	return req
+}
+
+func TestStream(t *testing.T) {
+	// Test: Headers go out at once and events are streamed chunked
+	var buf lockedBuffer
+	w := response.NewWriter(&buf)
+	s, err := NewStream(w, newRequest(t, ""))
+	require.NoError(t, err)
+	out := buf.String()
+	assert.True(t, strings.HasPrefix(out, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, out, "content-type: text/event-stream\r\n")
+	assert.Contains(t, out, "transfer-encoding: chunked\r\n")
+	require.NoError(t, s.Send(Event{Data: "hello"}))
+	assert.Contains(t, buf.String(), "\r\ndata: hello\n\n\r\n")
+
+	// Test: Heartbeats are comments and stop on Close
+	s.StartHeartbeat(time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+	assert.Contains(t, buf.String(), ":\n\n")
+	assert.ErrorIs(t, s.Send(Event{Data: "late"}), ErrStreamClosed)
+	assert.NoError(t, s.Err())
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+
+	// Test: A heartbeat into a dead connection ends the stream
+	s, err = NewStream(response.NewWriter(&failWriter{n: 20}), newRequest(t, ""))
+	require.NoError(t, err)
+	s.StartHeartbeat(time.Millisecond)
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("disconnect not noticed")
+	}
+	assert.EqualError(t, s.Err(), "broken pipe")
+	s.Close()
+}
+
+func TestReplay(t *testing.T) {
+	rb := NewReplayBuffer(3)
+	for _, d := range []string{"a", "b", "c", "d"} {
+		rb.Add(Event{Data: d})
+	}
+
+	// Test: IDs are assigned and only the newest events are kept
+	events, found := rb.Since("2")
+	assert.True(t, found)
+	assert.Equal(t, []Event{{ID: "3", Data: "c"}, {ID: "4", Data: "d"}}, events)
+	events, found = rb.Since("1")
+	assert.False(t, found)
+	assert.Len(t, events, 3)
+
+	// Test: A reconnecting stream gets what it missed
+	var buf bytes.Buffer
+	s, err := NewStream(response.NewWriter(&buf), newRequest(t, "3"))
+	require.NoError(t, err)
+	assert.Equal(t, "3", s.LastEventID())
+	found, err = s.Replay(rb)
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Contains(t, buf.String(), "id: 4\ndata: d\n\n")
+	assert.NotContains(t, buf.String(), "data: c")
+	s.Close()
+
+	// Test: First-time clients get no replay
+	buf.Reset()
+	s, err = NewStream(response.NewWriter(&buf), newRequest(t, ""))
+	require.NoError(t, err)
+	_, err = s.Replay(rb)
+	require.NoError(t, err)
+	assert.NotContains(t, buf.String(), "data:")
+	s.Close()
+}
@@ -0,0 +1,124 @@
+package vhost
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// Options configures Handler.
+type Options struct {
+	// Hosts maps hostnames to the site serving them. A name is matched
+	// case-insensitively and without its port. A pattern "*.example.com"
+	// matches any name under example.com, but not example.com itself; an
+	// exact name wins over a pattern, and a longer pattern over a shorter
+	// one.
+	Hosts map[string]server.Handler
+	// Default serves hosts that match nothing. Without it they get 404.
+	Default server.Handler
+}
+
+type wildcard struct {
+	// suffix is the pattern without its "*", such as ".example.com".
+	suffix  string
+	handler server.Handler
+}
+
+// Handler returns a server.Handler that dispatches on the host the client
+// asked for: req.Client.Host, which is the target's authority for
+// absolute-form requests and the Host header otherwise, or what
+// forwarded.Middleware resolved behind trusted proxies.
+//
+// Requests without a Host header, or with more than one, are answered 400
+// as RFC 9112 section 3.2 requires of HTTP/1.1, the only version the
+// server accepts.
+//
+// Handler panics if a pattern has a "*" anywhere but as its whole first
+// label.
+func Handler(opts Options) server.Handler {
+	exact := map[string]server.Handler{}
+	var wildcards []wildcard
+	for pattern, h := range opts.Hosts {
+		name := normalize(pattern)
+		suffix, isWildcard := strings.CutPrefix(name, "*")
+		if strings.Contains(suffix, "*") || (isWildcard && (len(suffix) < 2 || suffix[0] != '.')) {
+			panic("vhost: invalid host pattern " + pattern)
+		}
+		if isWildcard {
+			wildcards = append(wildcards, wildcard{suffix: suffix, handler: h})
+		} else {
+			exact[name] = h
+		}
+	}
+	// Most specific first, so the first match wins.
+	sort.Slice(wildcards, func(i, j int) bool {
+		return len(wildcards[i].suffix) > len(wildcards[j].suffix)
+	})
+
+	return func(w *response.Writer, req *request.Request) {
+		if msg := checkHost(req); msg != "" {
+			w.SetStatus(response.StatusBadRequest)
+			w.Write([]byte(msg + "\n"))
+			return
+		}
+		host := req.Client.Host
+		if host == "" {
+			host = req.ClientFromConn().Host
+		}
+		if h := match(normalize(host), exact, wildcards); h != nil {
+			h(w, req)
+			return
+		}
+		if opts.Default != nil {
+			opts.Default(w, req)
+			return
+		}
+		w.SetStatus(response.StatusNotFound)
+		w.Write([]byte(response.StatusText(response.StatusNotFound) + "\n"))
+	}
+}
+
+// checkHost returns why req's Host header is unacceptable, or "" if it is
+// fine.
+func checkHost(req *request.Request) string {
+	switch req.HostFields() {
+	case 0:
+		return "missing Host header"
+	case 1:
+		return ""
+	default:
+		return "multiple Host headers"
+	}
+}
+
+func match(host string, exact map[string]server.Handler, wildcards []wildcard) server.Handler {
+	if host == "" {
+		return nil
+	}
+	if h, ok := exact[host]; ok {
+		return h
+	}
+	for _, wc := range wildcards {
+		if len(host) > len(wc.suffix) && strings.HasSuffix(host, wc.suffix) {
+			return wc.handler
+		}
+	}
+	return nil
+}
+
+// normalize lowercases a host and drops its port and any trailing dot, so
+// "Example.COM.:8080" and "example.com" are the same site. IPv6 literals
+// keep their brackets.
+func normalize(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
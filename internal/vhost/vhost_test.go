@@ -0,0 +1,106 @@
+package vhost
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// site answers with its name.
+func site(name string) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		w.Write([]byte("site " + name + "\n"))
+	}
+}
+
+// serve parses raw, fills in req.Client the way the server does, runs h and
+// returns the raw response.
+func serve(t *testing.T, h server.Handler, raw string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader(raw))
+	require.NoError(t, err)
+	req.Client = req.ClientFromConn()
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	h(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func get(host string) string {
+	return "GET / HTTP/1.1\r\nHost: " + host + "\r\n\r\n"
+}
+
+func TestHandler(t *testing.T) {
+	h := Handler(Options{
+		Hosts: map[string]server.Handler{
+			"example.com":       site("apex"),
+			"*.example.com":     site("any"),
+			"*.api.example.com": site("api"),
+			"api.example.com":   site("api-root"),
+			"[::1]":             site("ipv6"),
+		},
+		Default: site("default"),
+	})
+
+	cases := []struct {
+		raw  string
+		want string
+	}{
+		{get("example.com"), "site apex"},
+		{get("Example.COM:8080"), "site apex"},
+		{get("example.com."), "site apex"},
+		{get("www.example.com"), "site any"},
+		{get("a.b.example.com"), "site any"},
+		{get("api.example.com"), "site api-root"},
+		{get("v1.api.example.com"), "site api"},
+		{get("[::1]:8080"), "site ipv6"},
+		{get("badexample.com"), "site default"},
+		{get("other.org"), "site default"},
+		// An absolute-form target overrides Host (RFC 9112 section 3.2.2).
+		{"GET http://www.example.com/ HTTP/1.1\r\nHost: other.org\r\n\r\n", "site any"},
+	}
+	for _, c := range cases {
+		// Test: each host is dispatched to the most specific site
+		assert.Contains(t, serve(t, h, c.raw), c.want+"\n", c.raw)
+	}
+}
+
+func TestHandlerRejectsBadHost(t *testing.T) {
+	h := Handler(Options{Hosts: map[string]server.Handler{"example.com": site("apex")}})
+
+	// Test: Host is required
+	resp := serve(t, h, "GET / HTTP/1.1\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 "))
+	assert.Contains(t, resp, "missing Host header")
+
+	// Test: More than one Host is rejected
+	resp = serve(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\nHost: evil.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 "))
+	assert.Contains(t, resp, "multiple Host headers")
+	resp = serve(t, h, "GET / HTTP/1.1\r\nHost: example.com\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 "))
+	resp = serve(t, h, "GET / HTTP/1.1\r\nHost:\r\nHost: example.com\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 "))
+	assert.Contains(t, resp, "multiple Host headers")
+
+	// Test: Unknown hosts get 404 without a default
+	resp = serve(t, h, get("other.org"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 404 "))
+}
+
+func TestHandlerInvalidPattern(t *testing.T) {
+	for _, pattern := range []string{"www.*.com", "*example.com", "*", "*.", "**.example.com"} {
+		// Test: each malformed wildcard panics
+		assert.Panics(t, func() {
+			Handler(Options{Hosts: map[string]server.Handler{pattern: site("x")}})
+		}, pattern)
+	}
+}
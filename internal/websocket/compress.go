@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// deflateTail is the empty stored block a sync flush ends with. Senders
+// strip it from each message and receivers put it back (RFC 7692 section
+// 7.2.1).
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// finalBlock is an empty final stored block, appended when inflating so the
+// decompressor reports io.EOF at the end of the message.
+var finalBlock = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+
+// flateWriters reuses compressors, which are expensive to allocate. Without
+// context takeover each message starts from a reset one.
+var flateWriters = sync.Pool{
+	New: func() any {
+		fw, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return fw
+	},
+}
+
+// deflate compresses one message payload.
+func deflate(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	fw := flateWriters.Get().(*flate.Writer)
+	defer flateWriters.Put(fw)
+	fw.Reset(&buf)
+	if _, err := fw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := fw.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail), nil
+}
+
+// inflate decompresses one message payload, refusing output over limit
+// bytes.
+func inflate(data []byte, limit int64) ([]byte, error) {
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail), bytes.NewReader(finalBlock)))
+	defer fr.Close()
+	// Read one byte past the limit to tell "exactly the limit" from "more".
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, protocolError(CloseInvalidPayload, "invalid compressed message")
+	}
+	if int64(len(out)) > limit {
+		return nil, protocolError(CloseMessageTooBig, fmt.Sprintf("message exceeds %d bytes", limit))
+	}
+	return out, nil
+}
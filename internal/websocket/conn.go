@@ -0,0 +1,514 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// MessageType is the type of a data message.
+type MessageType int
+
+const (
+	TextMessage   MessageType = opText
+	BinaryMessage MessageType = opBinary
+)
+
+// Frame opcodes (RFC 6455 section 5.2).
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes (RFC 6455 section 7.4.1).
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005
+	CloseAbnormal           = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseMessageTooBig      = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+// maxControlPayload is the largest payload a control frame may carry.
+const maxControlPayload = 125
+
+// closeTimeout bounds how long Close waits for the peer's close frame.
+const closeTimeout = 5 * time.Second
+
+// ErrClosed is returned by writes after the close handshake has started.
+var ErrClosed = errors.New("websocket: connection closed")
+
+// CloseError is returned by ReadMessage once the connection is closed,
+// carrying the status the peer sent or, for protocol errors we detected,
+// the status we sent. Code is CloseNoStatus when the close frame had no
+// status and CloseAbnormal when the connection dropped without one.
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("websocket: closed with status %d: %s", e.Code, e.Reason)
+}
+
+// Conn is a server-side WebSocket connection. One goroutine may read while
+// others write: writes are serialized internally, but ReadMessage must not
+// be called concurrently with itself.
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	subprotocol string
+	compress    bool
+	maxSize     int64
+
+	// rmu is held while a reader is active, so Close knows whether it has
+	// to wait for the peer's close frame itself.
+	rmu     sync.Mutex
+	readErr error
+
+	wmu       sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, subprotocol string, compress bool, maxSize int64) *Conn {
+	return &Conn{conn: conn, br: br, subprotocol: subprotocol, compress: compress, maxSize: maxSize}
+}
+
+// Subprotocol returns the negotiated subprotocol, or "" if there is none.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// RemoteAddr returns the peer's network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// SetReadDeadline sets the deadline for ReadMessage, after which it fails
+// with a timeout error. A zero time means no deadline.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for writes.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// frameHeader is a decoded frame header.
+type frameHeader struct {
+	fin    bool
+	rsv1   bool
+	opcode byte
+	length int64
+	mask   [4]byte
+}
+
+// readFrameHeader reads and checks a frame header from the client.
+func (c *Conn) readFrameHeader() (frameHeader, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+		return frameHeader{}, err
+	}
+	h := frameHeader{
+		fin:    b[0]&0x80 != 0,
+		rsv1:   b[0]&0x40 != 0,
+		opcode: b[0] & 0x0f,
+	}
+	if b[0]&0x30 != 0 {
+		return h, protocolError(CloseProtocolError, "reserved bits set")
+	}
+	if h.rsv1 && !c.compress {
+		return h, protocolError(CloseProtocolError, "compressed frame without permessage-deflate")
+	}
+	// Clients must mask every frame (RFC 6455 section 5.1).
+	if b[1]&0x80 == 0 {
+		return h, protocolError(CloseProtocolError, "unmasked client frame")
+	}
+
+	switch n := b[1] & 0x7f; n {
+	case 126:
+		if _, err := io.ReadFull(c.br, b[:2]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, b[:8]); err != nil {
+			return h, err
+		}
+		h.length = int64(binary.BigEndian.Uint64(b[:8]))
+		if h.length < 0 {
+			return h, protocolError(CloseProtocolError, "invalid frame length")
+		}
+	default:
+		h.length = int64(n)
+	}
+	if _, err := io.ReadFull(c.br, h.mask[:]); err != nil {
+		return h, err
+	}
+
+	switch h.opcode {
+	case opContinuation, opText, opBinary:
+	case opClose, opPing, opPong:
+		if !h.fin || h.length > maxControlPayload || h.rsv1 {
+			return h, protocolError(CloseProtocolError, "invalid control frame")
+		}
+	default:
+		return h, protocolError(CloseProtocolError, fmt.Sprintf("unknown opcode %#x", h.opcode))
+	}
+	return h, nil
+}
+
+// readPayload reads and unmasks a frame's payload.
+func (c *Conn) readPayload(h frameHeader) ([]byte, error) {
+	p := make([]byte, h.length)
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		return nil, err
+	}
+	for i := range p {
+		p[i] ^= h.mask[i%4]
+	}
+	return p, nil
+}
+
+// ReadMessage returns the next data message, reassembling fragments and
+// decompressing as needed. Pings are answered and pongs ignored along the
+// way. When the peer closes the connection, or sends something that breaks
+// the protocol, the close handshake is completed and a *CloseError
+// returned; every later call returns the same error.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.readErr != nil {
+		return 0, nil, c.readErr
+	}
+	typ, msg, err := c.readMessage()
+	if err != nil {
+		c.readErr = c.fail(err)
+		return 0, nil, c.readErr
+	}
+	return typ, msg, nil
+}
+
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		typ        MessageType
+		msg        []byte
+		compressed bool
+	)
+	for {
+		h, err := c.readFrameHeader()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch h.opcode {
+		case opPing, opPong, opClose:
+			payload, err := c.readPayload(h)
+			if err != nil {
+				return 0, nil, err
+			}
+			if err := c.handleControl(h.opcode, payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, protocolError(CloseProtocolError, "continuation frame without a message")
+			}
+			if h.rsv1 {
+				return 0, nil, protocolError(CloseProtocolError, "compression bit on continuation frame")
+			}
+		default:
+			if typ != 0 {
+				return 0, nil, protocolError(CloseProtocolError, "new message before the last one finished")
+			}
+			typ = MessageType(h.opcode)
+			compressed = h.rsv1
+		}
+
+		if int64(len(msg))+h.length > c.maxSize {
+			return 0, nil, protocolError(CloseMessageTooBig, fmt.Sprintf("message exceeds %d bytes", c.maxSize))
+		}
+		payload, err := c.readPayload(h)
+		if err != nil {
+			return 0, nil, err
+		}
+		msg = append(msg, payload...)
+		if !h.fin {
+			continue
+		}
+
+		if compressed {
+			if msg, err = inflate(msg, c.maxSize); err != nil {
+				return 0, nil, err
+			}
+		}
+		if typ == TextMessage && !utf8.Valid(msg) {
+			return 0, nil, protocolError(CloseInvalidPayload, "text message is not valid UTF-8")
+		}
+		return typ, msg, nil
+	}
+}
+
+// handleControl answers pings and processes the peer's close frame.
+func (c *Conn) handleControl(opcode byte, payload []byte) error {
+	switch opcode {
+	case opPing:
+		err := c.writeFrame(opPong, payload, false)
+		if errors.Is(err, ErrClosed) {
+			// We're closing; the pong no longer matters.
+			return nil
+		}
+		return err
+	case opPong:
+		return nil
+	}
+
+	ce := &CloseError{Code: CloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return protocolError(CloseProtocolError, "truncated close status")
+	case len(payload) >= 2:
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+		if !validCloseCode(ce.Code) {
+			return protocolError(CloseProtocolError, fmt.Sprintf("invalid close status %d", ce.Code))
+		}
+		if !utf8.ValidString(ce.Reason) {
+			return protocolError(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+	// Echo the status back, then the server closes the TCP connection
+	// first (RFC 6455 section 7.1.1).
+	reply := ce.Code
+	if reply == CloseNoStatus {
+		reply = CloseNormal
+	}
+	if err := c.sendClose(reply, ""); err != nil && !errors.Is(err, ErrClosed) {
+		return err
+	}
+	c.conn.Close()
+	return ce
+}
+
+// fail turns a read error into the error ReadMessage reports, closing the
+// connection. Protocol errors are announced to the peer first.
+func (c *Conn) fail(err error) error {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		return ce
+	}
+	var pe *protoErr
+	if errors.As(err, &pe) {
+		c.sendClose(pe.code, pe.reason)
+		c.conn.Close()
+		return &CloseError{Code: pe.code, Reason: pe.reason}
+	}
+	c.conn.Close()
+	c.wmu.Lock()
+	sent := c.closeSent
+	c.wmu.Unlock()
+	if sent && errors.Is(err, net.ErrClosed) {
+		// Close tore the connection down after its own handshake.
+		return &CloseError{Code: CloseNormal}
+	}
+	return fmt.Errorf("%w: %w", &CloseError{Code: CloseAbnormal}, err)
+}
+
+// validCloseCode reports whether code may appear in a close frame.
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// protoErr is a protocol violation by the peer, closed with code.
+type protoErr struct {
+	code   int
+	reason string
+}
+
+func (e *protoErr) Error() string {
+	return "websocket: " + e.reason
+}
+
+func protocolError(code int, reason string) error {
+	return &protoErr{code: code, reason: reason}
+}
+
+// WriteMessage sends data as a single message, compressed when
+// permessage-deflate was negotiated.
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	if c.compress {
+		compressed, err := deflate(data)
+		if err != nil {
+			return err
+		}
+		return c.writeFrame(byte(typ), compressed, true)
+	}
+	return c.writeFrame(byte(typ), data, false)
+}
+
+// NextWriter returns a writer for a message sent as a sequence of
+// fragments: each Write becomes a frame and Close sends the final one.
+// Other messages must not be written until it is closed. Fragmented
+// messages aren't compressed.
+func (c *Conn) NextWriter(typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	return &fragmentWriter{c: c, opcode: byte(typ)}, nil
+}
+
+// fragmentWriter sends a message one frame per Write.
+type fragmentWriter struct {
+	c      *Conn
+	opcode byte
+	closed bool
+}
+
+func (f *fragmentWriter) Write(p []byte) (int, error) {
+	if f.closed {
+		return 0, ErrClosed
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if err := f.c.writeFragment(f.opcode, p, false); err != nil {
+		return 0, err
+	}
+	f.opcode = opContinuation
+	return len(p), nil
+}
+
+func (f *fragmentWriter) Close() error {
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.c.writeFragment(f.opcode, nil, true)
+}
+
+// Ping sends a ping with the given application data, at most 125 bytes.
+// The peer's pong is consumed by ReadMessage.
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > maxControlPayload {
+		return fmt.Errorf("websocket: ping payload over %d bytes", maxControlPayload)
+	}
+	return c.writeFrame(opPing, data, false)
+}
+
+// Close starts the close handshake with the given status and reason and
+// closes the connection once the peer answers or closeTimeout passes. If a
+// goroutine is blocked in ReadMessage, it sees the peer's answer and
+// returns a *CloseError.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.sendClose(code, reason); err != nil {
+		c.conn.Close()
+		if errors.Is(err, ErrClosed) {
+			return nil
+		}
+		return err
+	}
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+	if !c.rmu.TryLock() {
+		// The active reader finishes the handshake.
+		return nil
+	}
+	defer c.rmu.Unlock()
+	for c.readErr == nil {
+		if _, _, err := c.readMessage(); err != nil {
+			c.readErr = c.fail(err)
+		}
+	}
+	// Reading the peer's close frame already closed the connection.
+	if err := c.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+// sendClose writes a close frame unless one was already sent.
+func (c *Conn) sendClose(code int, reason string) error {
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	if len(payload) > maxControlPayload {
+		return fmt.Errorf("websocket: close reason over %d bytes", maxControlPayload-2)
+	}
+	return c.writeFrame(opClose, payload, false)
+}
+
+// writeFrame sends a complete single-frame message or control frame.
+func (c *Conn) writeFrame(opcode byte, payload []byte, compressed bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	if opcode == opClose {
+		c.closeSent = true
+	}
+	return c.writeFrameLocked(opcode, payload, true, compressed)
+}
+
+// writeFragment sends one frame of a fragmented message.
+func (c *Conn) writeFragment(opcode byte, payload []byte, fin bool) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrClosed
+	}
+	return c.writeFrameLocked(opcode, payload, fin, false)
+}
+
+// writeFrameLocked encodes a frame. Server frames are never masked.
+func (c *Conn) writeFrameLocked(opcode byte, payload []byte, fin, compressed bool) error {
+	header := make([]byte, 2, 10+len(payload))
+	header[0] = opcode
+	if fin {
+		header[0] |= 0x80
+	}
+	if compressed {
+		header[0] |= 0x40
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		header[1] = byte(n)
+	case n <= 0xffff:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+	_, err := c.conn.Write(append(header, payload...))
+	return err
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/url"
+	"strings"
+
+	"httpfromtcp/internal/headers"
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+)
+
+// acceptGUID is appended to the client's key to derive Sec-WebSocket-Accept
+// (RFC 6455 section 4.2.2).
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// DefaultMaxMessageSize bounds incoming messages when Options.MaxMessageSize
+// is zero.
+const DefaultMaxMessageSize = 1 << 20
+
+// HandshakeError is returned by Upgrade when the request isn't a valid
+// WebSocket opening handshake. Upgrade has already answered it with Status.
+type HandshakeError struct {
+	Status response.StatusCode
+	Reason string
+}
+
+func (e *HandshakeError) Error() string {
+	return "websocket: " + e.Reason
+}
+
+// Options configures Upgrade.
+type Options struct {
+	// Subprotocols lists the application protocols the server speaks, in
+	// order of preference. The first one the client also offers is chosen.
+	Subprotocols []string
+	// CheckOrigin decides whether to accept a request from a browser page.
+	// When nil, requests whose Origin names a different host than the Host
+	// header are refused, which stops other sites from opening connections
+	// with the user's cookies.
+	CheckOrigin func(req *request.Request) bool
+	// EnableCompression negotiates permessage-deflate (RFC 7692) when the
+	// client offers it.
+	EnableCompression bool
+	// MaxMessageSize is the largest message accepted, after decompression.
+	// Bigger ones close the connection with CloseMessageTooBig. Zero means
+	// DefaultMaxMessageSize.
+	MaxMessageSize int64
+}
+
+// Upgrade completes the opening handshake for req, sends the 101 response
+// and takes over the connection. The handler must not have written anything
+// else. On failure it answers the request itself and returns a
+// *HandshakeError, so the handler can simply return.
+func Upgrade(w *response.Writer, req *request.Request, opts Options) (*Conn, error) {
+	if opts.MaxMessageSize == 0 {
+		opts.MaxMessageSize = DefaultMaxMessageSize
+	}
+	if req.RequestLine.Method != "GET" {
+		w.Header().Set("Allow", "GET")
+		return nil, fail(w, response.StatusMethodNotAllowed, "handshake must use GET")
+	}
+	if !hasToken(req.Headers.Get("Connection"), "upgrade") || !hasToken(req.Headers.Get("Upgrade"), "websocket") {
+		return nil, fail(w, response.StatusBadRequest, "not a websocket upgrade request")
+	}
+	if req.Headers.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, fail(w, response.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := strings.TrimSpace(req.Headers.Get("Sec-WebSocket-Key"))
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		return nil, fail(w, response.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := opts.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(req) {
+		return nil, fail(w, response.StatusForbidden, "origin not allowed")
+	}
+
+	h := headers.NewHeaders()
+	h.Set("Upgrade", "websocket")
+	h.Set("Connection", "Upgrade")
+	h.Set("Sec-WebSocket-Accept", acceptKey(key))
+	subprotocol := chooseSubprotocol(req.Headers.Get("Sec-WebSocket-Protocol"), opts.Subprotocols)
+	if subprotocol != "" {
+		h.Set("Sec-WebSocket-Protocol", subprotocol)
+	}
+	compress := opts.EnableCompression && acceptDeflate(req.Headers.Get("Sec-WebSocket-Extensions"))
+	if compress {
+		// Without context takeover every message is compressed on its own,
+		// which keeps per-connection memory flat.
+		h.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	if err := w.WriteStatusLine(response.StatusSwitchingProtocols); err != nil {
+		return nil, err
+	}
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+	netConn, br, err := w.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	return newConn(netConn, br, subprotocol, compress, opts.MaxMessageSize), nil
+}
+
+// fail answers a rejected handshake and returns the matching error.
+func fail(w *response.Writer, status response.StatusCode, reason string) error {
+	w.SetStatus(status)
+	w.Write([]byte(reason + "\n"))
+	return &HandshakeError{Status: status, Reason: reason}
+}
+
+// acceptKey derives Sec-WebSocket-Accept from the client's key.
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// hasToken reports whether the comma-separated header value contains token,
+// ignoring case.
+func hasToken(value, token string) bool {
+	for _, t := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(t), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin accepts requests without an Origin (non-browser clients) and
+// those whose Origin host matches Host.
+func sameOrigin(req *request.Request) bool {
+	origin := req.Headers.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, req.Headers.Get("Host"))
+}
+
+// chooseSubprotocol returns the first of the server's protocols that the
+// client offered, or "".
+func chooseSubprotocol(offered string, supported []string) string {
+	for _, p := range supported {
+		if hasToken(offered, p) {
+			return p
+		}
+	}
+	return ""
+}
+
+// acceptDeflate reports whether one of the client's extension offers is a
+// permessage-deflate we can satisfy. compress/flate always compresses with
+// the full 32 KiB window, so an offer limiting server_max_window_bits below
+// 15 is declined.
+func acceptDeflate(offers string) bool {
+	for _, offer := range strings.Split(offers, ",") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(p), "=")
+			switch strings.TrimSpace(name) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				ok = ok && strings.Trim(strings.TrimSpace(value), `"`) == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
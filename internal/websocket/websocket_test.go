@@ -0,0 +1,282 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"httpfromtcp/internal/request"
+	"httpfromtcp/internal/response"
+	"httpfromtcp/internal/server"
+)
+
+// startEcho runs a server that upgrades every request and echoes messages
+// back until the connection closes. The error ending each connection is
+// sent on errs unless an earlier one is still waiting there.
+func startEcho(t *testing.T, opts Options) (string, chan error) {
+	errs := make(chan error, 1)
+	report := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, opts)
+		if err != nil {
+			return
+		}
+		for {
+			typ, msg, err := conn.ReadMessage()
+			if err != nil {
+				report(err)
+				return
+			}
+			if err := conn.WriteMessage(typ, msg); err != nil {
+				report(err)
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Addr().String(), errs
+}
+
+// handshake dials addr and sends an opening handshake with extra header
+// lines. It returns the connection, a reader positioned after the response
+// headers, and the raw response head.
+func handshake(t *testing.T, addr, extra string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: %s\r\nUpgrade: websocket\r\nConnection: keep-alive, Upgrade\r\n"+
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n%s\r\n", addr, extra)
+	br := bufio.NewReader(conn)
+	var head strings.Builder
+	for {
+		line, err := br.ReadString('\n')
+		require.NoError(t, err)
+		head.WriteString(line)
+		if line == "\r\n" {
+			return conn, br, head.String()
+		}
+	}
+}
+
+// writeFrame sends a client frame, masked unless unmasked is set.
+func writeFrame(t *testing.T, conn net.Conn, opcode byte, fin, rsv1 bool, payload []byte, unmasked bool) {
+	b := []byte{opcode, 0}
+	if fin {
+		b[0] |= 0x80
+	}
+	if rsv1 {
+		b[0] |= 0x40
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		b[1] = byte(n)
+	case n <= 0xffff:
+		b[1] = 126
+		b = binary.BigEndian.AppendUint16(b, uint16(n))
+	default:
+		b[1] = 127
+		b = binary.BigEndian.AppendUint64(b, uint64(n))
+	}
+	if !unmasked {
+		b[1] |= 0x80
+		mask := []byte{1, 2, 3, 4}
+		b = append(b, mask...)
+		masked := make([]byte, len(payload))
+		for i := range payload {
+			masked[i] = payload[i] ^ mask[i%4]
+		}
+		payload = masked
+	}
+	_, err := conn.Write(append(b, payload...))
+	require.NoError(t, err)
+}
+
+type frame struct {
+	fin, rsv1 bool
+	opcode    byte
+	payload   []byte
+}
+
+func readFrame(t *testing.T, br *bufio.Reader) frame {
+	var b [8]byte
+	_, err := io.ReadFull(br, b[:2])
+	require.NoError(t, err)
+	require.Zero(t, b[1]&0x80, "server frames must not be masked")
+	f := frame{fin: b[0]&0x80 != 0, rsv1: b[0]&0x40 != 0, opcode: b[0] & 0x0f}
+	n := int(b[1] & 0x7f)
+	switch n {
+	case 126:
+		_, err = io.ReadFull(br, b[:2])
+		n = int(binary.BigEndian.Uint16(b[:2]))
+	case 127:
+		_, err = io.ReadFull(br, b[:8])
+		n = int(binary.BigEndian.Uint64(b[:8]))
+	}
+	require.NoError(t, err)
+	f.payload = make([]byte, n)
+	_, err = io.ReadFull(br, f.payload)
+	require.NoError(t, err)
+	return f
+}
+
+func closeCode(f frame) int {
+	return int(binary.BigEndian.Uint16(f.payload))
+}
+
+func TestHandshake(t *testing.T) {
+	addr, _ := startEcho(t, Options{Subprotocols: []string{"chat", "superchat"}})
+
+	// Test: Accept key from RFC 6455 section 1.3 and subprotocol choice
+	_, _, head := handshake(t, addr, "Sec-WebSocket-Protocol: superchat, chat\r\n")
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"), head)
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.Contains(t, head, "sec-websocket-protocol: chat\r\n")
+	assert.NotContains(t, head, "content-length")
+
+	// Test: Unsupported version is 426 with the version we speak
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: x\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 8\r\n\r\n")
+	resp, _ := io.ReadAll(conn)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 426 Upgrade Required\r\n"))
+	assert.Contains(t, string(resp), "sec-websocket-version: 13\r\n")
+
+	// Test: Cross-origin requests are refused by default
+	conn, err = net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nOrigin: https://evil.example\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n", addr)
+	resp, _ = io.ReadAll(conn)
+	assert.True(t, strings.HasPrefix(string(resp), "HTTP/1.1 403 Forbidden\r\n"))
+
+	// Test: Key checks
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey("dGhlIHNhbXBsZSBub25jZQ=="))
+}
+
+func TestFraming(t *testing.T) {
+	addr, errs := startEcho(t, Options{MaxMessageSize: 64})
+
+	// Test: Echo of a single-frame text message
+	conn, br, _ := handshake(t, addr, "")
+	writeFrame(t, conn, opText, true, false, []byte("hello"), false)
+	f := readFrame(t, br)
+	assert.Equal(t, frame{fin: true, opcode: opText, payload: []byte("hello")}, f)
+
+	// Test: Fragments with a ping in between are reassembled
+	writeFrame(t, conn, opBinary, false, false, []byte("ab"), false)
+	writeFrame(t, conn, opPing, true, false, []byte("p"), false)
+	writeFrame(t, conn, opContinuation, true, false, []byte("cd"), false)
+	assert.Equal(t, frame{fin: true, opcode: opPong, payload: []byte("p")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: true, opcode: opBinary, payload: []byte("abcd")}, readFrame(t, br))
+
+	// Test: Close handshake echoes the status
+	writeFrame(t, conn, opClose, true, false, []byte{0x03, 0xe8, 'b', 'y', 'e'}, false)
+	f = readFrame(t, br)
+	assert.Equal(t, byte(opClose), f.opcode)
+	assert.Equal(t, CloseNormal, closeCode(f))
+	_, err := br.ReadByte()
+	assert.Equal(t, io.EOF, err, "server closes TCP after the handshake")
+	assert.Equal(t, &CloseError{Code: CloseNormal, Reason: "bye"}, <-errs)
+
+	// Test: Protocol violations close with the matching status
+	for _, tc := range []struct {
+		name string
+		send func(conn net.Conn)
+		code int
+	}{
+		{"unmasked", func(c net.Conn) { writeFrame(t, c, opText, true, false, []byte("x"), true) }, CloseProtocolError},
+		{"too big", func(c net.Conn) { writeFrame(t, c, opText, true, false, make([]byte, 65), false) }, CloseMessageTooBig},
+		{"bad utf8", func(c net.Conn) { writeFrame(t, c, opText, true, false, []byte{0xff}, false) }, CloseInvalidPayload},
+		{"stray continuation", func(c net.Conn) { writeFrame(t, c, opContinuation, true, false, []byte("x"), false) }, CloseProtocolError},
+		{"compressed without deflate", func(c net.Conn) { writeFrame(t, c, opText, true, true, []byte("x"), false) }, CloseProtocolError},
+	} {
+		conn, br, _ := handshake(t, addr, "")
+		tc.send(conn)
+		f := readFrame(t, br)
+		assert.Equal(t, byte(opClose), f.opcode, tc.name)
+		assert.Equal(t, tc.code, closeCode(f), tc.name)
+		err := <-errs
+		var ce *CloseError
+		require.ErrorAs(t, err, &ce, tc.name)
+		assert.Equal(t, tc.code, ce.Code, tc.name)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	addr, _ := startEcho(t, Options{EnableCompression: true})
+
+	// Test: permessage-deflate is negotiated when offered
+	conn, br, head := handshake(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	assert.Contains(t, head, "sec-websocket-extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+
+	// Test: Compressed messages are inflated and replies compressed
+	msg := strings.Repeat("compress me ", 50)
+	payload, err := deflate([]byte(msg))
+	require.NoError(t, err)
+	assert.Less(t, len(payload), len(msg))
+	writeFrame(t, conn, opText, true, true, payload, false)
+	f := readFrame(t, br)
+	assert.True(t, f.rsv1)
+	out, err := inflate(f.payload, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, msg, string(out))
+
+	// Test: Uncompressed messages still work
+	writeFrame(t, conn, opText, true, false, []byte("plain"), false)
+	f = readFrame(t, br)
+	out, err = inflate(f.payload, 1<<20)
+	require.NoError(t, err)
+	assert.Equal(t, "plain", string(out))
+
+	// Test: Offers we can't honour are declined
+	_, _, head = handshake(t, addr, "Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n")
+	assert.NotContains(t, head, "sec-websocket-extensions")
+}
+
+func TestServerClose(t *testing.T) {
+	done := make(chan error, 1)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := Upgrade(w, req, Options{})
+		if err != nil {
+			done <- err
+			return
+		}
+		writer, _ := conn.NextWriter(TextMessage)
+		io.WriteString(writer, "frag")
+		io.WriteString(writer, "ments")
+		writer.Close()
+		done <- conn.Close(CloseGoingAway, "shutdown")
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: NextWriter sends fragments, then Close waits for our reply
+	conn, br, _ := handshake(t, s.Addr().String(), "")
+	assert.Equal(t, frame{fin: false, opcode: opText, payload: []byte("frag")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: false, opcode: opContinuation, payload: []byte("ments")}, readFrame(t, br))
+	assert.Equal(t, frame{fin: true, opcode: opContinuation, payload: []byte{}}, readFrame(t, br))
+	f := readFrame(t, br)
+	assert.Equal(t, byte(opClose), f.opcode)
+	assert.Equal(t, CloseGoingAway, closeCode(f))
+	assert.Equal(t, "shutdown", string(f.payload[2:]))
+	writeFrame(t, conn, opClose, true, false, f.payload[:2], false)
+	require.NoError(t, <-done)
+	_, err = br.ReadByte()
+	assert.Equal(t, io.EOF, err)
+}